@@ -0,0 +1,36 @@
+package core
+
+import (
+	"math/big"
+)
+
+const depthFloatPrec = 256
+
+// V2AmountInForPriceMove returns the input, fee included, that raises the price of the output token
+// (in input token units) of a constant product pool by priceFactor, e.g. 1.05 for +5%.
+// The input reserve has to grow by sqrt(priceFactor) since the price scales with its square.
+func V2AmountInForPriceMove(reserveIn *big.Int, feePips uint32, priceFactor *big.Float) *big.Int {
+	if reserveIn.Sign() <= 0 || priceFactor.Cmp(big.NewFloat(1)) <= 0 {
+		return big.NewInt(0)
+	}
+
+	growth := new(big.Float).SetPrec(depthFloatPrec).Sqrt(new(big.Float).SetPrec(depthFloatPrec).Set(priceFactor))
+	growth.Sub(growth, big.NewFloat(1))
+
+	amountIn := new(big.Float).SetPrec(depthFloatPrec).SetInt(reserveIn)
+	amountIn.Mul(amountIn, growth)
+	amountIn.Mul(amountIn, big.NewFloat(v3FeeBase))
+	amountIn.Quo(amountIn, big.NewFloat(float64(v3FeeBase-feePips)))
+
+	result, _ := amountIn.Int(nil)
+	return result
+}
+
+// ScaleSqrtPriceX96 multiplies a sqrt price by sqrt(priceFactor), the resulting price moves by priceFactor.
+func ScaleSqrtPriceX96(sqrtPriceX96 *big.Int, priceFactor *big.Float) *big.Int {
+	scaled := new(big.Float).SetPrec(depthFloatPrec).SetInt(sqrtPriceX96)
+	scaled.Mul(scaled, new(big.Float).SetPrec(depthFloatPrec).Sqrt(new(big.Float).SetPrec(depthFloatPrec).Set(priceFactor)))
+
+	result, _ := scaled.Int(nil)
+	return result
+}
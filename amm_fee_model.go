@@ -0,0 +1,108 @@
+package core
+
+import (
+	"errors"
+	"math/big"
+)
+
+var ErrInsufficientReserve = errors.New("pool reserve cannot fill the amount")
+
+// AmmFeeModel prices a constant product pool of one dex, its fee is taken from the input
+// in hundredths of a bip like V3PoolState.Fee, e.g. 3000 for uniswap v2 and 2500 for pancakeswap v2.
+// The integer maths matches the UniswapV2Library quotes on chain.
+type AmmFeeModel struct {
+	FeePips uint32
+}
+
+// TransferTax is the tax of a fee-on-transfer token as a fraction of Base. In is taken from what is sent into
+// the pool, Out from what the pool sends out, a zero Base means no tax.
+type TransferTax struct {
+	In   int64
+	Out  int64
+	Base int64
+}
+
+// AmountOut returns what the recipient gets for amountIn, dex fee and taxes included.
+func (m AmmFeeModel) AmountOut(amountIn, reserveIn, reserveOut *big.Int, tax TransferTax) *big.Int {
+	if amountIn == nil || amountIn.Sign() <= 0 || reserveIn.Sign() <= 0 || reserveOut.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+
+	netIn := tax.deduct(amountIn, tax.In)
+
+	amountInWithFee := new(big.Int).Mul(netIn, big.NewInt(int64(v3FeeBase-m.FeePips)))
+	numerator := new(big.Int).Mul(amountInWithFee, reserveOut)
+	denominator := new(big.Int).Mul(reserveIn, big.NewInt(v3FeeBase))
+	denominator.Add(denominator, amountInWithFee)
+
+	return tax.deduct(numerator.Div(numerator, denominator), tax.Out)
+}
+
+// AmountIn returns the input needed for the recipient to get amountOut, dex fee and taxes included.
+func (m AmmFeeModel) AmountIn(amountOut, reserveIn, reserveOut *big.Int, tax TransferTax) (*big.Int, error) {
+	if amountOut == nil || amountOut.Sign() <= 0 {
+		return big.NewInt(0), nil
+	}
+
+	if tax.Base > 0 && (tax.In >= tax.Base || tax.Out >= tax.Base) {
+		return nil, ErrInsufficientReserve
+	}
+
+	grossOut := tax.grossUp(amountOut, tax.Out)
+	if reserveIn.Sign() <= 0 || grossOut.Cmp(reserveOut) >= 0 {
+		return nil, ErrInsufficientReserve
+	}
+
+	numerator := new(big.Int).Mul(reserveIn, grossOut)
+	numerator.Mul(numerator, big.NewInt(v3FeeBase))
+	denominator := new(big.Int).Sub(reserveOut, grossOut)
+	denominator.Mul(denominator, big.NewInt(int64(v3FeeBase-m.FeePips)))
+
+	amountIn := numerator.Div(numerator, denominator)
+	amountIn.Add(amountIn, big.NewInt(1))
+	return tax.grossUp(amountIn, tax.In), nil
+}
+
+// ReceivedOut returns what is left of amount sent out by the pool once Out has been taken.
+func (t TransferTax) ReceivedOut(amount *big.Int) *big.Int {
+	return t.deduct(amount, t.Out)
+}
+
+// RequiredOut returns what the pool has to send out for amount to be left once Out has been taken.
+func (t TransferTax) RequiredOut(amount *big.Int) (*big.Int, error) {
+	if t.Base > 0 && t.Out >= t.Base {
+		return nil, ErrInsufficientReserve
+	}
+	return t.grossUp(amount, t.Out), nil
+}
+
+// SentIn returns what reaches the pool of amount once In has been taken.
+func (t TransferTax) SentIn(amount *big.Int) *big.Int {
+	return t.deduct(amount, t.In)
+}
+
+// RequiredIn returns what has to be sent for amount to reach the pool once In has been taken.
+func (t TransferTax) RequiredIn(amount *big.Int) (*big.Int, error) {
+	if t.Base > 0 && t.In >= t.Base {
+		return nil, ErrInsufficientReserve
+	}
+	return t.grossUp(amount, t.In), nil
+}
+
+func (t TransferTax) deduct(amount *big.Int, rate int64) *big.Int {
+	if t.Base <= 0 || rate <= 0 {
+		return amount
+	}
+
+	taxed := new(big.Int).Mul(amount, big.NewInt(t.Base-rate))
+	return taxed.Div(taxed, big.NewInt(t.Base))
+}
+
+// grossUp returns the smallest amount that still leaves amount once deduct has taxed it, rate has to be below Base.
+func (t TransferTax) grossUp(amount *big.Int, rate int64) *big.Int {
+	if t.Base <= 0 || rate <= 0 {
+		return amount
+	}
+
+	return divRoundingUp(new(big.Int).Mul(amount, big.NewInt(t.Base)), big.NewInt(t.Base-rate))
+}
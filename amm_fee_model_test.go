@@ -0,0 +1,194 @@
+package core
+
+import (
+	"math/big"
+	"testing"
+)
+
+func bigInt(t *testing.T, value string) *big.Int {
+	t.Helper()
+
+	amount, ok := new(big.Int).SetString(value, 10)
+	if !ok {
+		t.Fatalf("bad test amount %q", value)
+	}
+	return amount
+}
+
+var (
+	pancakeSwapV2FeeModel = AmmFeeModel{FeePips: 2500}
+	uniSwapV2FeeModel     = AmmFeeModel{FeePips: 3000}
+)
+
+// The expected amounts follow PancakeLibrary and UniswapV2Library getAmountOut and getAmountIn, which the routers'
+// getAmountsOut and getAmountsIn apply per hop: 9975/10000 of the input for pancakeswap and 997/1000 for uniswap.
+
+func TestAmmFeeModelAmountOut(t *testing.T) {
+	tests := []struct {
+		name       string
+		feeModel   AmmFeeModel
+		amountIn   string
+		reserveIn  string
+		reserveOut string
+		tax        TransferTax
+		want       string
+	}{
+		{
+			name:       "pancakeswap v2 buy",
+			feeModel:   pancakeSwapV2FeeModel,
+			amountIn:   "1000000000000000000",
+			reserveIn:  "1234567890123456789012",
+			reserveOut: "98765432109876543210987654",
+			want:       "79735576374276681239647",
+		},
+		{
+			name:       "pancakeswap v2 deep pool",
+			feeModel:   pancakeSwapV2FeeModel,
+			amountIn:   "500000000000000000",
+			reserveIn:  "35000000000000000000000",
+			reserveOut: "1000000000000000000000000000",
+			want:       "14249796940393599391208",
+		},
+		{
+			name:       "uniswap v2 buy",
+			feeModel:   uniSwapV2FeeModel,
+			amountIn:   "1000000000000000000",
+			reserveIn:  "1234567890123456789012",
+			reserveOut: "98765432109876543210987654",
+			want:       "79695640917498024450522",
+		},
+		{
+			name:       "uniswap v2 six decimals",
+			feeModel:   uniSwapV2FeeModel,
+			amountIn:   "3000000",
+			reserveIn:  "5000000000000",
+			reserveOut: "2000000000000000000000",
+			want:       "1196399284313948",
+		},
+		{
+			name:       "buy tax taken from the output",
+			feeModel:   pancakeSwapV2FeeModel,
+			amountIn:   "1000000000000000000",
+			reserveIn:  "1234567890123456789012",
+			reserveOut: "98765432109876543210987654",
+			tax:        TransferTax{Out: 300, Base: 10000},
+			want:       "77343509083048380802457",
+		},
+		{
+			name:       "sell tax taken from the input",
+			feeModel:   pancakeSwapV2FeeModel,
+			amountIn:   "1000000000000000000000000",
+			reserveIn:  "98765432109876543210987654",
+			reserveOut: "1234567890123456789012",
+			tax:        TransferTax{In: 500, Base: 10000},
+			want:       "11732740232696785141",
+		},
+		{
+			name:       "no input",
+			feeModel:   uniSwapV2FeeModel,
+			amountIn:   "0",
+			reserveIn:  "1234567890123456789012",
+			reserveOut: "98765432109876543210987654",
+			want:       "0",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.feeModel.AmountOut(bigInt(t, test.amountIn), bigInt(t, test.reserveIn), bigInt(t, test.reserveOut), test.tax)
+			if got.Cmp(bigInt(t, test.want)) != 0 {
+				t.Fatalf("AmountOut() = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAmmFeeModelAmountIn(t *testing.T) {
+	tests := []struct {
+		name       string
+		feeModel   AmmFeeModel
+		amountOut  string
+		reserveIn  string
+		reserveOut string
+		want       string
+		wantErr    bool
+	}{
+		{
+			name:       "pancakeswap v2 buy",
+			feeModel:   pancakeSwapV2FeeModel,
+			amountOut:  "50000000000000000000000",
+			reserveIn:  "1234567890123456789012",
+			reserveOut: "98765432109876543210987654",
+			want:       "626883770239161658",
+		},
+		{
+			name:       "pancakeswap v2 sell",
+			feeModel:   pancakeSwapV2FeeModel,
+			amountOut:  "1000000000000000000",
+			reserveIn:  "98765432109876543210987654",
+			reserveOut: "1234567890123456789012",
+			want:       "80265517053358262216962",
+		},
+		{
+			name:       "uniswap v2 buy",
+			feeModel:   uniSwapV2FeeModel,
+			amountOut:  "50000000000000000000000",
+			reserveIn:  "1234567890123456789012",
+			reserveOut: "98765432109876543210987654",
+			want:       "627198155279401959",
+		},
+		{
+			name:       "uniswap v2 rounds up",
+			feeModel:   uniSwapV2FeeModel,
+			amountOut:  "1000000000",
+			reserveIn:  "5000000000000",
+			reserveOut: "2000000000000000000000",
+			want:       "3",
+		},
+		{
+			name:       "output above the reserve",
+			feeModel:   uniSwapV2FeeModel,
+			amountOut:  "2000000000000000000000",
+			reserveIn:  "5000000000000",
+			reserveOut: "2000000000000000000000",
+			wantErr:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.feeModel.AmountIn(bigInt(t, test.amountOut), bigInt(t, test.reserveIn), bigInt(t, test.reserveOut), TransferTax{})
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("AmountIn() = %s, want ErrInsufficientReserve", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AmountIn() error = %v", err)
+			}
+			if got.Cmp(bigInt(t, test.want)) != 0 {
+				t.Fatalf("AmountIn() = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+// TestAmmFeeModelRoundTrip checks that AmountIn of an AmountOut quote buys at least that output, as on chain.
+func TestAmmFeeModelRoundTrip(t *testing.T) {
+	reserveIn := bigInt(t, "1234567890123456789012")
+	reserveOut := bigInt(t, "98765432109876543210987654")
+	tax := TransferTax{In: 100, Out: 300, Base: 10000}
+
+	for _, feeModel := range []AmmFeeModel{pancakeSwapV2FeeModel, uniSwapV2FeeModel} {
+		amountOut := feeModel.AmountOut(bigInt(t, "1000000000000000000"), reserveIn, reserveOut, tax)
+
+		amountIn, err := feeModel.AmountIn(amountOut, reserveIn, reserveOut, tax)
+		if err != nil {
+			t.Fatalf("fee %d: AmountIn() error = %v", feeModel.FeePips, err)
+		}
+		if got := feeModel.AmountOut(amountIn, reserveIn, reserveOut, tax); got.Cmp(amountOut) < 0 {
+			t.Fatalf("fee %d: AmountOut(AmountIn(%s)) = %s, want at least %s", feeModel.FeePips, amountOut, got, amountOut)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"errors"
+	"math/big"
+)
+
+const bondingCurveFeeBase = 10000
+
+var ErrCurveExhausted = errors.New("bonding curve cannot fill the amount")
+
+// BondingCurveState is a launchpad curve modelled as a constant product of virtual reserves.
+// The launchpad fee is charged on the native side of both buys and sells.
+type BondingCurveState struct {
+	VirtualNative *big.Int
+	VirtualToken  *big.Int
+	// TokensLeft is what can still be bought before the curve migrates.
+	TokensLeft *big.Int
+	// FeeRate is in bondingCurveFeeBase units, MinFee is the floor of a single trade's fee.
+	FeeRate uint32
+	MinFee  *big.Int
+}
+
+// CalibrateBondingCurve derives the virtual reserves from the current price and what is left on the curve.
+// With a the virtual native reserve, p = a/t the price and k = a*t, buying out tokensLeft for fundsLeft gives
+// a^2/p = (a + fundsLeft)(a/p - tokensLeft), hence a = fundsLeft*tokensLeft*p / (fundsLeft - tokensLeft*p).
+// priceE18 is native wei per token wei scaled by 1e18.
+func CalibrateBondingCurve(priceE18, fundsLeft, tokensLeft *big.Int) (*big.Int, *big.Int, error) {
+	if priceE18.Sign() <= 0 || fundsLeft.Sign() <= 0 || tokensLeft.Sign() <= 0 {
+		return nil, nil, ErrCurveExhausted
+	}
+
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	linearValue := new(big.Int).Mul(tokensLeft, priceE18)
+	denominator := new(big.Int).Sub(new(big.Int).Mul(fundsLeft, scale), linearValue)
+	if denominator.Sign() <= 0 {
+		return nil, nil, errors.New("bonding curve state is not convex")
+	}
+
+	virtualNative := new(big.Int).Mul(fundsLeft, linearValue)
+	virtualNative.Div(virtualNative, denominator)
+
+	virtualToken := new(big.Int).Mul(virtualNative, scale)
+	virtualToken.Div(virtualToken, priceE18)
+
+	return virtualNative, virtualToken, nil
+}
+
+// BondingCurveBuyOut returns the tokens bought for amountIn native, fee included.
+func BondingCurveBuyOut(state BondingCurveState, amountIn *big.Int) *big.Int {
+	net := new(big.Int).Sub(amountIn, curveFee(state, amountIn))
+	if net.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+
+	amountOut := new(big.Int).Mul(state.VirtualToken, net)
+	amountOut.Div(amountOut, new(big.Int).Add(state.VirtualNative, net))
+	if amountOut.Cmp(state.TokensLeft) > 0 {
+		return new(big.Int).Set(state.TokensLeft)
+	}
+	return amountOut
+}
+
+// BondingCurveBuyIn returns the native, fee included, needed to buy amountOut tokens.
+func BondingCurveBuyIn(state BondingCurveState, amountOut *big.Int) (*big.Int, error) {
+	if amountOut.Cmp(state.TokensLeft) > 0 || amountOut.Cmp(state.VirtualToken) >= 0 {
+		return nil, ErrCurveExhausted
+	}
+
+	net := divRoundingUp(
+		new(big.Int).Mul(state.VirtualNative, amountOut),
+		new(big.Int).Sub(state.VirtualToken, amountOut))
+	return grossUpForFee(state, net), nil
+}
+
+// BondingCurveSellOut returns the native received for amountIn tokens, fee deducted.
+func BondingCurveSellOut(state BondingCurveState, amountIn *big.Int) *big.Int {
+	gross := new(big.Int).Mul(state.VirtualNative, amountIn)
+	gross.Div(gross, new(big.Int).Add(state.VirtualToken, amountIn))
+
+	amountOut := gross.Sub(gross, curveFee(state, gross))
+	if amountOut.Sign() < 0 {
+		return big.NewInt(0)
+	}
+	return amountOut
+}
+
+// BondingCurveSellIn returns the tokens to sell for amountOut native after the fee.
+func BondingCurveSellIn(state BondingCurveState, amountOut *big.Int) (*big.Int, error) {
+	gross := grossUpForFee(state, amountOut)
+	if gross.Cmp(state.VirtualNative) >= 0 {
+		return nil, ErrCurveExhausted
+	}
+
+	return divRoundingUp(
+		new(big.Int).Mul(state.VirtualToken, gross),
+		new(big.Int).Sub(state.VirtualNative, gross)), nil
+}
+
+func curveFee(state BondingCurveState, amount *big.Int) *big.Int {
+	fee := new(big.Int).Mul(amount, big.NewInt(int64(state.FeeRate)))
+	fee.Div(fee, big.NewInt(bondingCurveFeeBase))
+	if state.MinFee != nil && fee.Cmp(state.MinFee) < 0 {
+		return new(big.Int).Set(state.MinFee)
+	}
+	return fee
+}
+
+// grossUpForFee returns the smallest amount that leaves net once curveFee is taken.
+func grossUpForFee(state BondingCurveState, net *big.Int) *big.Int {
+	gross := divRoundingUp(
+		new(big.Int).Mul(net, big.NewInt(bondingCurveFeeBase)),
+		big.NewInt(int64(bondingCurveFeeBase-state.FeeRate)))
+
+	if state.MinFee != nil && new(big.Int).Sub(gross, net).Cmp(state.MinFee) < 0 {
+		return new(big.Int).Add(net, state.MinFee)
+	}
+	return gross
+}
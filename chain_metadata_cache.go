@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/common/logger"
+	"github.com/cross-space-official/kaboom-service/common"
+	"github.com/cross-space-official/kaboom-service/model"
+	"github.com/cross-space-official/kaboom-service/repository"
+	"github.com/cross-space-official/kaboom-service/service/provider/evm"
+	"sync"
+	"time"
+)
+
+const chainMetadataTTL = 10 * time.Minute
+
+// ChainMetadata is what the services need to know about a chain, loaded together and refreshed after chainMetadataTTL.
+type ChainMetadata struct {
+	Chain               *model.Chain
+	NativeToken         *model.Token
+	KaboomRouterAddress string
+	// DexRouters are the plain dex routers by pair type, the built in ones overridden by the chain config.
+	DexRouters map[model.PairType]string
+	// SupplyExclusions are the wallets the chain config keeps out of circulating supply.
+	SupplyExclusions []SupplyExclusion
+	LoadedAt         time.Time
+}
+
+// chainMetadataLoad is a load in flight, callers for the same chain wait on done instead of loading again.
+type chainMetadataLoad struct {
+	done     chan struct{}
+	metadata *ChainMetadata
+	err      businesserror.XSpaceBusinessError
+}
+
+type chainMetadataCache struct {
+	gethService     evm.GethService
+	assetRepository repository.AssetRepository
+	ttl             time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*ChainMetadata
+	loads   map[string]*chainMetadataLoad
+}
+
+// Get returns the cached metadata of the chain, loading it once when missing or expired.
+// A failed refresh keeps serving the expired entry, a failed first load is an error.
+func (c *chainMetadataCache) Get(ctx context.Context, chainID string) (*ChainMetadata, businesserror.XSpaceBusinessError) {
+	c.mu.Lock()
+	entry := c.entries[chainID]
+	if entry != nil && time.Since(entry.LoadedAt) < c.ttl {
+		c.mu.Unlock()
+		return entry, nil
+	}
+
+	load, inFlight := c.loads[chainID]
+	if !inFlight {
+		load = &chainMetadataLoad{done: make(chan struct{})}
+		c.loads[chainID] = load
+	}
+	c.mu.Unlock()
+
+	if !inFlight {
+		c.runLoad(chainID, load)
+	}
+
+	select {
+	case <-load.done:
+	case <-ctx.Done():
+		return nil, common.NewRuntimeError(ctx.Err())
+	}
+
+	if load.err != nil {
+		if entry != nil {
+			logger.GetLoggerEntry(ctx).
+				WithField("chain_id", chainID).
+				Errorf("error refreshing chain metadata, serving stale entry, %v", load.err)
+			return entry, nil
+		}
+		return nil, load.err
+	}
+	return load.metadata, nil
+}
+
+// runLoad loads the chain and releases the waiters, also when the load panics.
+func (c *chainMetadataCache) runLoad(chainID string, load *chainMetadataLoad) {
+	defer func() {
+		if r := recover(); r != nil {
+			load.metadata = nil
+			load.err = common.NewRuntimeError(fmt.Errorf("loading chain %s metadata panicked, %v", chainID, r))
+		}
+
+		c.mu.Lock()
+		if load.err == nil {
+			c.entries[chainID] = load.metadata
+		}
+		delete(c.loads, chainID)
+		c.mu.Unlock()
+		close(load.done)
+	}()
+
+	// the load outlives a caller that gives up, the others are still waiting on it
+	load.metadata, load.err = c.load(context.Background(), chainID)
+}
+
+func (c *chainMetadataCache) NativeToken(ctx context.Context, chainID string) (*model.Token, businesserror.XSpaceBusinessError) {
+	metadata, err := c.Get(ctx, chainID)
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata.NativeToken, nil
+}
+
+func (c *chainMetadataCache) KaboomRouterAddress(ctx context.Context, chainID string) (string, businesserror.XSpaceBusinessError) {
+	metadata, err := c.Get(ctx, chainID)
+	if err != nil {
+		return "", err
+	}
+
+	return metadata.KaboomRouterAddress, nil
+}
+
+// DexRouterAddress is the plain dex router of the pair type, v2 and v3 alike.
+func (c *chainMetadataCache) DexRouterAddress(ctx context.Context, chainID string, pairType model.PairType) (string, businesserror.XSpaceBusinessError) {
+	metadata, err := c.Get(ctx, chainID)
+	if err != nil {
+		return "", err
+	}
+
+	address, ok := metadata.DexRouters[pairType]
+	if !ok {
+		return "", common.NewRuntimeError(fmt.Errorf("no dex router for chain %s and pair type %s", chainID, pairType))
+	}
+	return address, nil
+}
+
+// SupplyExclusions are the registered and the configured exclusions of the chain that apply to the token.
+func (c *chainMetadataCache) SupplyExclusions(ctx context.Context, chainID, tokenAddress string) ([]SupplyExclusion, businesserror.XSpaceBusinessError) {
+	metadata, err := c.Get(ctx, chainID)
+	if err != nil {
+		return nil, err
+	}
+
+	exclusions := getSupplyExclusions(chainID, tokenAddress)
+	for _, exclusion := range metadata.SupplyExclusions {
+		if exclusion.appliesTo(tokenAddress) {
+			exclusions = append(exclusions, exclusion)
+		}
+	}
+	return exclusions, nil
+}
+
+func (c *chainMetadataCache) load(ctx context.Context, chainID string) (*ChainMetadata, businesserror.XSpaceBusinessError) {
+	chain, err := c.assetRepository.RetrieveChainByID(ctx, chainID)
+	if err != nil {
+		return nil, err
+	}
+	if chain == nil || chain.NativeToken == nil {
+		return nil, common.NewRuntimeError(fmt.Errorf("chain %s has no native token", chainID))
+	}
+
+	routerAddress, err := c.gethService.GetKaboomRouterAddress(chainID)
+	if err != nil {
+		return nil, err
+	}
+
+	dexRouters := map[model.PairType]string{}
+	for pairType, address := range v2RouterAddresses[chainID] {
+		dexRouters[pairType] = address
+	}
+	for pairType, address := range v3SwapRouterAddresses[chainID] {
+		dexRouters[pairType] = address
+	}
+	for pairType, address := range chain.DexRouterAddresses {
+		dexRouters[model.PairType(pairType)] = address
+	}
+
+	supplyExclusions := make([]SupplyExclusion, 0, len(chain.SupplyExclusions))
+	for _, exclusion := range chain.SupplyExclusions {
+		supplyExclusions = append(supplyExclusions, SupplyExclusion{
+			Name:         exclusion.Name,
+			Address:      exclusion.Address,
+			TokenAddress: exclusion.TokenAddress,
+		})
+	}
+
+	return &ChainMetadata{
+		Chain:               chain,
+		NativeToken:         chain.NativeToken,
+		KaboomRouterAddress: routerAddress,
+		DexRouters:          dexRouters,
+		SupplyExclusions:    supplyExclusions,
+		LoadedAt:            time.Now(),
+	}, nil
+}
+
+func newChainMetadataCache(gethService evm.GethService, assetRepository repository.AssetRepository) *chainMetadataCache {
+	return &chainMetadataCache{
+		gethService:     gethService,
+		assetRepository: assetRepository,
+		ttl:             chainMetadataTTL,
+		entries:         map[string]*ChainMetadata{},
+		loads:           map[string]*chainMetadataLoad{},
+	}
+}
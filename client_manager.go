@@ -0,0 +1,227 @@
+package eventsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/common/logger"
+	"github.com/cross-space-official/kaboom-service/common"
+	"github.com/cross-space-official/kaboom-service/configs"
+	"sync"
+	"time"
+)
+
+const (
+	minRedialInterval = 5 * time.Second
+	maxRedialInterval = 5 * time.Minute
+	probeTimeout      = 10 * time.Second
+)
+
+// ChainUnavailableError is returned for chains whose client could not be dialed.
+type ChainUnavailableError struct {
+	ChainID string
+	Cause   error
+}
+
+func (e *ChainUnavailableError) Error() string {
+	return Redact(fmt.Sprintf("chain %s unavailable: %v", e.ChainID, e.Cause))
+}
+
+func (e *ChainUnavailableError) Unwrap() error {
+	return e.Cause
+}
+
+func IsChainUnavailable(err error) bool {
+	var target *ChainUnavailableError
+	return errors.As(err, &target)
+}
+
+type ChainHealth struct {
+	ChainID             string
+	Healthy             bool
+	LastError           string
+	LastDialAt          time.Time
+	LastHealthyAt       time.Time
+	ConsecutiveFailures int
+}
+
+type ClientManager interface {
+	GetClient(ctx context.Context, chainID string) (SyncClient, businesserror.XSpaceBusinessError)
+	ReportFailure(ctx context.Context, chainID string, err error)
+	Health() []ChainHealth
+	Stop()
+}
+
+type managedClient struct {
+	sync.Mutex
+	config     configs.OnchainClientConfig
+	client     *evmEventSyncClient
+	health     ChainHealth
+	nextDialAt time.Time
+}
+
+type clientManager struct {
+	mu      sync.RWMutex
+	clients map[string]*managedClient
+	stopped bool
+}
+
+func (m *clientManager) GetClient(ctx context.Context, chainID string) (SyncClient, businesserror.XSpaceBusinessError) {
+	m.mu.RLock()
+	entry, ok := m.clients[chainID]
+	stopped := m.stopped
+	m.mu.RUnlock()
+
+	if stopped {
+		return nil, common.NewRuntimeError(&ChainUnavailableError{ChainID: chainID, Cause: errors.New("client manager stopped")})
+	}
+
+	if !ok {
+		return nil, common.NewRuntimeError(&ChainUnavailableError{ChainID: chainID, Cause: errors.New("chain not configured")})
+	}
+
+	entry.Lock()
+	defer entry.Unlock()
+
+	// the client follows key rotations itself, see rotatingTransport
+	if entry.client != nil {
+		return entry.client, nil
+	}
+
+	now := time.Now()
+	if now.Before(entry.nextDialAt) {
+		return nil, common.NewRuntimeError(&ChainUnavailableError{ChainID: chainID, Cause: errors.New(entry.health.LastError)})
+	}
+
+	entry.health.LastDialAt = now
+	client, err := entry.dial(ctx)
+	if err != nil {
+		entry.markFailure(err)
+		logger.GetLoggerEntry(ctx).
+			WithField("chain_id", chainID).
+			Warnf("failed to dial chain client, retry after %s, %v", entry.nextDialAt.Format(time.RFC3339), err)
+		return nil, common.NewRuntimeError(&ChainUnavailableError{ChainID: chainID, Cause: RedactError(err)})
+	}
+
+	client.onFailure = func(err error) {
+		m.reportFailure(context.Background(), chainID, client, err)
+	}
+	entry.client = client
+	entry.health.Healthy = true
+	entry.health.LastError = ""
+	entry.health.LastHealthyAt = now
+	entry.health.ConsecutiveFailures = 0
+
+	return entry.client, nil
+}
+
+// ReportFailure drops the cached client of a chain so the next GetClient redials it.
+// Clients handed out by GetClient report their own failed log fetches.
+func (m *clientManager) ReportFailure(ctx context.Context, chainID string, err error) {
+	m.reportFailure(ctx, chainID, nil, err)
+}
+
+// reportFailure drops the cached client, or only failed when set, a late report from a replaced client is ignored.
+func (m *clientManager) reportFailure(ctx context.Context, chainID string, failed *evmEventSyncClient, err error) {
+	m.mu.RLock()
+	entry, ok := m.clients[chainID]
+	m.mu.RUnlock()
+	if !ok || err == nil {
+		return
+	}
+
+	entry.Lock()
+	defer entry.Unlock()
+
+	if failed != nil && entry.client != failed {
+		return
+	}
+	if entry.client != nil {
+		entry.client.Close()
+		entry.client = nil
+	}
+	entry.markFailure(err)
+
+	logger.GetLoggerEntry(ctx).
+		WithField("chain_id", chainID).
+		Warnf("chain client reported failure, %v", RedactError(err))
+}
+
+func (m *clientManager) Health() []ChainHealth {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	healths := make([]ChainHealth, 0, len(m.clients))
+	for _, entry := range m.clients {
+		entry.Lock()
+		healths = append(healths, entry.health)
+		entry.Unlock()
+	}
+
+	return healths
+}
+
+func (m *clientManager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stopped = true
+	for _, entry := range m.clients {
+		entry.Lock()
+		if entry.client != nil {
+			entry.client.Close()
+			entry.client = nil
+		}
+		entry.health.Healthy = false
+		entry.Unlock()
+	}
+}
+
+// dial connects to the chain and asks it for its chain id, an http dial alone succeeds even for unreachable hosts.
+func (c *managedClient) dial(ctx context.Context) (*evmEventSyncClient, error) {
+	client, err := dialEventSyncClient(c.config)
+	if err != nil {
+		return nil, err
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	chainID, probeErr := client.client.ChainID(probeCtx)
+	if probeErr == nil && chainID.String() != c.config.ChainID {
+		probeErr = fmt.Errorf("endpoint serves chain %s", chainID.String())
+	}
+	if probeErr != nil {
+		client.Close()
+		return nil, fmt.Errorf("probe chain id: %w", probeErr)
+	}
+
+	return client, nil
+}
+
+func (c *managedClient) markFailure(err error) {
+	c.health.Healthy = false
+	c.health.LastError = Redact(err.Error())
+	c.health.ConsecutiveFailures++
+
+	backoff := maxRedialInterval
+	if c.health.ConsecutiveFailures <= 6 {
+		backoff = minRedialInterval << uint(c.health.ConsecutiveFailures-1)
+	}
+	c.nextDialAt = time.Now().Add(backoff)
+}
+
+func NewClientManager(clientConfigs []configs.OnchainClientConfig) ClientManager {
+	clients := make(map[string]*managedClient, len(clientConfigs))
+	for _, config := range clientConfigs {
+		clients[config.ChainID] = &managedClient{
+			config: config,
+			health: ChainHealth{ChainID: config.ChainID},
+		}
+	}
+
+	return &clientManager{
+		clients: clients,
+	}
+}
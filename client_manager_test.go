@@ -0,0 +1,183 @@
+package eventsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/cross-space-official/kaboom-service/configs"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newChainIDServer answers eth_chainId with chainID and counts the calls it served.
+func newChainIDServer(t *testing.T, chainID string) (*httptest.Server, *int32) {
+	t.Helper()
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+
+		var request struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      request.ID,
+			"result":  chainID,
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &calls
+}
+
+func registerTestEndpoint(chainID, url string) {
+	RegisterEndpointDefinition(EndpointDefinition{ChainID: chainID, URLTemplate: url, AuthType: AuthTypeNone})
+}
+
+func TestClientManagerGetClientProbesChainID(t *testing.T) {
+	server, calls := newChainIDServer(t, "0x7a69")
+	registerTestEndpoint("31337", server.URL)
+
+	manager := NewClientManager([]configs.OnchainClientConfig{{ChainID: "31337"}})
+	defer manager.Stop()
+
+	client, err := manager.GetClient(context.Background(), "31337")
+	if err != nil {
+		t.Fatalf("GetClient() error = %v", err)
+	}
+	if client == nil {
+		t.Fatal("GetClient() returned no client")
+	}
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Fatalf("probe calls = %d, want 1", got)
+	}
+
+	health := manager.Health()
+	if len(health) != 1 || !health[0].Healthy {
+		t.Fatalf("Health() = %+v, want one healthy chain", health)
+	}
+
+	// the cached client is reused without another probe
+	if _, err := manager.GetClient(context.Background(), "31337"); err != nil {
+		t.Fatalf("second GetClient() error = %v", err)
+	}
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Fatalf("probe calls after reuse = %d, want 1", got)
+	}
+}
+
+func TestClientManagerGetClientRejectsWrongChain(t *testing.T) {
+	server, calls := newChainIDServer(t, "0x1")
+	registerTestEndpoint("31338", server.URL)
+
+	manager := NewClientManager([]configs.OnchainClientConfig{{ChainID: "31338"}})
+	defer manager.Stop()
+
+	_, err := manager.GetClient(context.Background(), "31338")
+	if err == nil {
+		t.Fatal("GetClient() succeeded against an endpoint of another chain")
+	}
+	if !IsChainUnavailable(err) {
+		t.Fatalf("GetClient() error = %v, want ChainUnavailableError", err)
+	}
+
+	health := manager.Health()
+	if len(health) != 1 || health[0].Healthy || health[0].ConsecutiveFailures != 1 {
+		t.Fatalf("Health() = %+v, want one unhealthy chain with one failure", health)
+	}
+
+	// inside the backoff the endpoint is not dialed again
+	if _, err := manager.GetClient(context.Background(), "31338"); err == nil {
+		t.Fatal("GetClient() succeeded inside the redial backoff")
+	}
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Fatalf("probe calls = %d, want 1", got)
+	}
+}
+
+func TestClientManagerGetClientUnreachableHost(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+	registerTestEndpoint("31339", url)
+
+	manager := NewClientManager([]configs.OnchainClientConfig{{ChainID: "31339"}})
+	defer manager.Stop()
+
+	if _, err := manager.GetClient(context.Background(), "31339"); !IsChainUnavailable(err) {
+		t.Fatalf("GetClient() error = %v, want ChainUnavailableError", err)
+	}
+	if health := manager.Health(); health[0].Healthy {
+		t.Fatalf("Health() = %+v, want unhealthy", health)
+	}
+}
+
+func TestClientManagerGetClientUnconfiguredChain(t *testing.T) {
+	manager := NewClientManager(nil)
+	defer manager.Stop()
+
+	if _, err := manager.GetClient(context.Background(), "1"); !IsChainUnavailable(err) {
+		t.Fatalf("GetClient() error = %v, want ChainUnavailableError", err)
+	}
+}
+
+func TestClientManagerReportFailureRedials(t *testing.T) {
+	server, calls := newChainIDServer(t, "0x7a6c")
+	registerTestEndpoint("31340", server.URL)
+
+	manager := NewClientManager([]configs.OnchainClientConfig{{ChainID: "31340"}})
+	defer manager.Stop()
+
+	if _, err := manager.GetClient(context.Background(), "31340"); err != nil {
+		t.Fatalf("GetClient() error = %v", err)
+	}
+
+	manager.ReportFailure(context.Background(), "31340", errors.New("connection reset"))
+	health := manager.Health()
+	if health[0].Healthy || health[0].LastError != "connection reset" {
+		t.Fatalf("Health() = %+v, want unhealthy with the reported error", health)
+	}
+	if _, err := manager.GetClient(context.Background(), "31340"); err == nil {
+		t.Fatal("GetClient() redialed inside the backoff")
+	}
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Fatalf("probe calls = %d, want 1", got)
+	}
+}
+
+func TestManagedClientMarkFailureBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{4, 40 * time.Second},
+		{6, 160 * time.Second},
+		{7, maxRedialInterval},
+		{20, maxRedialInterval},
+	}
+
+	for _, tt := range tests {
+		client := &managedClient{}
+		for i := 0; i < tt.failures; i++ {
+			client.markFailure(errors.New("dial failed"))
+		}
+
+		backoff := time.Until(client.nextDialAt)
+		if backoff > tt.want || backoff < tt.want-time.Second {
+			t.Errorf("after %d failures backoff = %s, want %s", tt.failures, backoff, tt.want)
+		}
+	}
+}
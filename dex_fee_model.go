@@ -0,0 +1,76 @@
+package service
+
+import (
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/kaboom-service/common"
+	"github.com/cross-space-official/kaboom-service/core"
+	"github.com/cross-space-official/kaboom-service/model"
+	"math/big"
+	"sync"
+)
+
+// defaultV2FeeModel prices a v2 fork without a registered model at the uniswap v2 fee.
+var defaultV2FeeModel = core.AmmFeeModel{FeePips: 3000}
+
+var (
+	v2FeeModelsMu sync.RWMutex
+	v2FeeModels   = map[model.PairType]core.AmmFeeModel{
+		model.PairTypePancakeSwapV2: {FeePips: 2500},
+		model.PairTypeUniSwapV2:     {FeePips: 3000},
+	}
+)
+
+// RegisterV2FeeModel sets the fee model of a v2 pair type, forks add theirs here.
+func RegisterV2FeeModel(pairType model.PairType, feeModel core.AmmFeeModel) {
+	v2FeeModelsMu.Lock()
+	defer v2FeeModelsMu.Unlock()
+
+	v2FeeModels[pairType] = feeModel
+}
+
+func getV2FeeModel(pairType model.PairType) core.AmmFeeModel {
+	v2FeeModelsMu.RLock()
+	defer v2FeeModelsMu.RUnlock()
+
+	feeModel, ok := v2FeeModels[pairType]
+	if !ok {
+		return defaultV2FeeModel
+	}
+	return feeModel
+}
+
+// buyTax and sellTax are the token's measured transfer taxes as seen by the pool.
+// Buying taxes what the pool sends out, selling what is sent into it.
+func buyTax(pair *model.DexPair) core.TransferTax {
+	return core.TransferTax{Out: pair.GetToken().BuyTax, Base: model.PercentageBase}
+}
+
+func sellTax(pair *model.DexPair) core.TransferTax {
+	return core.TransferTax{In: pair.GetToken().SellTax, Base: model.PercentageBase}
+}
+
+// v2BuyAmountOut and the other v2 quotes price the pair in its quote token with the fee model of its dex,
+// the amounts are what the wallet actually receives or has to send.
+func v2BuyAmountOut(pair *model.DexPair, quoteIn *big.Int) *big.Int {
+	return getV2FeeModel(pair.Type).AmountOut(quoteIn, pair.GetWNativeReserve(), pair.GetTokenReserve(), buyTax(pair))
+}
+
+func v2BuyAmountIn(pair *model.DexPair, tokenOut *big.Int) (*big.Int, businesserror.XSpaceBusinessError) {
+	amountIn, err := getV2FeeModel(pair.Type).AmountIn(tokenOut, pair.GetWNativeReserve(), pair.GetTokenReserve(), buyTax(pair))
+	if err != nil {
+		return nil, common.NewRuntimeError(err)
+	}
+	return amountIn, nil
+}
+
+func v2SellAmountOut(pair *model.DexPair, tokenIn *big.Int) *big.Int {
+	return getV2FeeModel(pair.Type).AmountOut(tokenIn, pair.GetTokenReserve(), pair.GetWNativeReserve(), sellTax(pair))
+}
+
+func v2SellAmountIn(pair *model.DexPair, quoteOut *big.Int) (*big.Int, businesserror.XSpaceBusinessError) {
+	amountIn, err := getV2FeeModel(pair.Type).AmountIn(quoteOut, pair.GetTokenReserve(), pair.GetWNativeReserve(), sellTax(pair))
+	if err != nil {
+		return nil, common.NewRuntimeError(err)
+	}
+	return amountIn, nil
+}
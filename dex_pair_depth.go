@@ -0,0 +1,283 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/kaboom-service/common"
+	"github.com/cross-space-official/kaboom-service/core"
+	"github.com/cross-space-official/kaboom-service/model"
+	"github.com/shopspring/decimal"
+	"math/big"
+	"time"
+)
+
+// liquidityDepthMoves are the price moves depth is reported for, in each direction.
+var liquidityDepthMoves = []decimal.Decimal{
+	decimal.RequireFromString("0.01"),
+	decimal.RequireFromString("0.02"),
+	decimal.RequireFromString("0.05"),
+	decimal.RequireFromString("0.10"),
+}
+
+type LiquidityDepthLevel struct {
+	Move decimal.Decimal `json:"move"`
+	// BuyInNative is the native spent, dex fee included, that raises the token price by Move.
+	BuyInNative *model.BigInt `json:"buyInNative,omitempty"`
+	// SellInNative is the spot value of the tokens sold, dex fee and sell tax included, that lowers the token price by Move.
+	SellInNative *model.BigInt `json:"sellInNative,omitempty"`
+}
+
+type LiquidityDepth struct {
+	Levels     []LiquidityDepthLevel `json:"levels"`
+	SellTax    int64                 `json:"sellTax"`
+	ComputedAt time.Time             `json:"computedAt"`
+}
+
+// BuyInNativeFor returns the native needed to raise the price by move, nil when the level is not tracked.
+func (d *LiquidityDepth) BuyInNativeFor(move decimal.Decimal) *big.Int {
+	for _, level := range d.Levels {
+		if level.Move.Equal(move) && level.BuyInNative != nil {
+			return bigIntOf(*level.BuyInNative)
+		}
+	}
+	return nil
+}
+
+func (d *LiquidityDepth) marshal() ([]byte, businesserror.XSpaceBusinessError) {
+	depth, basicErr := json.Marshal(d)
+	if basicErr != nil {
+		return nil, common.NewRuntimeError(basicErr)
+	}
+	return depth, nil
+}
+
+type liquidityDepthCalculator struct {
+	v3Pools     *v3PoolReader
+	quoteTokens *quoteTokenService
+	launchpads  *launchpadReader
+}
+
+// Compute walks the pool curve for each move in liquidityDepthMoves.
+// The buy tax is taken from the tokens received and does not change how far the pool price moves, so only the sell side is grossed up.
+func (l *liquidityDepthCalculator) Compute(ctx context.Context, pair *model.DexPair) (*LiquidityDepth, businesserror.XSpaceBusinessError) {
+	depth := &LiquidityDepth{
+		Levels:     []LiquidityDepthLevel{},
+		SellTax:    pair.GetToken().SellTax,
+		ComputedAt: time.Now(),
+	}
+
+	if isLaunchpadPairType(pair.Type) {
+		return l.launchpadDepth(ctx, pair, depth)
+	}
+
+	var snapshot *v3PoolSnapshot
+	if isV3PairType(pair.Type) {
+		var err businesserror.XSpaceBusinessError
+		snapshot, err = l.v3Pools.ReadPool(ctx, pair.ChainID, pair.ContractAddress)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, move := range liquidityDepthMoves {
+		up, _ := decimal.NewFromInt(1).Add(move).Float64()
+		down, _ := decimal.NewFromInt(1).Sub(move).Float64()
+
+		var buyIn, sellTokens *big.Int
+		var err businesserror.XSpaceBusinessError
+		if snapshot != nil {
+			buyIn, sellTokens, err = l.v3Depth(ctx, pair, snapshot, up, down)
+		} else {
+			buyIn, sellTokens, err = l.v2Depth(pair, up, down)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		level := LiquidityDepthLevel{Move: move}
+		if buyIn != nil {
+			buyIn, err = l.toNative(ctx, pair, buyIn)
+			if err != nil {
+				return nil, err
+			}
+			buyInNative := model.NewBigInt(*buyIn)
+			level.BuyInNative = &buyInNative
+		}
+
+		sellTokens = grossUpForTax(sellTokens, depth.SellTax)
+		if sellTokens != nil {
+			sellValue, err := l.tokenValueInNative(ctx, pair, snapshot, sellTokens)
+			if err != nil {
+				return nil, err
+			}
+			sellInNative := model.NewBigInt(*sellValue)
+			level.SellInNative = &sellInNative
+		}
+
+		depth.Levels = append(depth.Levels, level)
+	}
+
+	return depth, nil
+}
+
+// PriceImpact compares a quote against the spot price, the result includes the dex fee and token tax.
+// For buys amountIn is native and amountOut tokens, for sells the other way round.
+func (l *liquidityDepthCalculator) PriceImpact(ctx context.Context, pair *model.DexPair, isBuy bool, amountIn, amountOut *big.Int) (decimal.Decimal, businesserror.XSpaceBusinessError) {
+	if amountIn == nil || amountOut == nil || amountIn.Sign() <= 0 {
+		return decimal.Zero, nil
+	}
+
+	spotValue := amountIn
+	received := amountOut
+	var err businesserror.XSpaceBusinessError
+	if isBuy {
+		received, err = l.tokenValueInNative(ctx, pair, nil, amountOut)
+	} else {
+		spotValue, err = l.tokenValueInNative(ctx, pair, nil, amountIn)
+	}
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if spotValue.Sign() == 0 {
+		return decimal.Zero, nil
+	}
+
+	ratio := decimal.NewFromBigInt(received, 0).Div(decimal.NewFromBigInt(spotValue, 0))
+	return decimal.NewFromInt(1).Sub(ratio), nil
+}
+
+func (l *liquidityDepthCalculator) v2Depth(pair *model.DexPair, up, down float64) (*big.Int, *big.Int, businesserror.XSpaceBusinessError) {
+	feePips := getV2FeeModel(pair.Type).FeePips
+
+	// selling lowers the token price by down, i.e. raises the quote price in token units by 1/down
+	buyIn := core.V2AmountInForPriceMove(pair.GetWNativeReserve(), feePips, big.NewFloat(up))
+	sellTokens := core.V2AmountInForPriceMove(pair.GetTokenReserve(), feePips, big.NewFloat(1/down))
+
+	return buyIn, sellTokens, nil
+}
+
+// v3Depth scales the pool price, which is token1 per token0, so the direction flips when the token is token1.
+func (l *liquidityDepthCalculator) v3Depth(ctx context.Context, pair *model.DexPair, snapshot *v3PoolSnapshot, up, down float64) (*big.Int, *big.Int, businesserror.XSpaceBusinessError) {
+	buyFactor, sellFactor := up, down
+	if !isTokenToken0(pair) {
+		buyFactor, sellFactor = 1/up, 1/down
+	}
+
+	buyIn, err := l.v3Pools.SwapToPrice(ctx, pair, snapshot, !isTokenToken0(pair), big.NewFloat(buyFactor))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sellTokens, err := l.v3Pools.SwapToPrice(ctx, pair, snapshot, isTokenToken0(pair), big.NewFloat(sellFactor))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return buyIn, sellTokens, nil
+}
+
+// launchpadDepth walks the virtual constant product of the curve, a level the curve cannot reach before
+// migrating or unwinding is left empty.
+func (l *liquidityDepthCalculator) launchpadDepth(ctx context.Context, pair *model.DexPair, depth *LiquidityDepth) (*LiquidityDepth, businesserror.XSpaceBusinessError) {
+	curve, err := l.launchpads.ReadCurve(ctx, pair.ChainID, pair.Type, pair.GetToken().ContractAddress)
+	if err != nil {
+		return nil, err
+	}
+	if curve.Migrated {
+		return nil, common.NewRuntimeError(errLaunchpadMigrated)
+	}
+
+	// the curve fee is in bips, the dex math takes hundredths of a bip
+	feePips := curve.FeeRate * 100
+	fundsLeft := new(big.Int).Sub(curve.MaxFunds, curve.Funds)
+	sold := new(big.Int).Sub(curve.MaxOffers, curve.Offers)
+
+	for _, move := range liquidityDepthMoves {
+		up, _ := decimal.NewFromInt(1).Add(move).Float64()
+		down, _ := decimal.NewFromInt(1).Sub(move).Float64()
+		level := LiquidityDepthLevel{Move: move}
+
+		buyIn := core.V2AmountInForPriceMove(curve.VirtualNative, feePips, big.NewFloat(up))
+		if buyIn.Cmp(fundsLeft) <= 0 {
+			buyInNative := model.NewBigInt(*buyIn)
+			level.BuyInNative = &buyInNative
+		}
+
+		sellTokens := core.V2AmountInForPriceMove(curve.VirtualToken, feePips, big.NewFloat(1/down))
+		sellTokens = grossUpForTax(sellTokens, depth.SellTax)
+		if sellTokens != nil && sellTokens.Cmp(sold) <= 0 {
+			sellInNative := model.NewBigInt(*curve.SpotValue(sellTokens))
+			level.SellInNative = &sellInNative
+		}
+
+		depth.Levels = append(depth.Levels, level)
+	}
+
+	return depth, nil
+}
+
+// tokenValueInNative values tokens at the pool spot price, snapshot is read when nil for v3 pairs.
+func (l *liquidityDepthCalculator) tokenValueInNative(ctx context.Context, pair *model.DexPair, snapshot *v3PoolSnapshot, amount *big.Int) (*big.Int, businesserror.XSpaceBusinessError) {
+	if isLaunchpadPairType(pair.Type) {
+		curve, err := l.launchpads.ReadCurve(ctx, pair.ChainID, pair.Type, pair.GetToken().ContractAddress)
+		if err != nil {
+			return nil, err
+		}
+		return curve.SpotValue(amount), nil
+	}
+
+	if isV3PairType(pair.Type) {
+		if snapshot == nil {
+			var err businesserror.XSpaceBusinessError
+			snapshot, err = l.v3Pools.ReadPool(ctx, pair.ChainID, pair.ContractAddress)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		price := core.V3SpotPrice(snapshot.SqrtPriceX96)
+		if !isTokenToken0(pair) {
+			price = new(big.Rat).Inv(price)
+		}
+
+		// the spot price is in quote token wei, like the v2 reserves below
+		value := new(big.Rat).Mul(new(big.Rat).SetInt(amount), price)
+		return l.toNative(ctx, pair, new(big.Int).Quo(value.Num(), value.Denom()))
+	}
+
+	tokenReserve := pair.GetTokenReserve()
+	if tokenReserve.Sign() == 0 {
+		return nil, common.NewRuntimeError(errors.New("pool has no token reserve"))
+	}
+
+	value := new(big.Int).Mul(amount, pair.GetWNativeReserve())
+	return l.toNative(ctx, pair, value.Div(value, tokenReserve))
+}
+
+func (l *liquidityDepthCalculator) toNative(ctx context.Context, pair *model.DexPair, amount *big.Int) (*big.Int, businesserror.XSpaceBusinessError) {
+	if isNativeQuoted(pair) {
+		return amount, nil
+	}
+
+	return l.quoteTokens.QuoteToNative(ctx, pair.ChainID, getQuoteToken(pair).ContractAddress, amount)
+}
+
+// grossUpForTax returns the amount to send so that amount arrives after tax, nil when the tax takes everything.
+func grossUpForTax(amount *big.Int, tax int64) *big.Int {
+	if amount == nil || tax >= model.PercentageBase {
+		return nil
+	}
+
+	gross := new(big.Int).Mul(amount, big.NewInt(model.PercentageBase))
+	return gross.Div(gross, big.NewInt(model.PercentageBase-tax))
+}
+
+func newLiquidityDepthCalculator(v3Pools *v3PoolReader, quoteTokens *quoteTokenService, launchpads *launchpadReader) *liquidityDepthCalculator {
+	return &liquidityDepthCalculator{
+		v3Pools:     v3Pools,
+		quoteTokens: quoteTokens,
+		launchpads:  launchpads,
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 type (
@@ -27,181 +28,829 @@ type (
 		gethService     evm.GethService
 		uploadService   repository.UploadRepository
 		assetRepository repository.AssetRepository
-		nativeTokenMap  map[string]*model.Token
+		chains          *chainMetadataCache
+
+		publishThresholds PublishThresholds
+		v3Pools           *v3PoolReader
+		quoteTokens       *quoteTokenService
+		safetySimulator   *tokenSafetySimulator
+		riskAnalyzer      *tokenRiskAnalyzer
+		lpLocks           *lpLockReader
+		usdOracle         *usdPriceOracle
+		depth             *liquidityDepthCalculator
+		iconResolver      *tokenIconResolver
+		metadataReader    *tokenMetadataReader
+		launchpads        *launchpadReader
+		provenance        *pairProvenanceVerifier
+		creationLocks     *pairCreationLocks
+		supply            *circulatingSupplyReader
+		holders           *tokenHolderCounter
+		reviews           *pairReviewMachine
+	}
+
+	pairOnchainState struct {
+		Token0   common2.Address
+		Token1   common2.Address
+		Reserve0 *big.Int
+		Reserve1 *big.Int
+		FeeTier  uint32
 	}
 )
 
-func (d *dexEvmPairService) PublishPairs(ctx context.Context, chainID, pairType string, pairAddresses []string) businesserror.XSpaceBusinessError {
-	//TODO implement me
-	panic("implement me")
+func isSupportedPairType(pairType model.PairType) bool {
+	return pairType == model.PairTypePancakeSwapV2 || pairType == model.PairTypeUniSwapV2 ||
+		isV3PairType(pairType) || isLaunchpadPairType(pairType)
+}
+
+func (d *dexEvmPairService) PublishPairs(ctx context.Context, chainID, pairType string, pairAddresses []string) ([]*PublishPairResult, businesserror.XSpaceBusinessError) {
+	if !isSupportedPairType(model.PairType(pairType)) {
+		return nil, common.NewRuntimeError(errors.New(common.InvalidPairType))
+	}
+
+	results := make([]*PublishPairResult, 0, len(pairAddresses))
+	seen := map[string]bool{}
+	for _, pairAddress := range pairAddresses {
+		if !common2.IsHexAddress(pairAddress) {
+			results = append(results, &PublishPairResult{PairAddress: pairAddress, Status: PublishPairStatusFailed, Reason: "invalid address"})
+			continue
+		}
+
+		pairAddress = common2.HexToAddress(pairAddress).Hex()
+		if seen[pairAddress] {
+			results = append(results, &PublishPairResult{PairAddress: pairAddress, Status: PublishPairStatusSkipped, Reason: "duplicate address"})
+			continue
+		}
+		seen[pairAddress] = true
+
+		result := d.publishPair(ctx, chainID, pairType, pairAddress)
+		if result.Status == PublishPairStatusFailed {
+			logger.GetLoggerEntry(ctx).
+				WithField("chain_id", chainID).
+				WithField("pair_address", pairAddress).
+				Warnf("failed to publish pair, %s", result.Reason)
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func (d *dexEvmPairService) publishPair(ctx context.Context, chainID, pairType, pairAddress string) *PublishPairResult {
+	result := &PublishPairResult{PairAddress: pairAddress}
+
+	pair, err := d.assetRepository.TryRetrievePairByContractAddress(ctx, chainID, pairAddress)
+	if err != nil {
+		return result.fail(err)
+	}
+
+	created := pair == nil
+	if created {
+		pair, err = d.createPairFromAddress(ctx, chainID, pairType, pairAddress, false)
+		if err != nil {
+			return result.fail(err)
+		}
+	} else {
+		err = d.SyncPair(ctx, pair)
+		if err != nil {
+			return result.fail(err)
+		}
+	}
+	result.PairID = pair.ID
+
+	switch reviewStateOf(pair) {
+	case PairReviewStatePublished:
+		return result.skip("already published")
+	case PairReviewStatePendingReview:
+		return result.skip("already pending review")
+	case PairReviewStateRejected:
+		// creation has just screened it
+		if created {
+			return result.skip(pair.ReviewReason)
+		}
+	}
+
+	reason, err := d.advanceReview(ctx, pair, true)
+	if err != nil {
+		return result.fail(err)
+	}
+	if len(reason) > 0 {
+		return result.skip(reason)
+	}
+
+	result.Status = PublishPairStatusPendingReview
+	return result
+}
+
+// PairPreview is what CreatePairFromAddress would write, Warnings lists failed reads and checks the pair would not pass.
+type PairPreview struct {
+	Pair     *model.DexPair
+	Token    *model.Token
+	Safety   *TokenSafetyResult
+	Warnings []string
+}
+
+// pairDraft is a pair built from chain state and not yet persisted.
+type pairDraft struct {
+	Pair          *model.DexPair
+	Token         model.Token
+	QuoteToken    *model.Token
+	ExistingToken bool
 }
 
+// CreatePairFromAddress adds a pair by hand, it is screened and queued for review like a discovered one.
 func (d *dexEvmPairService) CreatePairFromAddress(ctx context.Context, chainID, pairType, pairAddress string) businesserror.XSpaceBusinessError {
-	if pairType != model.PairTypePancakeSwapV2 && pairType != model.PairTypeUniSwapV2 {
-		return common.NewRuntimeError(errors.New(common.InvalidPairType))
+	_, err := d.createPairFromAddress(ctx, chainID, pairType, pairAddress, true)
+	return err
+}
+
+// PreviewPairFromAddress runs every read CreatePairFromAddress does, safety simulation included, without writing anything.
+func (d *dexEvmPairService) PreviewPairFromAddress(ctx context.Context, chainID, pairType, pairAddress string) (*PairPreview, businesserror.XSpaceBusinessError) {
+	draft, err := d.buildPair(ctx, chainID, pairType, pairAddress)
+	if err != nil {
+		return nil, err
 	}
 
-	client, err := d.gethService.GetClient(chainID)
+	preview := &PairPreview{Pair: draft.Pair, Warnings: []string{}}
+
+	existing, err := d.assetRepository.TryRetrievePairByContractAddress(ctx, chainID, pairAddress)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if existing != nil {
+		preview.Warnings = append(preview.Warnings, "pair already exists: "+existing.ID)
+	}
+	if draft.ExistingToken {
+		preview.Warnings = append(preview.Warnings, "token already exists: "+draft.Token.ID)
+	}
+	if !d.provenance.Verifiable(chainID, model.PairType(pairType)) {
+		preview.Warnings = append(preview.Warnings, "no factory configured, the pair cannot be verified and will be rejected")
 	}
 
-	instance, basicErr := core.NewUniswapv2pair(common2.HexToAddress(pairAddress), client)
+	draft.setPairTokens()
+	refresh, err := d.refreshPair(ctx, draft.Pair, false)
 	if err != nil {
-		return common.NewRuntimeError(basicErr)
+		return nil, err
+	}
+	preview.Warnings = append(preview.Warnings, refresh.Warnings...)
+	if refresh.Migrated {
+		preview.Warnings = append(preview.Warnings, "launchpad token already migrated")
 	}
 
-	if _, ok := d.nativeTokenMap[chainID]; !ok {
-		chain, err := d.assetRepository.RetrieveChainByID(ctx, chainID)
-		if err != nil {
-			logger.GetLoggerEntry(ctx).Errorf("Failed to retrieve wnative token for chain: %s", chainID)
-			return nil
+	draft.Token = refresh.Token
+	draft.setPairTokens()
+	preview.Token = &draft.Token
+
+	preview.Safety, err = d.safetySimulator.Simulate(ctx, draft.Pair)
+	if err != nil {
+		preview.Warnings = append(preview.Warnings, fmt.Sprintf("error simulating token safety: %v", err))
+	} else {
+		preview.Safety.applyTo(preview.Token)
+		draft.setPairTokens()
+		switch preview.Safety.Status {
+		case TokenSafetyStatusHoneypot:
+			preview.Warnings = append(preview.Warnings, "honeypot: "+preview.Safety.Reason)
+		case TokenSafetyStatusUnknown:
+			// screening only publishes tokens that passed, an inconclusive run blocks the pair as well
+			preview.Warnings = append(preview.Warnings, "token safety unknown: "+preview.Safety.Reason)
 		}
+	}
 
-		d.nativeTokenMap[chainID] = chain.NativeToken
+	liquidityInNative, err := d.liquidityInNative(ctx, draft.Pair)
+	if err != nil {
+		preview.Warnings = append(preview.Warnings, fmt.Sprintf("error converting liquidity to native: %v", err))
+	} else if reason := d.publishThresholds.Check(draft.Pair, liquidityInNative); len(reason) > 0 {
+		preview.Warnings = append(preview.Warnings, "screening would reject the pair: "+reason)
 	}
-	nativeToken := d.nativeTokenMap[chainID]
 
-	token0, basicErr := instance.Token0(nil)
-	if basicErr != nil {
-		return common.NewRuntimeError(basicErr)
+	if d.iconResolver.NeedsResolve(*preview.Token) {
+		preview.Warnings = append(preview.Warnings, "icon is resolved on creation")
 	}
 
-	token1, basicErr := instance.Token1(nil)
-	if basicErr != nil {
-		return common.NewRuntimeError(basicErr)
+	return preview, nil
+}
+
+// createPairFromAddress is idempotent on chain and pair address, a known pair is synced and returned as is.
+// A new pair is screened and, with submit, queued for review.
+func (d *dexEvmPairService) createPairFromAddress(ctx context.Context, chainID, pairType, pairAddress string, submit bool) (*model.DexPair, businesserror.XSpaceBusinessError) {
+	unlock := d.creationLocks.lock(chainID, pairAddress)
+	defer unlock()
+
+	existing, err := d.assetRepository.TryRetrievePairByContractAddress(ctx, chainID, pairAddress)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return d.syncExistingPair(ctx, existing, pairType)
+	}
+
+	draft, err := d.buildPair(ctx, chainID, pairType, pairAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	pair := draft.Pair
+	if draft.ExistingToken {
+		err = d.assetRepository.CreateDexPair(ctx, pair)
+	} else {
+		err = d.assetRepository.CreatePairWithToken(ctx, &draft.Token, pair)
+	}
+	if err != nil {
+		// the unique index on chain and address rejects a pair another instance created in the meantime
+		existing, retrieveErr := d.assetRepository.TryRetrievePairByContractAddress(ctx, chainID, pairAddress)
+		if retrieveErr == nil && existing != nil {
+			return d.syncExistingPair(ctx, existing, pairType)
+		}
+		return nil, err
+	}
+	draft.setPairTokens()
+
+	err = d.SyncPair(ctx, pair)
+	if err != nil {
+		return nil, err
+	}
+
+	// a pair whose screening failed with an error stays discovered and one rejected by screening is screened again,
+	// publishing it again retries either
+	_, err = d.advanceReview(ctx, pair, submit)
+	if err != nil {
+		logger.GetLoggerEntry(ctx).WithField("pair_id", pair.ID).Errorf("error screening pair, %v", err)
+	}
+
+	return pair, nil
+}
+
+func (d *dexEvmPairService) syncExistingPair(ctx context.Context, existing *model.DexPair, pairType string) (*model.DexPair, businesserror.XSpaceBusinessError) {
+	if existing.Type != model.PairType(pairType) {
+		return nil, common.NewRuntimeError(fmt.Errorf("pair %s already exists as %s", existing.ContractAddress, existing.Type))
+	}
+
+	err := d.SyncPair(ctx, existing)
+	if err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+// buildPair reads the pair from chain and resolves its tokens, it only reads from the repository.
+func (d *dexEvmPairService) buildPair(ctx context.Context, chainID, pairType, pairAddress string) (*pairDraft, businesserror.XSpaceBusinessError) {
+	if !isSupportedPairType(model.PairType(pairType)) {
+		return nil, common.NewRuntimeError(errors.New(common.InvalidPairType))
+	}
+
+	nativeToken, err := d.chains.NativeToken(ctx, chainID)
+	if err != nil {
+		logger.GetLoggerEntry(ctx).Errorf("Failed to retrieve wnative token for chain: %s", chainID)
+		return nil, err
+	}
+
+	state, err := d.readPairState(ctx, chainID, model.PairType(pairType), pairAddress, nativeToken.ContractAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.provenance.Verify(ctx, chainID, model.PairType(pairType), pairAddress, state)
+	if err != nil {
+		logger.GetLoggerEntry(ctx).
+			WithField("contract_address", pairAddress).
+			Errorf("pair failed provenance check, %v", err)
+		return nil, err
+	}
+
+	tokenSide, quoteSide, err := detectTokenSides(chainID, nativeToken.ContractAddress, state.Token0, state.Token1)
+	if err != nil {
+		return nil, err
+	}
+
+	quoteToken := nativeToken
+	if !strings.EqualFold(quoteSide.String(), nativeToken.ContractAddress) {
+		if isV3PairType(model.PairType(pairType)) || isLaunchpadPairType(model.PairType(pairType)) {
+			return nil, common.NewRuntimeError(errors.New("v3 and launchpad pairs must be quoted in the native token"))
+		}
+
+		quoteToken, err = d.assetRepository.TryRetrieveTokenByContractAddress(ctx, chainID, quoteSide.String())
+		if err != nil {
+			return nil, err
+		}
+		if quoteToken == nil {
+			return nil, common.NewRuntimeError(fmt.Errorf("quote token %s is not registered", quoteSide.String()))
+		}
 	}
 
 	token0ID := ""
 	token1ID := ""
-	tokenAddress := ""
-	if token0.String() == nativeToken.ContractAddress {
-		tokenAddress = token1.String()
-		token0ID = nativeToken.ID
+	tokenAddress := tokenSide.String()
+	if state.Token0 == quoteSide {
+		token0ID = quoteToken.ID
 	} else {
-		tokenAddress = token0.String()
-		token1ID = nativeToken.ID
+		token1ID = quoteToken.ID
 	}
 
-	var reserve struct {
-		Reserve0           *big.Int
-		Reserve1           *big.Int
-		BlockTimestampLast uint32
-	}
-	reserve, basicErr = instance.GetReserves(nil)
-	if basicErr != nil {
-		return common.NewRuntimeError(basicErr)
+	// a token migrating off a launchpad is already known through its curve pair
+	existingToken, err := d.assetRepository.TryRetrieveTokenByContractAddress(ctx, chainID, tokenAddress)
+	if err != nil {
+		return nil, err
 	}
 
-	token := model.Token{
-		ChainID:         chainID,
-		ContractAddress: tokenAddress,
-		IconFileURL:     "",
+	draft := &pairDraft{
+		Token: model.Token{
+			ChainID:         chainID,
+			ContractAddress: tokenAddress,
+			IconFileURL:     "",
+		},
+		QuoteToken: quoteToken,
+	}
+	if existingToken != nil {
+		draft.Token = *existingToken
+		draft.ExistingToken = true
+		if token0ID == "" {
+			token0ID = draft.Token.ID
+		} else {
+			token1ID = draft.Token.ID
+		}
 	}
 
-	pair := &model.DexPair{
+	draft.Pair = &model.DexPair{
 		Type:            model.PairType(pairType),
 		ChainID:         chainID,
 		ContractAddress: pairAddress,
 		Token0ID:        token0ID,
 		Token1ID:        token1ID,
-		Reserve0:        model.NewBigInt(*reserve.Reserve0),
-		Reserve1:        model.NewBigInt(*reserve.Reserve1),
-		IsPublished:     true,
-		ForcePublish:    true,
+		Reserve0:        model.NewBigInt(*state.Reserve0),
+		Reserve1:        model.NewBigInt(*state.Reserve1),
+		FeeTier:         state.FeeTier,
+		ReviewState:     PairReviewStateDiscovered,
+	}
+
+	return draft, nil
+}
+
+// setPairTokens copies the token and the quote token onto the side of the pair they belong to.
+func (p *pairDraft) setPairTokens() {
+	if p.Pair.Token0ID == p.Token.ID {
+		p.Pair.Token0 = p.Token
+		p.Pair.Token1 = *p.QuoteToken
+	} else {
+		p.Pair.Token0 = *p.QuoteToken
+		p.Pair.Token1 = p.Token
+	}
+}
+
+// readPairState reads the pool tokens and reserves, for v3 pools the reserves are the pool token balances.
+// A launchpad pair address is the token itself, its reserves are the tokens left on the curve and the native raised.
+func (d *dexEvmPairService) readPairState(ctx context.Context, chainID string, pairType model.PairType, pairAddress, nativeAddress string) (*pairOnchainState, businesserror.XSpaceBusinessError) {
+	if isLaunchpadPairType(pairType) {
+		curve, err := d.launchpads.ReadCurve(ctx, chainID, pairType, pairAddress)
+		if err != nil {
+			return nil, err
+		}
+		if curve.Migrated {
+			return nil, common.NewRuntimeError(errLaunchpadMigrated)
+		}
+
+		return &pairOnchainState{
+			Token0:   common2.HexToAddress(pairAddress),
+			Token1:   common2.HexToAddress(nativeAddress),
+			Reserve0: curve.Offers,
+			Reserve1: curve.Funds,
+		}, nil
+	}
+
+	if isV3PairType(pairType) {
+		snapshot, err := d.v3Pools.ReadPool(ctx, chainID, pairAddress)
+		if err != nil {
+			return nil, err
+		}
+
+		return &pairOnchainState{
+			Token0:   snapshot.Token0,
+			Token1:   snapshot.Token1,
+			Reserve0: snapshot.Balance0,
+			Reserve1: snapshot.Balance1,
+			FeeTier:  snapshot.Fee,
+		}, nil
+	}
+
+	client, err := d.gethService.GetClient(chainID)
+	if err != nil {
+		return nil, err
+	}
+
+	instance, basicErr := core.NewUniswapv2pair(common2.HexToAddress(pairAddress), client)
+	if basicErr != nil {
+		return nil, common.NewRuntimeError(basicErr)
+	}
+
+	token0, basicErr := instance.Token0(nil)
+	if basicErr != nil {
+		return nil, common.NewRuntimeError(basicErr)
+	}
+
+	token1, basicErr := instance.Token1(nil)
+	if basicErr != nil {
+		return nil, common.NewRuntimeError(basicErr)
+	}
+
+	reserve, basicErr := instance.GetReserves(nil)
+	if basicErr != nil {
+		return nil, common.NewRuntimeError(basicErr)
+	}
+
+	return &pairOnchainState{
+		Token0:   token0,
+		Token1:   token1,
+		Reserve0: reserve.Reserve0,
+		Reserve1: reserve.Reserve1,
+	}, nil
+}
+
+// pairRefresh is what one sync read from chain, Warnings are the reads that failed and were skipped.
+// PricesOnly limits the read to reserves, prices and market cap.
+type pairRefresh struct {
+	Token      model.Token
+	Migrated   bool
+	PricesOnly bool
+	Warnings   []string
+}
+
+func (r *pairRefresh) warn(c context.Context, pair *model.DexPair, message string, err error) {
+	logger.GetLoggerEntry(c).
+		WithField("pair_id", pair.ID).
+		WithField("contract_address", pair.ContractAddress).
+		Errorf("%s, %v", message, err)
+	r.Warnings = append(r.Warnings, fmt.Sprintf("%s: %v", message, err))
+}
+
+func (d *dexEvmPairService) SyncPair(c context.Context, pair *model.DexPair) businesserror.XSpaceBusinessError {
+	return d.syncPair(c, pair, false)
+}
+
+// SyncPairPrices refreshes reserves, prices and market cap and leaves the LP locks, ownership, metadata, contract
+// analysis and icon of the last SyncPair in place.
+func (d *dexEvmPairService) SyncPairPrices(c context.Context, pair *model.DexPair) businesserror.XSpaceBusinessError {
+	return d.syncPair(c, pair, true)
+}
+
+func (d *dexEvmPairService) syncPair(c context.Context, pair *model.DexPair, pricesOnly bool) businesserror.XSpaceBusinessError {
+	if len(pair.MigratedPairID) > 0 {
+		return nil
 	}
 
-	err = d.assetRepository.CreatePairWithToken(ctx, &token, pair)
+	refresh, err := d.refreshPair(c, pair, pricesOnly)
 	if err != nil {
 		return err
 	}
+	if refresh.Migrated {
+		return d.migrateLaunchpadPair(c, pair)
+	}
 
-	if pair.Token0ID == token.ID {
-		pair.Token0 = token
-		pair.Token1 = *nativeToken
-	} else {
-		pair.Token0 = *nativeToken
-		pair.Token1 = token
+	err = d.assetRepository.UpdateDexPair(c, pair)
+	if err != nil {
+		logger.GetLoggerEntry(c).Errorf("error updating pair, %v", err)
+		return err
 	}
 
-	err = d.SyncPair(ctx, pair)
+	d.updatePrimaryPool(c, pair)
+
+	err = d.syncToken(c, refresh)
 	if err != nil {
 		return err
 	}
 
+	d.recordPriceSnapshot(c, pair, refresh.Token)
 	return nil
 }
 
-func (d *dexEvmPairService) SyncPair(c context.Context, pair *model.DexPair) businesserror.XSpaceBusinessError {
+// refreshPair reads the pair and its token from chain into pair and the returned token without persisting either.
+func (d *dexEvmPairService) refreshPair(c context.Context, pair *model.DexPair, pricesOnly bool) (*pairRefresh, businesserror.XSpaceBusinessError) {
+	refresh := &pairRefresh{Token: pair.GetToken(), PricesOnly: pricesOnly}
+
+	var err businesserror.XSpaceBusinessError
+	switch {
+	case isV3PairType(pair.Type):
+		err = d.refreshV3Pair(c, pair, refresh)
+	case isLaunchpadPairType(pair.Type):
+		err = d.refreshLaunchpadPair(c, pair, refresh)
+	default:
+		d.refreshV2Pair(c, pair, refresh)
+	}
+	if err != nil || refresh.Migrated {
+		return refresh, err
+	}
+	if refresh.PricesOnly {
+		d.fillTokenUSD(c, &refresh.Token)
+		return refresh, nil
+	}
+
+	if !refresh.Token.IsRenounced {
+		owner, err := d.gethService.GetTokenOwnerAddress(c, refresh.Token)
+		if err != nil {
+			refresh.warn(c, pair, "error getting token owner", err)
+		} else if strings.EqualFold(owner, common.AddressZero) {
+			refresh.Token.IsRenounced = true
+		}
+	}
+
+	d.refreshToken(c, pair, refresh)
+	return refresh, nil
+}
+
+func (d *dexEvmPairService) refreshV2Pair(c context.Context, pair *model.DexPair, refresh *pairRefresh) {
+	d.fillLiquidityUSD(c, pair)
+	d.fillLiquidityDepth(c, pair)
+
+	if !refresh.PricesOnly {
+		d.refreshV2PairSupply(c, pair, refresh)
+	}
+
+	totalSupply, err := d.gethService.GetTokenTotalSupply(c, refresh.Token)
+	if err != nil {
+		refresh.warn(c, pair, "error getting token total supply", err)
+		return
+	}
+
+	// the spot price is the reserve ratio, a quote for one token would include the fee and its own price impact
+	tokenReserve := pair.GetTokenReserve()
+	if tokenReserve.Sign() == 0 {
+		refresh.Token.TotalSupply = model.NewBigInt(*totalSupply)
+		return
+	}
+
+	liquidity, err := d.liquidityInNative(c, pair)
+	if err != nil {
+		refresh.Token.TotalSupply = model.NewBigInt(*totalSupply)
+		refresh.warn(c, pair, "error converting market cap to native", err)
+		return
+	}
+
+	d.fillTokenMarketCap(c, pair, refresh, totalSupply, new(big.Rat).SetFrac(liquidity, tokenReserve))
+}
+
+// refreshV2PairSupply reads the LP supply, burned and locked, which only changes with liquidity events.
+func (d *dexEvmPairService) refreshV2PairSupply(c context.Context, pair *model.DexPair, refresh *pairRefresh) {
 	totalSupply, burnedSupply, err := d.gethService.GetV2PairSupply(c, *pair)
+	if err != nil {
+		refresh.warn(c, pair, "error getting pair supply", err)
+		return
+	}
+	pair.TotalSupply = model.NewBigInt(*totalSupply)
+	pair.BurnedSupply = model.NewBigInt(*burnedSupply)
+
+	lockInfo, err := d.lpLocks.ReadLocks(c, pair)
+	if err != nil {
+		refresh.warn(c, pair, "error reading lp locks", err)
+		return
+	}
+	pair.LockedSupply = model.NewBigInt(*lockInfo.LockedSupply)
+	pair.LockedUntil = lockInfo.EarliestUnlockAt
+	pair.LockedLiquidity = shareOf(lockInfo.LockedSupply, totalSupply)
+}
+
+// refreshV3Pair refreshes balances and prices from slot0, v3 pools have no LP supply to track.
+func (d *dexEvmPairService) refreshV3Pair(c context.Context, pair *model.DexPair, refresh *pairRefresh) businesserror.XSpaceBusinessError {
+	snapshot, err := d.v3Pools.ReadPool(c, pair.ChainID, pair.ContractAddress)
+	if err != nil {
+		logger.GetLoggerEntry(c).
+			WithField("pair_id", pair.ID).
+			Errorf("error reading v3 pool, %v", err)
+		return err
+	}
+
+	pair.Reserve0 = model.NewBigInt(*snapshot.Balance0)
+	pair.Reserve1 = model.NewBigInt(*snapshot.Balance1)
+	pair.FeeTier = snapshot.Fee
+	d.fillLiquidityUSD(c, pair)
+	d.fillLiquidityDepth(c, pair)
+
+	totalSupply, err := d.gethService.GetTokenTotalSupply(c, refresh.Token)
+	if err != nil {
+		refresh.warn(c, pair, "error getting token total supply", err)
+		return nil
+	}
+
+	// price is token1 per token0, flip it when the token is token1
+	price := core.V3SpotPrice(snapshot.SqrtPriceX96)
+	if !isTokenToken0(pair) {
+		price = new(big.Rat).Inv(price)
+	}
+
+	d.fillTokenMarketCap(c, pair, refresh, totalSupply, price)
+	return nil
+}
+
+// refreshLaunchpadPair follows the curve until the launchpad migrates the token, refresh.Migrated hands it over to the dex pair.
+func (d *dexEvmPairService) refreshLaunchpadPair(c context.Context, pair *model.DexPair, refresh *pairRefresh) businesserror.XSpaceBusinessError {
+	token := &refresh.Token
+	curve, err := d.launchpads.ReadCurve(c, pair.ChainID, pair.Type, token.ContractAddress)
 	if err != nil {
 		logger.GetLoggerEntry(c).
 			WithField("pair_id", pair.ID).
-			Errorf("error getting pair supply, %v", err)
+			Errorf("error reading launchpad curve, %v", err)
+		return err
+	}
+
+	if curve.Migrated {
+		refresh.Migrated = true
+		return nil
+	}
+
+	tokenReserve, nativeReserve := model.NewBigInt(*curve.Offers), model.NewBigInt(*curve.Funds)
+	if isTokenToken0(pair) {
+		pair.Reserve0, pair.Reserve1 = tokenReserve, nativeReserve
 	} else {
-		pair.TotalSupply = model.NewBigInt(*totalSupply)
-		pair.BurnedSupply = model.NewBigInt(*burnedSupply)
+		pair.Reserve0, pair.Reserve1 = nativeReserve, tokenReserve
+	}
+	d.fillLiquidityUSD(c, pair)
+	d.fillLiquidityDepth(c, pair)
+
+	totalSupply, err := d.gethService.GetTokenTotalSupply(c, *token)
+	if err != nil {
+		refresh.warn(c, pair, "error getting token total supply", err)
+		return nil
+	}
+
+	d.fillTokenMarketCap(c, pair, refresh, totalSupply, curve.SpotPrice())
+	return nil
+}
+
+// migrateLaunchpadPair moves a graduated token to the dex pair its curve migrated to and retires the curve pair.
+// Until the factory knows the pair the curve pair is left as is and the next sync retries.
+func (d *dexEvmPairService) migrateLaunchpadPair(c context.Context, pair *model.DexPair) businesserror.XSpaceBusinessError {
+	launchpad, err := d.launchpads.GetLaunchpad(pair.ChainID, pair.Type)
+	if err != nil {
+		return err
+	}
 
-		err = d.assetRepository.UpdateDexPair(c, pair)
+	nativeToken, err := d.chains.NativeToken(c, pair.ChainID)
+	if err != nil {
+		return err
+	}
+
+	pairAddress, err := d.launchpads.FindMigratedPair(c, pair, nativeToken.ContractAddress)
+	if err != nil {
+		return err
+	}
+	if len(pairAddress) == 0 {
+		logger.GetLoggerEntry(c).
+			WithField("pair_id", pair.ID).
+			Infof("launchpad curve filled, %s pair not created yet", launchpad.MigrationPairType)
+		return nil
+	}
+
+	migrated, err := d.assetRepository.TryRetrievePairByContractAddress(c, pair.ChainID, pairAddress)
+	if err != nil {
+		return err
+	}
+	if migrated == nil {
+		migrated, err = d.createPairFromAddress(c, pair.ChainID, string(launchpad.MigrationPairType), pairAddress, pair.IsPublished)
 		if err != nil {
-			logger.GetLoggerEntry(c).Errorf("error updating pair, %v", err)
 			return err
 		}
 	}
 
-	token := pair.GetToken()
-	if !token.IsRenounced {
-		owner, err := d.gethService.GetTokenOwnerAddress(c, token)
+	// the token was already reviewed on the curve, its dex pair only has to pass screening
+	if pair.IsPublished && reviewStateOf(migrated) == PairReviewStatePendingReview {
+		err = d.reviews.Transition(c, migrated, PairReviewStatePublished, PairReviewActorSystem, "launchpad pair "+pair.ID+" was published")
 		if err != nil {
-			logger.GetLoggerEntry(c).
-				WithField("token_id", token.ID).
-				WithField("contract_address", token.ContractAddress).
-				Errorf("error getting token owner, %v", err)
-		} else if strings.EqualFold(owner, common.AddressZero) {
-			token.IsRenounced = true
+			return err
 		}
 	}
 
-	totalSupply, err = d.gethService.GetTokenTotalSupply(c, token)
+	err = d.reviews.Reject(c, pair, PairReviewActorSystem, "launchpad token migrated to "+pairAddress)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	pair.MigratedPairID = migrated.ID
+	pair.MigratedAt = &now
+
+	err = d.assetRepository.UpdateDexPair(c, pair)
+	if err != nil {
+		return err
+	}
+
+	logger.GetLoggerEntry(c).
+		WithField("pair_id", pair.ID).
+		WithField("migrated_pair_id", migrated.ID).
+		Infof("launchpad token migrated to %s", pairAddress)
+
+	d.updatePrimaryPool(c, migrated)
+	return nil
+}
+
+func (d *dexEvmPairService) liquidityInNative(c context.Context, pair *model.DexPair) (*big.Int, businesserror.XSpaceBusinessError) {
+	return pairLiquidityInNative(c, d.quoteTokens, pair)
+}
+
+// fillLiquidityUSD values both sides of the pool at twice the quote reserve, it is left empty without a fresh usd price.
+func (d *dexEvmPairService) fillLiquidityUSD(c context.Context, pair *model.DexPair) {
+	pair.LiquidityUSD = nil
+
+	liquidity, err := d.liquidityInNative(c, pair)
 	if err != nil {
 		logger.GetLoggerEntry(c).
-			WithField("token_id", token.ID).
-			WithField("contract_address", token.ContractAddress).
-			Errorf("error getting token total supply, %v", err)
-	} else {
-		ethOut := core.GetAmountOut(
-			decimal.NewFromBigInt(big.NewInt(1), int32(token.Decimals)),
-			decimal.NewFromBigInt(pair.GetTokenReserve(), 0),
-			decimal.NewFromBigInt(pair.GetWNativeReserve(), 0))
+			WithField("pair_id", pair.ID).
+			Errorf("error converting liquidity to native, %v", err)
+		return
+	}
 
-		token.TotalSupply = model.NewBigInt(*totalSupply)
-		token.MarketCapInNative = model.NewBigInt(*big.NewInt(1).
-			Div(big.NewInt(1).Mul(totalSupply, ethOut.BigInt()), model.GetChainNativeByID(pair.ChainID).BigInt()))
+	// a launchpad curve only holds the native raised, the tokens for sale are not liquidity
+	if !isLaunchpadPairType(pair.Type) {
+		liquidity = new(big.Int).Mul(liquidity, big.NewInt(2))
 	}
+	pair.LiquidityUSD = d.usdOracle.NativeToUSD(c, pair.ChainID, liquidity)
+}
 
-	if token.Decimals == 0 {
-		tokenMetadata, err := d.gethService.GetTokenMetadata(c, token)
-		if err == nil && tokenMetadata != nil {
-			token.Name = tokenMetadata.Name
-			token.Symbol = tokenMetadata.Symbol
-			token.Decimals = tokenMetadata.Decimals
+// fillLiquidityDepth keeps the previous depth when the curve cannot be walked.
+func (d *dexEvmPairService) fillLiquidityDepth(c context.Context, pair *model.DexPair) {
+	depth, err := d.depth.Compute(c, pair)
+	if err != nil {
+		logger.GetLoggerEntry(c).
+			WithField("pair_id", pair.ID).
+			Errorf("error computing liquidity depth, %v", err)
+		return
+	}
+
+	pair.LiquidityDepth, err = depth.marshal()
+	if err != nil {
+		logger.GetLoggerEntry(c).
+			WithField("pair_id", pair.ID).
+			Errorf("error encoding liquidity depth, %v", err)
+	}
+}
+
+// fillTokenMarketCap values the token at its spot price, in native wei per token wei. The fully diluted value counts
+// the whole supply, the market cap only what circulates. Both are cleared when the circulating supply cannot be read,
+// a market cap from an older supply next to a fresh fully diluted value would disagree with it.
+func (d *dexEvmPairService) fillTokenMarketCap(c context.Context, pair *model.DexPair, refresh *pairRefresh, totalSupply *big.Int, price *big.Rat) {
+	token := &refresh.Token
+	token.TotalSupply = model.NewBigInt(*totalSupply)
+	token.FullyDilutedValueInNative = model.NewBigInt(*valueAtPrice(totalSupply, price))
+
+	circulating, err := d.supply.Circulating(c, pair, *token, totalSupply)
+	if err != nil {
+		refresh.warn(c, pair, "error reading circulating supply, clearing market cap", err)
+		zero := model.NewBigInt(*big.NewInt(0))
+		token.CirculatingSupply = zero
+		token.MarketCapInNative = zero
+		return
+	}
+
+	token.CirculatingSupply = model.NewBigInt(*circulating)
+	token.MarketCapInNative = model.NewBigInt(*valueAtPrice(circulating, price))
+}
+
+// fillTokenUSD derives the usd market cap, fully diluted value and price, all are left empty without a fresh usd price.
+// The usd market cap is also left empty while the circulating supply is unknown.
+func (d *dexEvmPairService) fillTokenUSD(c context.Context, token *model.Token) {
+	token.MarketCapUSD = nil
+	if bigIntOf(token.CirculatingSupply).Sign() > 0 {
+		token.MarketCapUSD = d.usdOracle.NativeToUSD(c, token.ChainID, bigIntOf(token.MarketCapInNative))
+	}
+	token.FullyDilutedValueUSD = d.usdOracle.NativeToUSD(c, token.ChainID, bigIntOf(token.FullyDilutedValueInNative))
+	token.PriceUSD = nil
+
+	totalSupply := bigIntOf(token.TotalSupply)
+	if token.FullyDilutedValueUSD == nil || totalSupply.Sign() == 0 {
+		return
+	}
+
+	price := token.FullyDilutedValueUSD.Div(decimal.NewFromBigInt(totalSupply, -int32(token.Decimals)))
+	token.PriceUSD = &price
+}
+
+// refreshToken fills metadata, usd values and the contract risk report, it is shared by all pair types.
+func (d *dexEvmPairService) refreshToken(c context.Context, pair *model.DexPair, refresh *pairRefresh) {
+	token := &refresh.Token
+	if d.metadataReader.NeedsRead(*token) {
+		if err := d.metadataReader.Sync(c, token); err != nil {
+			refresh.warn(c, pair, "error reading token metadata", err)
 		}
 	}
 
-	if token.IconFileURL == "" || strings.HasSuffix(token.IconFileURL, "default-token.png") {
-		url, err := d.uploadService.CreateFileFromURL(c, token.ID,
-			"kaboom",
-			fmt.Sprintf("https://dd.dexscreener.com/ds-data/tokens/%s/%s.png",
-				model.GetChainNameByID(token.ChainID), token.ContractAddress))
-		if err == nil {
-			token.IconFileURL = url
-		} else {
-			token.IconFileURL = fmt.Sprintf("https://dd.dexscreener.com/ds-data/tokens/%s/%s.png",
-				model.GetChainNameByID(token.ChainID), token.ContractAddress)
+	d.fillTokenUSD(c, token)
+
+	report, err := d.riskAnalyzer.Analyze(c, *token)
+	if err == nil {
+		err = report.applyTo(token)
+	}
+	if err != nil {
+		refresh.warn(c, pair, "error analyzing token contract", err)
+	}
+}
+
+// syncToken resolves the icon, which uploads, and persists the token.
+func (d *dexEvmPairService) syncToken(c context.Context, refresh *pairRefresh) businesserror.XSpaceBusinessError {
+	token := refresh.Token
+	if !refresh.PricesOnly && d.iconResolver.NeedsResolve(token) {
+		if err := d.iconResolver.Resolve(c, &token); err != nil {
+			logger.GetLoggerEntry(c).
+				WithField("token_id", token.ID).
+				Errorf("error resolving token icon, %v", err)
 		}
 	}
 
-	err = d.assetRepository.UpdateToken(c, &token)
+	err := d.assetRepository.UpdateToken(c, &token)
 	if err != nil {
 		logger.GetLoggerEntry(c).Errorf("error updating token, %v", err)
 		return err
@@ -210,10 +859,20 @@ func (d *dexEvmPairService) SyncPair(c context.Context, pair *model.DexPair) bus
 	return nil
 }
 
+// NewEvmDexPairService builds the service with market caches of its own, see NewEvmDexPairServiceWithMarketData.
 func NewEvmDexPairService(
 	gethService evm.GethService,
 	uploadService repository.UploadRepository,
 	assetRepository repository.AssetRepository,
+) DexPairService {
+	return NewEvmDexPairServiceWithMarketData(gethService, uploadService, assetRepository, NewMarketData(gethService, assetRepository))
+}
+
+func NewEvmDexPairServiceWithMarketData(
+	gethService evm.GethService,
+	uploadService repository.UploadRepository,
+	assetRepository repository.AssetRepository,
+	market *MarketData,
 ) DexPairService {
 	path, _ := filepath.Abs("./resource/uniswap_v2_abi.json")
 	file, err := os.ReadFile(path)
@@ -225,11 +884,32 @@ func NewEvmDexPairService(
 		return nil
 	}
 
+	v3Pools := newV3PoolReader(gethService)
+	quoteTokens := market.quoteTokens
+	launchpads := newLaunchpadReader(gethService)
+
 	return &dexEvmPairService{
 		uniSwapV2Abi:    uniSwapV2Abi,
 		uploadService:   uploadService,
 		gethService:     gethService,
 		assetRepository: assetRepository,
-		nativeTokenMap:  map[string]*model.Token{},
+		chains:          market.chains,
+
+		publishThresholds: DefaultPublishThresholds(),
+		v3Pools:           v3Pools,
+		quoteTokens:       quoteTokens,
+		safetySimulator:   newTokenSafetySimulator(gethService, market.chains, v3Pools, quoteTokens, launchpads, assetRepository),
+		riskAnalyzer:      newTokenRiskAnalyzer(gethService),
+		lpLocks:           newLPLockReader(gethService),
+		usdOracle:         market.usdOracle,
+		depth:             newLiquidityDepthCalculator(v3Pools, quoteTokens, launchpads),
+		iconResolver:      newTokenIconResolver(uploadService),
+		metadataReader:    newTokenMetadataReader(gethService),
+		launchpads:        launchpads,
+		provenance:        newPairProvenanceVerifier(gethService, launchpads),
+		creationLocks:     newPairCreationLocks(),
+		supply:            newCirculatingSupplyReader(gethService, assetRepository, market.chains, launchpads),
+		holders:           newTokenHolderCounter(gethService),
+		reviews:           newPairReviewMachine(assetRepository),
 	}
 }
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/common/logger"
+	"github.com/cross-space-official/kaboom-service/common"
+	"github.com/cross-space-official/kaboom-service/model"
+	"math/big"
+	"strings"
+	"sync"
+)
+
+// pairCreationLocks serializes creation and re-import per chain and pair address,
+// so two concurrent calls cannot both find the pair missing and insert it twice.
+type pairCreationLocks struct {
+	mu    sync.Mutex
+	locks map[string]*pairCreationLock
+}
+
+type pairCreationLock struct {
+	sync.Mutex
+	holders int
+}
+
+// lock blocks until the pair is free and returns the unlock function.
+func (l *pairCreationLocks) lock(chainID, pairAddress string) func() {
+	key := chainID + ":" + strings.ToLower(pairAddress)
+
+	l.mu.Lock()
+	entry, ok := l.locks[key]
+	if !ok {
+		entry = &pairCreationLock{}
+		l.locks[key] = entry
+	}
+	entry.holders++
+	l.mu.Unlock()
+
+	entry.Lock()
+	return func() {
+		entry.Unlock()
+
+		l.mu.Lock()
+		entry.holders--
+		if entry.holders == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// ReimportPair rebuilds every derived field of a pair and its token from chain, identity and publish state are kept.
+// The pair has to still hold the same tokens and pass the provenance check.
+func (d *dexEvmPairService) ReimportPair(ctx context.Context, pairID string) (*model.DexPair, businesserror.XSpaceBusinessError) {
+	pair, err := d.assetRepository.RetrievePairByPairID(ctx, pairID)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := d.creationLocks.lock(pair.ChainID, pair.ContractAddress)
+	defer unlock()
+
+	nativeToken, err := d.chains.NativeToken(ctx, pair.ChainID)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := d.readPairState(ctx, pair.ChainID, pair.Type, pair.ContractAddress, nativeToken.ContractAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.provenance.Verify(ctx, pair.ChainID, pair.Type, pair.ContractAddress, state)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.EqualFold(state.Token0.Hex(), pair.Token0.ContractAddress) || !strings.EqualFold(state.Token1.Hex(), pair.Token1.ContractAddress) {
+		return nil, common.NewRuntimeError(fmt.Errorf("pair %s now holds %s and %s, not the stored tokens",
+			pair.ContractAddress, state.Token0.Hex(), state.Token1.Hex()))
+	}
+
+	resetPairDerived(pair, state)
+	token := pair.GetToken()
+	resetTokenDerived(&token)
+	setPairToken(pair, token)
+
+	err = d.SyncPair(ctx, pair)
+	if err != nil {
+		return nil, err
+	}
+
+	safety, err := d.safetySimulator.SimulateAndStore(ctx, pair)
+	if err != nil {
+		logger.GetLoggerEntry(ctx).WithField("pair_id", pair.ID).Errorf("error simulating token safety, %v", err)
+		return pair, nil
+	}
+	logSafetyResult(ctx, pair, safety)
+
+	return pair, nil
+}
+
+func resetPairDerived(pair *model.DexPair, state *pairOnchainState) {
+	zero := model.NewBigInt(*big.NewInt(0))
+
+	pair.Reserve0 = model.NewBigInt(*state.Reserve0)
+	pair.Reserve1 = model.NewBigInt(*state.Reserve1)
+	pair.FeeTier = state.FeeTier
+	pair.TotalSupply = zero
+	pair.BurnedSupply = zero
+	pair.LockedSupply = zero
+	pair.LockedUntil = nil
+	pair.LockedLiquidity = 0
+	pair.LiquidityUSD = nil
+	pair.LiquidityDepth = nil
+}
+
+// resetTokenDerived clears what syncs only fill once, so the next sync reads metadata, ownership, risk and safety again.
+// An uploaded icon is kept, a generated one is retried.
+func resetTokenDerived(token *model.Token) {
+	zero := model.NewBigInt(*big.NewInt(0))
+
+	token.MetadataStatus = TokenMetadataStatusPending
+	token.MetadataAttempts = 0
+	token.IsRenounced = false
+	token.TotalSupply = zero
+	token.CirculatingSupply = zero
+	token.MarketCapInNative = zero
+	token.MarketCapUSD = nil
+	token.FullyDilutedValueInNative = zero
+	token.FullyDilutedValueUSD = nil
+	token.PriceUSD = nil
+	token.RiskReport = nil
+	token.RiskScore = 0
+	token.RiskAnalyzedAt = nil
+	token.SafetyStatus = ""
+	token.SafetyCheckedAt = nil
+	token.IconCheckedAt = nil
+}
+
+// setPairToken puts token back on the side of the pair it belongs to.
+func setPairToken(pair *model.DexPair, token model.Token) {
+	if pair.Token0ID == token.ID {
+		pair.Token0 = token
+	} else {
+		pair.Token1 = token
+	}
+}
+
+func newPairCreationLocks() *pairCreationLocks {
+	return &pairCreationLocks{
+		locks: map[string]*pairCreationLock{},
+	}
+}
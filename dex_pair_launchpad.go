@@ -0,0 +1,345 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/kaboom-service/common"
+	"github.com/cross-space-official/kaboom-service/core"
+	"github.com/cross-space-official/kaboom-service/model"
+	"github.com/cross-space-official/kaboom-service/repository"
+	"github.com/cross-space-official/kaboom-service/service/provider/evm"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	common2 "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Launchpad is a bonding curve launchpad, its tokens trade on the curve until they migrate to a v2 pair.
+// Launchpad pairs use the token address as their contract address since the curve lives in the manager.
+type Launchpad struct {
+	Name string
+	// ManagerAddress takes the trades, HelperAddress answers the curve state.
+	ManagerAddress string
+	HelperAddress  string
+	// MigrationPairType and MigrationFactory locate the pair the curve migrates to.
+	MigrationPairType model.PairType
+	MigrationFactory  string
+}
+
+var launchpads = map[string]map[model.PairType]Launchpad{
+	"56": {
+		model.PairTypeFourMeme: {
+			Name:              "four.meme",
+			ManagerAddress:    "0x5c952063c7fc8610FFDB798152D69F0B9550762b",
+			HelperAddress:     "0xF251F83e40a78868FcfA3FA4599Dad6494E46034",
+			MigrationPairType: model.PairTypePancakeSwapV2,
+			MigrationFactory:  "0xcA143Ce32Fe78f1f7019d7d551a6402fC5350c73",
+		},
+	},
+}
+
+// LaunchpadMigrationTopic is emitted by the manager when a curve is filled and its liquidity moves to the dex.
+var LaunchpadMigrationTopic = crypto.Keccak256Hash([]byte("LiquidityAdded(address,uint256,address,uint256)"))
+
+var errLaunchpadMigrated = errors.New("token has migrated off the launchpad curve")
+
+func isLaunchpadPairType(pairType model.PairType) bool {
+	return pairType == model.PairTypeFourMeme
+}
+
+type launchpadCurveSnapshot struct {
+	core.BondingCurveState
+	Manager common2.Address
+	Quote   common2.Address
+	// PriceE18 is the last curve price in native wei per token wei, scaled by 1e18.
+	PriceE18 *big.Int
+	// Offers is what is left for sale out of MaxOffers, Funds what was raised towards MaxFunds.
+	Offers     *big.Int
+	MaxOffers  *big.Int
+	Funds      *big.Int
+	MaxFunds   *big.Int
+	LaunchTime time.Time
+	Migrated   bool
+}
+
+type launchpadReader struct {
+	launchpadAbi abi.ABI
+	factoryAbi   abi.ABI
+	gethService  evm.GethService
+}
+
+func (r *launchpadReader) GetLaunchpad(chainID string, pairType model.PairType) (*Launchpad, businesserror.XSpaceBusinessError) {
+	launchpad, ok := launchpads[chainID][pairType]
+	if !ok {
+		return nil, common.NewRuntimeError(fmt.Errorf("no launchpad for chain %s and pair type %s", chainID, pairType))
+	}
+
+	return &launchpad, nil
+}
+
+func (r *launchpadReader) GetManagerAddress(chainID string, pairType model.PairType) (string, businesserror.XSpaceBusinessError) {
+	launchpad, err := r.GetLaunchpad(chainID, pairType)
+	if err != nil {
+		return "", err
+	}
+
+	return launchpad.ManagerAddress, nil
+}
+
+// ReadCurve reads the curve of tokenAddress and calibrates its virtual reserves, only native quoted curves are supported.
+func (r *launchpadReader) ReadCurve(ctx context.Context, chainID string, pairType model.PairType, tokenAddress string) (*launchpadCurveSnapshot, businesserror.XSpaceBusinessError) {
+	launchpad, err := r.GetLaunchpad(chainID, pairType)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := r.gethService.GetClient(chainID)
+	if err != nil {
+		return nil, err
+	}
+
+	helper := bind.NewBoundContract(common2.HexToAddress(launchpad.HelperAddress), r.launchpadAbi, client, client, client)
+
+	var info []interface{}
+	basicErr := helper.Call(&bind.CallOpts{Context: ctx}, &info, "getTokenInfo", common2.HexToAddress(tokenAddress))
+	if basicErr != nil {
+		return nil, common.NewRuntimeError(fmt.Errorf("launchpad getTokenInfo: %w", basicErr))
+	}
+
+	snapshot := &launchpadCurveSnapshot{
+		Manager:    info[1].(common2.Address),
+		Quote:      info[2].(common2.Address),
+		PriceE18:   info[3].(*big.Int),
+		Offers:     info[7].(*big.Int),
+		MaxOffers:  info[8].(*big.Int),
+		Funds:      info[9].(*big.Int),
+		MaxFunds:   info[10].(*big.Int),
+		LaunchTime: time.Unix(info[6].(*big.Int).Int64(), 0),
+		Migrated:   info[11].(bool),
+	}
+	if snapshot.MaxOffers.Sign() == 0 {
+		return nil, common.NewRuntimeError(fmt.Errorf("token %s is not on %s", tokenAddress, launchpad.Name))
+	}
+	if snapshot.Quote != (common2.Address{}) {
+		return nil, common.NewRuntimeError(fmt.Errorf("launchpad token %s is not native quoted", tokenAddress))
+	}
+
+	snapshot.TokensLeft = snapshot.Offers
+	snapshot.FeeRate = uint32(info[4].(*big.Int).Uint64())
+	snapshot.MinFee = info[5].(*big.Int)
+	if snapshot.Migrated {
+		return snapshot, nil
+	}
+
+	fundsLeft := new(big.Int).Sub(snapshot.MaxFunds, snapshot.Funds)
+	snapshot.VirtualNative, snapshot.VirtualToken, basicErr = core.CalibrateBondingCurve(snapshot.PriceE18, fundsLeft, snapshot.Offers)
+	if basicErr != nil {
+		return nil, common.NewRuntimeError(basicErr)
+	}
+
+	return snapshot, nil
+}
+
+// Quote prices a trade through the launchpad helper, amount is the input when exactInput and the output otherwise.
+// The helper quotes everything but a sell for an exact native output, that one walks the calibrated curve.
+func (r *launchpadReader) Quote(ctx context.Context, pair *model.DexPair, isBuy, exactInput bool, amount *big.Int) (*big.Int, businesserror.XSpaceBusinessError) {
+	if amount == nil || amount.Sign() <= 0 {
+		return big.NewInt(0), nil
+	}
+
+	tokenAddress := pair.GetToken().ContractAddress
+	snapshot, err := r.ReadCurve(ctx, pair.ChainID, pair.Type, tokenAddress)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.Migrated {
+		return nil, common.NewRuntimeError(errLaunchpadMigrated)
+	}
+
+	if !isBuy && !exactInput {
+		result, basicErr := core.BondingCurveSellIn(snapshot.BondingCurveState, amount)
+		if basicErr != nil {
+			return nil, common.NewRuntimeError(basicErr)
+		}
+		return result, nil
+	}
+
+	launchpad, err := r.GetLaunchpad(pair.ChainID, pair.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := r.gethService.GetClient(pair.ChainID)
+	if err != nil {
+		return nil, err
+	}
+
+	helper := bind.NewBoundContract(common2.HexToAddress(launchpad.HelperAddress), r.launchpadAbi, client, client, client)
+	token := common2.HexToAddress(tokenAddress)
+	opts := &bind.CallOpts{Context: ctx}
+
+	var out []interface{}
+	var basicErr error
+	switch {
+	case isBuy && exactInput:
+		// tryBuy takes either the token amount or the funds, the other one is zero
+		basicErr = helper.Call(opts, &out, "tryBuy", token, big.NewInt(0), amount)
+		if basicErr == nil {
+			return out[2].(*big.Int), nil
+		}
+	case isBuy:
+		// amountMsgValue is what buying exactly amount costs, the fee included
+		basicErr = helper.Call(opts, &out, "tryBuy", token, amount, big.NewInt(0))
+		if basicErr == nil {
+			return out[5].(*big.Int), nil
+		}
+	default:
+		// the manager pays out the funds less the fee
+		basicErr = helper.Call(opts, &out, "trySell", token, amount)
+		if basicErr == nil {
+			funds := new(big.Int).Sub(out[2].(*big.Int), out[3].(*big.Int))
+			if funds.Sign() < 0 {
+				funds.SetInt64(0)
+			}
+			return funds, nil
+		}
+	}
+
+	return nil, common.NewRuntimeError(fmt.Errorf("launchpad quote: %w", basicErr))
+}
+
+// PackBuyData spends the whole native value on the curve and sends the tokens to recipient.
+func (r *launchpadReader) PackBuyData(pair *model.DexPair, recipient string, amountInWei, minimalOutAmountInWei *big.Int) ([]byte, businesserror.XSpaceBusinessError) {
+	raw, err := r.launchpadAbi.Pack(
+		"buyTokenAMAP",
+		common2.HexToAddress(pair.GetToken().ContractAddress),
+		common2.HexToAddress(recipient),
+		amountInWei,
+		minimalOutAmountInWei,
+	)
+	if err != nil {
+		return nil, common.NewRuntimeError(err)
+	}
+	return raw, nil
+}
+
+// PackSellData sells back to the curve, the manager pays the native to the sender.
+func (r *launchpadReader) PackSellData(pair *model.DexPair, amountInWei, minimalOutAmountInWei *big.Int) ([]byte, businesserror.XSpaceBusinessError) {
+	raw, err := r.launchpadAbi.Pack(
+		"sellToken",
+		big.NewInt(0),
+		common2.HexToAddress(pair.GetToken().ContractAddress),
+		amountInWei,
+		minimalOutAmountInWei,
+	)
+	if err != nil {
+		return nil, common.NewRuntimeError(err)
+	}
+	return raw, nil
+}
+
+// FindMigratedPair returns the address of the pair the curve migrated to, empty when the factory has none yet.
+func (r *launchpadReader) FindMigratedPair(ctx context.Context, pair *model.DexPair, nativeAddress string) (string, businesserror.XSpaceBusinessError) {
+	launchpad, err := r.GetLaunchpad(pair.ChainID, pair.Type)
+	if err != nil {
+		return "", err
+	}
+
+	client, err := r.gethService.GetClient(pair.ChainID)
+	if err != nil {
+		return "", err
+	}
+
+	factory := bind.NewBoundContract(common2.HexToAddress(launchpad.MigrationFactory), r.factoryAbi, client, client, client)
+
+	var out []interface{}
+	basicErr := factory.Call(&bind.CallOpts{Context: ctx}, &out, "getPair",
+		common2.HexToAddress(pair.GetToken().ContractAddress), common2.HexToAddress(nativeAddress))
+	if basicErr != nil {
+		return "", common.NewRuntimeError(fmt.Errorf("factory getPair: %w", basicErr))
+	}
+
+	address := out[0].(common2.Address)
+	if address == (common2.Address{}) {
+		return "", nil
+	}
+	return address.Hex(), nil
+}
+
+// SpotValue values tokens at the last curve price.
+func (s *launchpadCurveSnapshot) SpotValue(amount *big.Int) *big.Int {
+	value := new(big.Int).Mul(amount, s.PriceE18)
+	return value.Div(value, new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil))
+}
+
+// SpotPrice is the last curve price in native wei per token wei.
+func (s *launchpadCurveSnapshot) SpotPrice() *big.Rat {
+	return new(big.Rat).SetFrac(s.PriceE18, new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil))
+}
+
+type LaunchpadMigrationListener interface {
+	// HandleLaunchpadLog resyncs the curve pair of a token whose migration the manager just logged,
+	// the sync moves the token to its dex pair.
+	HandleLaunchpadLog(ctx context.Context, chainID string, log types.Log) businesserror.XSpaceBusinessError
+}
+
+type launchpadMigrationListener struct {
+	pairService     DexPairService
+	assetRepository repository.AssetRepository
+}
+
+func (l *launchpadMigrationListener) HandleLaunchpadLog(ctx context.Context, chainID string, log types.Log) businesserror.XSpaceBusinessError {
+	if len(log.Topics) == 0 || log.Topics[0] != LaunchpadMigrationTopic || len(log.Data) < 32 {
+		return nil
+	}
+
+	pairType, ok := launchpadPairTypeOf(chainID, log.Address)
+	if !ok {
+		return nil
+	}
+
+	tokenAddress := common2.BytesToAddress(log.Data[:32])
+	pair, err := l.assetRepository.TryRetrievePairByContractAddress(ctx, chainID, tokenAddress.Hex())
+	if err != nil {
+		return err
+	}
+	if pair == nil || pair.Type != pairType || len(pair.MigratedPairID) > 0 {
+		return nil
+	}
+
+	return l.pairService.SyncPair(ctx, pair)
+}
+
+// launchpadPairTypeOf finds the launchpad whose manager emitted a log.
+func launchpadPairTypeOf(chainID string, manager common2.Address) (model.PairType, bool) {
+	for pairType, launchpad := range launchpads[chainID] {
+		if strings.EqualFold(launchpad.ManagerAddress, manager.Hex()) {
+			return pairType, true
+		}
+	}
+	return "", false
+}
+
+func NewLaunchpadMigrationListener(
+	pairService DexPairService,
+	assetRepository repository.AssetRepository,
+) LaunchpadMigrationListener {
+	return &launchpadMigrationListener{
+		pairService:     pairService,
+		assetRepository: assetRepository,
+	}
+}
+
+func newLaunchpadReader(gethService evm.GethService) *launchpadReader {
+	return &launchpadReader{
+		launchpadAbi: readAbiResource("./resource/four_meme_abi.json"),
+		factoryAbi:   readAbiResource("./resource/dex_factory_abi.json"),
+		gethService:  gethService,
+	}
+}
@@ -0,0 +1,290 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/common/logger"
+	"github.com/cross-space-official/kaboom-service/common"
+	"github.com/cross-space-official/kaboom-service/model"
+	"github.com/cross-space-official/kaboom-service/service/provider/evm"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	common2 "github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLocksPerLocker bounds the lock entries read for one LP token from one locker.
+const maxLocksPerLocker = 100
+
+type LPLockerKind string
+
+const (
+	LPLockerKindPinkLock    LPLockerKind = "pinklock"
+	LPLockerKindUnicrypt    LPLockerKind = "unicrypt"
+	LPLockerKindTeamFinance LPLockerKind = "team_finance"
+	// LPLockerKindBalance only reads the LP balance held by the locker, the unlock time stays unknown so it is
+	// listed but not counted as locked.
+	LPLockerKindBalance LPLockerKind = "balance"
+)
+
+type LPLocker struct {
+	Name    string
+	Kind    LPLockerKind
+	Address string
+}
+
+var (
+	lpLockersMu sync.RWMutex
+	lpLockers   = map[string][]LPLocker{
+		"1": {
+			{Name: "PinkLock", Kind: LPLockerKindPinkLock, Address: "0x71B5759d73262FBb223956913ecF4ecC51057641"},
+			{Name: "Unicrypt", Kind: LPLockerKindUnicrypt, Address: "0x663A5C229c09b049E36dCc11a9B0d4a8Eb9db214"},
+			{Name: "Team.Finance", Kind: LPLockerKindTeamFinance, Address: "0xE2fE530C047f2d85298b07D9333C05737f1435fB"},
+		},
+		"56": {
+			{Name: "PinkLock", Kind: LPLockerKindPinkLock, Address: "0x407993575c91ce7643a4d4cCACc9A98c36eE1BBE"},
+			{Name: "Unicrypt", Kind: LPLockerKindUnicrypt, Address: "0xC765bddB93b0D1c1A88282BA0fa6B2d00E3e0c83"},
+			{Name: "Team.Finance", Kind: LPLockerKindTeamFinance, Address: "0x0C89C0407775dd89b12918B9c0aa42Bf96518820"},
+			{Name: "Mudra", Kind: LPLockerKindBalance, Address: "0xAE7e6CAbad8d80f0b4E1C4DDE2a5dB7201eF1252"},
+		},
+	}
+)
+
+// RegisterLPLocker adds a locker contract for the chain, or replaces the one with the same address.
+func RegisterLPLocker(chainID string, locker LPLocker) {
+	lpLockersMu.Lock()
+	defer lpLockersMu.Unlock()
+
+	for i, existing := range lpLockers[chainID] {
+		if strings.EqualFold(existing.Address, locker.Address) {
+			lpLockers[chainID][i] = locker
+			return
+		}
+	}
+	lpLockers[chainID] = append(lpLockers[chainID], locker)
+}
+
+func getLPLockers(chainID string) []LPLocker {
+	lpLockersMu.RLock()
+	defer lpLockersMu.RUnlock()
+
+	return append([]LPLocker{}, lpLockers[chainID]...)
+}
+
+type LPLock struct {
+	Locker   string
+	Amount   *big.Int
+	UnlockAt *time.Time
+}
+
+type LPLockInfo struct {
+	Locks []LPLock
+	// LockedSupply only sums locks with a known unlock time, a lock that can end at any time is as good as unlocked.
+	LockedSupply *big.Int
+	// EarliestUnlockAt is nil when no lock with a known unlock time is active.
+	EarliestUnlockAt *time.Time
+}
+
+func (i *LPLockInfo) add(lock LPLock) {
+	if lock.Amount == nil || lock.Amount.Sign() <= 0 {
+		return
+	}
+
+	i.Locks = append(i.Locks, lock)
+	if lock.UnlockAt == nil {
+		return
+	}
+
+	i.LockedSupply.Add(i.LockedSupply, lock.Amount)
+	if i.EarliestUnlockAt == nil || lock.UnlockAt.Before(*i.EarliestUnlockAt) {
+		i.EarliestUnlockAt = lock.UnlockAt
+	}
+}
+
+// pinkLockEntry mirrors IPinkLock.Lock, abi.ConvertType copies the fields by position.
+type pinkLockEntry struct {
+	ID             *big.Int
+	Token          common2.Address
+	Owner          common2.Address
+	Amount         *big.Int
+	LockDate       *big.Int
+	TgeDate        *big.Int
+	TgeBps         *big.Int
+	Cycle          *big.Int
+	CycleBps       *big.Int
+	UnlockedAmount *big.Int
+	Description    string
+}
+
+type lpLockReader struct {
+	lockerAbi   abi.ABI
+	gethService evm.GethService
+}
+
+// ReadLocks sums the LP of the pair that is still locked in the known lockers of the chain.
+// A locker that fails to answer is logged and skipped so the others still count.
+func (r *lpLockReader) ReadLocks(ctx context.Context, pair *model.DexPair) (*LPLockInfo, businesserror.XSpaceBusinessError) {
+	client, err := r.gethService.GetClient(pair.ChainID)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &LPLockInfo{LockedSupply: big.NewInt(0)}
+	lpToken := common2.HexToAddress(pair.ContractAddress)
+	now := time.Now()
+
+	for _, locker := range getLPLockers(pair.ChainID) {
+		contract := bind.NewBoundContract(common2.HexToAddress(locker.Address), r.lockerAbi, client, client, client)
+		opts := &bind.CallOpts{Context: ctx}
+
+		var locks []LPLock
+		switch locker.Kind {
+		case LPLockerKindPinkLock:
+			locks, err = r.readPinkLocks(opts, contract, lpToken)
+		case LPLockerKindUnicrypt:
+			locks, err = r.readUnicryptLocks(opts, contract, lpToken)
+		case LPLockerKindTeamFinance:
+			locks, err = r.readTeamFinanceLocks(opts, contract, lpToken)
+		default:
+			var balance *big.Int
+			balance, err = r.gethService.GetTokenBalance(ctx, pair.ChainID, locker.Address, pair.ContractAddress)
+			locks = []LPLock{{Amount: balance}}
+		}
+		if err != nil {
+			logger.GetLoggerEntry(ctx).
+				WithField("pair_id", pair.ID).
+				WithField("locker", locker.Name).
+				Errorf("error reading lp locks, %v", err)
+			continue
+		}
+
+		for _, lock := range locks {
+			if lock.UnlockAt != nil && !lock.UnlockAt.After(now) {
+				continue
+			}
+			lock.Locker = locker.Name
+			info.add(lock)
+		}
+	}
+
+	return info, nil
+}
+
+func (r *lpLockReader) readPinkLocks(opts *bind.CallOpts, contract *bind.BoundContract, lpToken common2.Address) ([]LPLock, businesserror.XSpaceBusinessError) {
+	var count []interface{}
+	if basicErr := contract.Call(opts, &count, "getTotalLockCountForToken", lpToken); basicErr != nil {
+		return nil, common.NewRuntimeError(basicErr)
+	}
+
+	total := count[0].(*big.Int)
+	if total.Sign() == 0 {
+		return nil, nil
+	}
+
+	end := new(big.Int).Sub(total, big.NewInt(1))
+	start := big.NewInt(0)
+	if total.Cmp(big.NewInt(maxLocksPerLocker)) > 0 {
+		start = new(big.Int).Sub(total, big.NewInt(maxLocksPerLocker))
+	}
+
+	var out []interface{}
+	if basicErr := contract.Call(opts, &out, "getLocksForToken", lpToken, start, end); basicErr != nil {
+		return nil, common.NewRuntimeError(basicErr)
+	}
+
+	var entries []pinkLockEntry
+	if basicErr := convertLockEntries(out[0], &entries); basicErr != nil {
+		return nil, common.NewRuntimeError(basicErr)
+	}
+
+	locks := make([]LPLock, 0, len(entries))
+	for _, entry := range entries {
+		locks = append(locks, LPLock{
+			Amount:   new(big.Int).Sub(entry.Amount, entry.UnlockedAmount),
+			UnlockAt: unixTimeOf(entry.TgeDate),
+		})
+	}
+	return locks, nil
+}
+
+func (r *lpLockReader) readUnicryptLocks(opts *bind.CallOpts, contract *bind.BoundContract, lpToken common2.Address) ([]LPLock, businesserror.XSpaceBusinessError) {
+	var count []interface{}
+	if basicErr := contract.Call(opts, &count, "getNumLocksForToken", lpToken); basicErr != nil {
+		return nil, common.NewRuntimeError(basicErr)
+	}
+
+	total := count[0].(*big.Int).Int64()
+	locks := []LPLock{}
+	for index := int64(0); index < total && index < maxLocksPerLocker; index++ {
+		var out []interface{}
+		if basicErr := contract.Call(opts, &out, "tokenLocks", lpToken, big.NewInt(index)); basicErr != nil {
+			return nil, common.NewRuntimeError(basicErr)
+		}
+
+		locks = append(locks, LPLock{
+			Amount:   out[1].(*big.Int),
+			UnlockAt: unixTimeOf(out[3].(*big.Int)),
+		})
+	}
+	return locks, nil
+}
+
+func (r *lpLockReader) readTeamFinanceLocks(opts *bind.CallOpts, contract *bind.BoundContract, lpToken common2.Address) ([]LPLock, businesserror.XSpaceBusinessError) {
+	var out []interface{}
+	if basicErr := contract.Call(opts, &out, "getDepositsByTokenAddress", lpToken); basicErr != nil {
+		return nil, common.NewRuntimeError(basicErr)
+	}
+
+	depositIDs := out[0].([]*big.Int)
+	if len(depositIDs) > maxLocksPerLocker {
+		depositIDs = depositIDs[len(depositIDs)-maxLocksPerLocker:]
+	}
+
+	locks := []LPLock{}
+	for _, depositID := range depositIDs {
+		var deposit []interface{}
+		if basicErr := contract.Call(opts, &deposit, "lockedToken", depositID); basicErr != nil {
+			return nil, common.NewRuntimeError(basicErr)
+		}
+
+		if deposit[4].(bool) {
+			continue
+		}
+		locks = append(locks, LPLock{
+			Amount:   deposit[2].(*big.Int),
+			UnlockAt: unixTimeOf(deposit[3].(*big.Int)),
+		})
+	}
+	return locks, nil
+}
+
+func convertLockEntries(value interface{}, entries *[]pinkLockEntry) (basicErr error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			basicErr = fmt.Errorf("unexpected lock entries %T", value)
+		}
+	}()
+
+	abi.ConvertType(value, entries)
+	return nil
+}
+
+func unixTimeOf(seconds *big.Int) *time.Time {
+	if seconds == nil || seconds.Sign() == 0 {
+		return nil
+	}
+
+	t := time.Unix(seconds.Int64(), 0)
+	return &t
+}
+
+func newLPLockReader(gethService evm.GethService) *lpLockReader {
+	return &lpLockReader{
+		lockerAbi:   readAbiResource("./resource/lp_locker_abi.json"),
+		gethService: gethService,
+	}
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/kaboom-service/common"
+	"github.com/cross-space-official/kaboom-service/model"
+	"github.com/cross-space-official/kaboom-service/service/provider/evm"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	common2 "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"math/big"
+	"strings"
+)
+
+// PairFactory is the factory a dex deploys its pairs from, pairs are CREATE2 deployed by Deployer with InitCodeHash.
+// Deployer is the factory itself except for pancakeswap v3, which splits the pool deployer out.
+type PairFactory struct {
+	Address      string
+	Deployer     string
+	InitCodeHash string
+}
+
+const (
+	uniSwapV2InitCodeHash     = "0x96e8ac4277198ff8b6f785478aa9a39f403cb768dd02cbee326c3e7da348845f"
+	pancakeSwapV2InitCodeHash = "0x00fb7f630766e6a796048ea87d01acd3068e8ff67d078148a3fa3f4a84f69bd5"
+	// pancakeswap v2 outside bsc was deployed from a rebuilt pair contract
+	pancakeSwapV2MultichainInitCodeHash = "0x57224589c67f3f30a6b0d7a1b54cf3153ab84563bc609ef41dfb34f8b2974d2d"
+	uniSwapV3InitCodeHash               = "0xe34f199b19b2b4f47f68442619d555527d244f78a3297ea89325f843f87b8b54"
+	pancakeSwapV3InitCodeHash           = "0x6ce8eb472fa82df5469c6ab6d485f17c3ad13c8cd7af59b3d4a8026c5ce0f7e2"
+
+	pancakeSwapV3Factory      = "0x0BFbCF9fa4f9C56B0F40a671Ad40E0805A091865"
+	pancakeSwapV3PoolDeployer = "0x41ff9AA7e16B8B1a8a8dc4f0eFacd93D02d071c9"
+)
+
+// pairFactories are the factories provenance is verified against, pairs of a chain or pair type without one are rejected.
+var pairFactories = map[string]map[model.PairType]PairFactory{
+	"1": {
+		model.PairTypePancakeSwapV2: {
+			Address:      "0x1097053Fd2ea711dad45caCcc45EfF7548fCB362",
+			Deployer:     "0x1097053Fd2ea711dad45caCcc45EfF7548fCB362",
+			InitCodeHash: pancakeSwapV2MultichainInitCodeHash,
+		},
+		model.PairTypeUniSwapV2: {
+			Address:      "0x5C69bEe701ef814a2B6a3EDD4B1652CB9cc5aA6f",
+			Deployer:     "0x5C69bEe701ef814a2B6a3EDD4B1652CB9cc5aA6f",
+			InitCodeHash: uniSwapV2InitCodeHash,
+		},
+		model.PairTypeUniSwapV3: {
+			Address:      "0x1F98431c8aD98523631AE4a59f267346ea31F984",
+			Deployer:     "0x1F98431c8aD98523631AE4a59f267346ea31F984",
+			InitCodeHash: uniSwapV3InitCodeHash,
+		},
+		model.PairTypePancakeSwapV3: {
+			Address:      pancakeSwapV3Factory,
+			Deployer:     pancakeSwapV3PoolDeployer,
+			InitCodeHash: pancakeSwapV3InitCodeHash,
+		},
+	},
+	"56": {
+		model.PairTypePancakeSwapV2: {
+			Address:      "0xcA143Ce32Fe78f1f7019d7d551a6402fC5350c73",
+			Deployer:     "0xcA143Ce32Fe78f1f7019d7d551a6402fC5350c73",
+			InitCodeHash: pancakeSwapV2InitCodeHash,
+		},
+		model.PairTypeUniSwapV2: {
+			Address:      "0x8909Dc15e40173Ff4699343b6eB8132c65e18eC6",
+			Deployer:     "0x8909Dc15e40173Ff4699343b6eB8132c65e18eC6",
+			InitCodeHash: uniSwapV2InitCodeHash,
+		},
+		model.PairTypeUniSwapV3: {
+			Address:      "0xdB1d10011AD0Ff90774D0C6Bb92e5C5c8b4461F7",
+			Deployer:     "0xdB1d10011AD0Ff90774D0C6Bb92e5C5c8b4461F7",
+			InitCodeHash: uniSwapV3InitCodeHash,
+		},
+		model.PairTypePancakeSwapV3: {
+			Address:      pancakeSwapV3Factory,
+			Deployer:     pancakeSwapV3PoolDeployer,
+			InitCodeHash: pancakeSwapV3InitCodeHash,
+		},
+	},
+	"8453": {
+		model.PairTypePancakeSwapV2: {
+			Address:      "0x02a84c1b3BBD7401a5f7fa98a384EBC70bB5749E",
+			Deployer:     "0x02a84c1b3BBD7401a5f7fa98a384EBC70bB5749E",
+			InitCodeHash: pancakeSwapV2MultichainInitCodeHash,
+		},
+		model.PairTypeUniSwapV2: {
+			Address:      "0x8909Dc15e40173Ff4699343b6eB8132c65e18eC6",
+			Deployer:     "0x8909Dc15e40173Ff4699343b6eB8132c65e18eC6",
+			InitCodeHash: uniSwapV2InitCodeHash,
+		},
+		model.PairTypeUniSwapV3: {
+			Address:      "0x33128a8fC17869897dcE68Ed026d694621f6FDfD",
+			Deployer:     "0x33128a8fC17869897dcE68Ed026d694621f6FDfD",
+			InitCodeHash: uniSwapV3InitCodeHash,
+		},
+		model.PairTypePancakeSwapV3: {
+			Address:      pancakeSwapV3Factory,
+			Deployer:     pancakeSwapV3PoolDeployer,
+			InitCodeHash: pancakeSwapV3InitCodeHash,
+		},
+	},
+}
+
+type pairProvenanceVerifier struct {
+	factoryAbi  abi.ABI
+	gethService evm.GethService
+	launchpads  *launchpadReader
+}
+
+// Verifiable tells whether Verify has a factory or launchpad to check pairs of the chain and type against.
+func (v *pairProvenanceVerifier) Verifiable(chainID string, pairType model.PairType) bool {
+	if isLaunchpadPairType(pairType) {
+		_, ok := launchpads[chainID][pairType]
+		return ok
+	}
+
+	_, ok := pairFactories[chainID][pairType]
+	return ok
+}
+
+// Verify rejects a pair the configured factory did not deploy: the factory has to map the pair tokens to pairAddress
+// and pairAddress has to be the CREATE2 address of those tokens under the factory's init code hash.
+// A chain and pair type without a configured factory cannot be verified and is rejected as well.
+func (v *pairProvenanceVerifier) Verify(ctx context.Context, chainID string, pairType model.PairType, pairAddress string, state *pairOnchainState) businesserror.XSpaceBusinessError {
+	if isLaunchpadPairType(pairType) {
+		return v.verifyLaunchpad(ctx, chainID, pairType, pairAddress)
+	}
+
+	factory, ok := pairFactories[chainID][pairType]
+	if !ok {
+		return common.NewRuntimeError(fmt.Errorf("pair %s cannot be verified, no %s factory is configured on chain %s",
+			pairAddress, pairType, chainID))
+	}
+
+	pair := common2.HexToAddress(pairAddress)
+	registered, err := v.factoryPair(ctx, chainID, pairType, factory, state)
+	if err != nil {
+		return err
+	}
+	if registered != pair {
+		return common.NewRuntimeError(fmt.Errorf("pair %s is not registered in the %s factory, it maps its tokens to %s",
+			pairAddress, pairType, registered.Hex()))
+	}
+
+	if expected := pairCreate2Address(pairType, factory, state); expected != pair {
+		return common.NewRuntimeError(fmt.Errorf("pair %s does not match the %s init code hash, expected %s",
+			pairAddress, pairType, expected.Hex()))
+	}
+
+	return nil
+}
+
+func (v *pairProvenanceVerifier) factoryPair(ctx context.Context, chainID string, pairType model.PairType, factory PairFactory, state *pairOnchainState) (common2.Address, businesserror.XSpaceBusinessError) {
+	client, err := v.gethService.GetClient(chainID)
+	if err != nil {
+		return common2.Address{}, err
+	}
+
+	contract := bind.NewBoundContract(common2.HexToAddress(factory.Address), v.factoryAbi, client, client, client)
+	opts := &bind.CallOpts{Context: ctx}
+
+	var out []interface{}
+	var basicErr error
+	if isV3PairType(pairType) {
+		basicErr = contract.Call(opts, &out, "getPool", state.Token0, state.Token1, new(big.Int).SetUint64(uint64(state.FeeTier)))
+	} else {
+		basicErr = contract.Call(opts, &out, "getPair", state.Token0, state.Token1)
+	}
+	if basicErr != nil {
+		return common2.Address{}, common.NewRuntimeError(fmt.Errorf("%s factory lookup: %w", pairType, basicErr))
+	}
+
+	return out[0].(common2.Address), nil
+}
+
+// verifyLaunchpad checks the configured manager is the one holding the curve, the pair address is the token itself.
+func (v *pairProvenanceVerifier) verifyLaunchpad(ctx context.Context, chainID string, pairType model.PairType, tokenAddress string) businesserror.XSpaceBusinessError {
+	launchpad, err := v.launchpads.GetLaunchpad(chainID, pairType)
+	if err != nil {
+		return err
+	}
+
+	curve, err := v.launchpads.ReadCurve(ctx, chainID, pairType, tokenAddress)
+	if err != nil {
+		return err
+	}
+
+	if !strings.EqualFold(curve.Manager.Hex(), launchpad.ManagerAddress) {
+		return common.NewRuntimeError(fmt.Errorf("token %s is managed by %s, not the %s manager",
+			tokenAddress, curve.Manager.Hex(), launchpad.Name))
+	}
+	return nil
+}
+
+// pairCreate2Address derives the pair address from its sorted tokens, v3 pools also salt with the fee tier.
+func pairCreate2Address(pairType model.PairType, factory PairFactory, state *pairOnchainState) common2.Address {
+	token0, token1 := state.Token0, state.Token1
+	if bytes.Compare(token0.Bytes(), token1.Bytes()) > 0 {
+		token0, token1 = token1, token0
+	}
+
+	var salt common2.Hash
+	if isV3PairType(pairType) {
+		salt = crypto.Keccak256Hash(
+			common2.LeftPadBytes(token0.Bytes(), 32),
+			common2.LeftPadBytes(token1.Bytes(), 32),
+			common2.LeftPadBytes(new(big.Int).SetUint64(uint64(state.FeeTier)).Bytes(), 32))
+	} else {
+		salt = crypto.Keccak256Hash(token0.Bytes(), token1.Bytes())
+	}
+
+	return crypto.CreateAddress2(common2.HexToAddress(factory.Deployer), salt, common2.FromHex(factory.InitCodeHash))
+}
+
+func newPairProvenanceVerifier(gethService evm.GethService, launchpads *launchpadReader) *pairProvenanceVerifier {
+	return &pairProvenanceVerifier{
+		factoryAbi:  readAbiResource("./resource/dex_factory_abi.json"),
+		gethService: gethService,
+		launchpads:  launchpads,
+	}
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"fmt"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/kaboom-service/model"
+	"github.com/shopspring/decimal"
+	"math/big"
+	"time"
+)
+
+type PublishPairStatus string
+
+const (
+	PublishPairStatusPendingReview PublishPairStatus = "pending_review"
+	PublishPairStatusSkipped       PublishPairStatus = "skipped"
+	PublishPairStatusFailed        PublishPairStatus = "failed"
+)
+
+type PublishPairResult struct {
+	PairAddress string            `json:"pairAddress"`
+	PairID      string            `json:"pairId,omitempty"`
+	Status      PublishPairStatus `json:"status"`
+	Reason      string            `json:"reason,omitempty"`
+}
+
+func (r *PublishPairResult) skip(reason string) *PublishPairResult {
+	r.Status = PublishPairStatusSkipped
+	r.Reason = reason
+	return r
+}
+
+func (r *PublishPairResult) fail(err businesserror.XSpaceBusinessError) *PublishPairResult {
+	r.Status = PublishPairStatusFailed
+	r.Reason = err.Error()
+	return r
+}
+
+type PublishThresholds struct {
+	// MinLiquidityInNative is in whole native tokens, e.g. 1 BNB.
+	MinLiquidityInNative decimal.Decimal
+	// MinBurnedLiquidity is the burned or locked share of LP supply in model.PercentageBase units.
+	MinBurnedLiquidity int64
+	// MinLockRemaining is how long locked LP must stay locked to count towards MinBurnedLiquidity.
+	MinLockRemaining time.Duration
+	RequireRenounced bool
+	// RejectHoneypot skips pairs whose token did not pass the safety simulation, an unknown or missing result included.
+	RejectHoneypot bool
+	// MaxBuyTax and MaxSellTax are in model.PercentageBase units, zero disables the check.
+	MaxBuyTax  int64
+	MaxSellTax int64
+	// MaxRiskScore is the highest accepted TokenRiskReport score, zero disables the check.
+	MaxRiskScore int
+	// MinHolders is the fewest token holders accepted, zero disables the check.
+	// Screening skips it for tokens whose holders cannot be counted, see tokenHolderCounter.
+	MinHolders int64
+}
+
+func DefaultPublishThresholds() PublishThresholds {
+	return PublishThresholds{
+		MinLiquidityInNative: decimal.NewFromInt(1),
+		RejectHoneypot:       true,
+		MinHolders:           10,
+	}
+}
+
+// Check returns the reason the pair must not be published, or an empty string.
+// liquidityInNative is the quote side reserve converted to native wei.
+func (t PublishThresholds) Check(pair *model.DexPair, liquidityInNative *big.Int) string {
+	if pair.IsRugged {
+		return "pair rugged: " + pair.RugReason
+	}
+
+	if len(pair.MigratedPairID) > 0 {
+		return "launchpad token migrated"
+	}
+
+	minLiquidityInWei := t.MinLiquidityInNative.Mul(model.GetChainNativeByID(pair.ChainID))
+	if decimal.NewFromBigInt(liquidityInNative, 0).LessThan(minLiquidityInWei) {
+		return fmt.Sprintf("liquidity below %s native", t.MinLiquidityInNative.String())
+	}
+
+	// a launchpad curve has no lp tokens, the manager holds the liquidity until migration
+	if t.MinBurnedLiquidity > 0 && !isV3PairType(pair.Type) && !isLaunchpadPairType(pair.Type) {
+		totalSupply := bigIntOf(pair.TotalSupply)
+		if totalSupply.Sign() == 0 {
+			return "unknown lp supply"
+		}
+
+		safeSupply := bigIntOf(pair.BurnedSupply)
+		if pair.LockedUntil != nil && pair.LockedUntil.After(time.Now().Add(t.MinLockRemaining)) {
+			safeSupply.Add(safeSupply, bigIntOf(pair.LockedSupply))
+		}
+		if shareOf(safeSupply, totalSupply) < t.MinBurnedLiquidity {
+			return "burned and locked liquidity below threshold"
+		}
+	}
+
+	if t.RequireRenounced && !pair.GetToken().IsRenounced {
+		return "ownership not renounced"
+	}
+
+	token := pair.GetToken()
+	if t.RejectHoneypot && token.SafetyStatus != string(TokenSafetyStatusPassed) {
+		return "token did not pass safety simulation"
+	}
+
+	if t.MaxBuyTax > 0 && token.BuyTax > t.MaxBuyTax {
+		return "buy tax above threshold"
+	}
+
+	if t.MaxSellTax > 0 && token.SellTax > t.MaxSellTax {
+		return "sell tax above threshold"
+	}
+
+	if t.MaxRiskScore > 0 && token.RiskScore > t.MaxRiskScore {
+		return "contract risk score above threshold"
+	}
+
+	if t.MinHolders > 0 && token.HolderCount < t.MinHolders {
+		return "holders below threshold"
+	}
+
+	return ""
+}
+
+func bigIntOf(value model.BigInt) *big.Int {
+	return new(big.Int).Set(&value.Int)
+}
+
+// shareOf returns part over total in model.PercentageBase units.
+func shareOf(part, total *big.Int) int64 {
+	if total.Sign() == 0 {
+		return 0
+	}
+
+	share := new(big.Int).Mul(part, big.NewInt(model.PercentageBase))
+	return share.Div(share, total).Int64()
+}
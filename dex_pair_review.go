@@ -0,0 +1,236 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/kaboom-service/common"
+	"github.com/cross-space-official/kaboom-service/model"
+	"github.com/cross-space-official/kaboom-service/repository"
+	"strings"
+	"time"
+)
+
+// A pair is discovered when created, screened once the automatic checks pass, then waits in pending review
+// until a reviewer publishes or rejects it. Only published pairs are IsPublished.
+const (
+	PairReviewStateDiscovered    = "discovered"
+	PairReviewStateScreened      = "screened"
+	PairReviewStatePendingReview = "pending_review"
+	PairReviewStatePublished     = "published"
+	PairReviewStateRejected      = "rejected"
+)
+
+// PairReviewActorSystem decides the automatic transitions, reviewers are recorded by their user id.
+const PairReviewActorSystem = "system"
+
+// pairScreeningRejection prefixes the reason of a screening rejection, the only system rejection a pair comes back
+// from. Rugged and migrated pairs are rejected for good.
+const pairScreeningRejection = "screening failed, "
+
+var pairReviewTransitions = map[string][]string{
+	PairReviewStateDiscovered:    {PairReviewStateScreened, PairReviewStateRejected},
+	PairReviewStateScreened:      {PairReviewStatePendingReview, PairReviewStateRejected},
+	PairReviewStatePendingReview: {PairReviewStatePublished, PairReviewStateRejected},
+	PairReviewStatePublished:     {PairReviewStateRejected},
+	// a rejected pair can only start over
+	PairReviewStateRejected: {PairReviewStateDiscovered},
+}
+
+// reviewStateOf reads the state of a pair, pairs from before the review workflow have none and follow IsPublished.
+func reviewStateOf(pair *model.DexPair) string {
+	if len(pair.ReviewState) > 0 {
+		return pair.ReviewState
+	}
+	if pair.IsPublished {
+		return PairReviewStatePublished
+	}
+	return PairReviewStateDiscovered
+}
+
+func canTransitionReview(from, to string) bool {
+	for _, allowed := range pairReviewTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+type pairReviewMachine struct {
+	assetRepository repository.AssetRepository
+}
+
+// Transition moves the pair to state and appends who decided it and why to the pair's review trail.
+// The repository only applies it while the stored state is still the one read, so concurrent decisions cannot both win.
+func (m *pairReviewMachine) Transition(ctx context.Context, pair *model.DexPair, state, actor, reason string) businesserror.XSpaceBusinessError {
+	from := reviewStateOf(pair)
+	if !canTransitionReview(from, state) {
+		return common.NewRuntimeError(fmt.Errorf("pair %s cannot move from %s to %s", pair.ID, from, state))
+	}
+
+	now := time.Now()
+	event := &model.PairReviewEvent{
+		PairID:    pair.ID,
+		FromState: from,
+		ToState:   state,
+		Actor:     actor,
+		Reason:    reason,
+		CreatedAt: now,
+	}
+
+	previous := *pair
+	pair.ReviewState = state
+	pair.ReviewedBy = actor
+	pair.ReviewReason = reason
+	pair.ReviewedAt = &now
+	pair.IsPublished = state == PairReviewStatePublished
+	if !pair.IsPublished {
+		pair.ForcePublish = false
+	}
+
+	err := m.assetRepository.UpdatePairReviewState(ctx, pair, event)
+	if err != nil {
+		*pair = previous
+		return err
+	}
+	return nil
+}
+
+// Reject takes the pair out of the workflow from any state, a pair already rejected is left as is.
+func (m *pairReviewMachine) Reject(ctx context.Context, pair *model.DexPair, actor, reason string) businesserror.XSpaceBusinessError {
+	if reviewStateOf(pair) == PairReviewStateRejected {
+		return nil
+	}
+	return m.Transition(ctx, pair, PairReviewStateRejected, actor, reason)
+}
+
+// ReviewPair publishes or rejects a pair waiting in the review queue, reviewer is the deciding user.
+func (d *dexEvmPairService) ReviewPair(ctx context.Context, pairID, reviewer string, approve bool, reason string) (*model.DexPair, businesserror.XSpaceBusinessError) {
+	pair, err := d.assetRepository.RetrievePairByPairID(ctx, pairID)
+	if err != nil {
+		return nil, err
+	}
+
+	if state := reviewStateOf(pair); state != PairReviewStatePendingReview {
+		return nil, common.NewRuntimeError(fmt.Errorf("pair %s is %s, not pending review", pairID, state))
+	}
+
+	if !approve {
+		return pair, d.reviews.Transition(ctx, pair, PairReviewStateRejected, reviewer, reason)
+	}
+
+	// the pair may have rugged or migrated while it waited
+	if pair.IsRugged || len(pair.MigratedPairID) > 0 {
+		return nil, common.NewRuntimeError(fmt.Errorf("pair %s can no longer be published", pairID))
+	}
+	return pair, d.reviews.Transition(ctx, pair, PairReviewStatePublished, reviewer, reason)
+}
+
+// GetPairReviewQueue returns the pairs of the chain waiting for a reviewer, oldest first.
+func (d *dexEvmPairService) GetPairReviewQueue(ctx context.Context, chainID string, limit int) ([]*model.DexPair, businesserror.XSpaceBusinessError) {
+	return d.assetRepository.RetrievePairsByReviewState(ctx, chainID, PairReviewStatePendingReview, limit)
+}
+
+// GetPairReviewHistory returns every review decision taken on the pair, oldest first.
+func (d *dexEvmPairService) GetPairReviewHistory(ctx context.Context, pairID string) ([]*model.PairReviewEvent, businesserror.XSpaceBusinessError) {
+	return d.assetRepository.RetrievePairReviewEvents(ctx, pairID)
+}
+
+// isScreeningRejection tells a pair the screening rejected, and which may pass it later, from one rejected for good.
+func isScreeningRejection(pair *model.DexPair) bool {
+	return reviewStateOf(pair) == PairReviewStateRejected &&
+		pair.ReviewedBy == PairReviewActorSystem &&
+		strings.HasPrefix(pair.ReviewReason, pairScreeningRejection) &&
+		!pair.IsRugged && len(pair.MigratedPairID) == 0
+}
+
+// advanceReview takes the pair through the automatic steps: a pair the screening rejected before starts over,
+// a discovered pair is screened and, with submit, a screened pair enters the review queue.
+// It returns why the pair stopped short, empty when it moved as far as asked or is already further.
+func (d *dexEvmPairService) advanceReview(ctx context.Context, pair *model.DexPair, submit bool) (string, businesserror.XSpaceBusinessError) {
+	state := reviewStateOf(pair)
+	if isScreeningRejection(pair) {
+		err := d.reviews.Transition(ctx, pair, PairReviewStateDiscovered, PairReviewActorSystem, "resubmitted for screening")
+		if err != nil {
+			return "", err
+		}
+		state = PairReviewStateDiscovered
+	}
+
+	if state == PairReviewStateDiscovered {
+		reason, err := d.screenPair(ctx, pair)
+		if err != nil || len(reason) > 0 {
+			return reason, err
+		}
+		state = PairReviewStateScreened
+	}
+
+	switch state {
+	case PairReviewStateScreened:
+		if !submit {
+			return "", nil
+		}
+		return "", d.reviews.Transition(ctx, pair, PairReviewStatePendingReview, PairReviewActorSystem, "submitted for review")
+	case PairReviewStateRejected:
+		return "rejected in review: " + pair.ReviewReason, nil
+	default:
+		return "", nil
+	}
+}
+
+// screenPair runs the safety simulation and publish thresholds on a discovered pair, it returns the rejection reason.
+// A pair stays discovered only when a check returns an error. A simulation that runs but cannot measure the token
+// comes back unknown, which RejectHoneypot rejects like a honeypot, submitting the pair again screens it again.
+func (d *dexEvmPairService) screenPair(ctx context.Context, pair *model.DexPair) (string, businesserror.XSpaceBusinessError) {
+	safety, err := d.safetySimulator.SimulateAndStore(ctx, pair)
+	if err != nil {
+		return "", err
+	}
+	logSafetyResult(ctx, pair, safety)
+
+	liquidityInNative, err := d.liquidityInNative(ctx, pair)
+	if err != nil {
+		return "", err
+	}
+
+	thresholds := d.publishThresholds
+	if thresholds.MinHolders > 0 {
+		known, err := d.countHolders(ctx, pair)
+		if err != nil {
+			return "", err
+		}
+		if !known {
+			thresholds.MinHolders = 0
+		}
+	}
+
+	if reason := thresholds.Check(pair, liquidityInNative); len(reason) > 0 {
+		return reason, d.reviews.Transition(ctx, pair, PairReviewStateRejected, PairReviewActorSystem, pairScreeningRejection+reason)
+	}
+	return "", d.reviews.Transition(ctx, pair, PairReviewStateScreened, PairReviewActorSystem, "passed screening")
+}
+
+// countHolders stores the holder count of the pair's token, it reports whether the holders could be counted.
+func (d *dexEvmPairService) countHolders(ctx context.Context, pair *model.DexPair) (bool, businesserror.XSpaceBusinessError) {
+	token := pair.GetToken()
+	count, known, err := d.holders.Count(ctx, pair.ChainID, token)
+	if err != nil || !known {
+		return false, err
+	}
+
+	token.HolderCount = count
+	err = d.assetRepository.UpdateToken(ctx, &token)
+	if err != nil {
+		return false, err
+	}
+
+	setPairToken(pair, token)
+	return true, nil
+}
+
+func newPairReviewMachine(assetRepository repository.AssetRepository) *pairReviewMachine {
+	return &pairReviewMachine{
+		assetRepository: assetRepository,
+	}
+}
@@ -0,0 +1,440 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/kaboom-service/common"
+	"github.com/cross-space-official/kaboom-service/core"
+	"github.com/cross-space-official/kaboom-service/model"
+	"github.com/cross-space-official/kaboom-service/service/provider/evm"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	common2 "github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	v3MaxTickWordLoads = 64
+	// v3QuoteTTL is how long Quote reuses a pool read, about a block, a preview or a routed trade quotes the same pool many times.
+	v3QuoteTTL = 3 * time.Second
+)
+
+// v3SwapRouterAddresses are the built in routers of ChainMetadata.DexRouters, read them through the chain metadata cache.
+var v3SwapRouterAddresses = map[string]map[model.PairType]string{
+	"1": {
+		model.PairTypeUniSwapV3:     "0x68b3465833fb72A70ecDF485E0e4C7bD8665Fc45",
+		model.PairTypePancakeSwapV3: "0x13f4EA83D0bd40E75C8222255bc855a974568Dd4",
+	},
+	"56": {
+		model.PairTypeUniSwapV3:     "0xB971eF87ede563556b2ED4b1C0b0019111Dd85d2",
+		model.PairTypePancakeSwapV3: "0x13f4EA83D0bd40E75C8222255bc855a974568Dd4",
+	},
+	"8453": {
+		model.PairTypeUniSwapV3:     "0x2626664c2603336E57B271c5C0b26F421741e481",
+		model.PairTypePancakeSwapV3: "0x13f4EA83D0bd40E75C8222255bc855a974568Dd4",
+	},
+}
+
+func isV3PairType(pairType model.PairType) bool {
+	return pairType == model.PairTypePancakeSwapV3 || pairType == model.PairTypeUniSwapV3
+}
+
+// isTokenToken0 tells whether the traded token, not the native one, sorts first in the pool.
+func isTokenToken0(pair *model.DexPair) bool {
+	return pair.Token0ID == pair.GetToken().ID
+}
+
+type v3PoolSnapshot struct {
+	core.V3PoolState
+	Token0   common2.Address
+	Token1   common2.Address
+	Balance0 *big.Int
+	Balance1 *big.Int
+}
+
+// v3QuotePool is a pool read shared by the quotes of the next v3QuoteTTL.
+type v3QuotePool struct {
+	state    core.V3PoolState
+	ticks    *v3TickCache
+	expireAt time.Time
+}
+
+type v3PoolReader struct {
+	poolAbi     abi.ABI
+	routerAbi   abi.ABI
+	gethService evm.GethService
+
+	mu         sync.Mutex
+	quotePools map[string]*v3QuotePool
+}
+
+func (r *v3PoolReader) ReadPool(ctx context.Context, chainID, poolAddress string) (*v3PoolSnapshot, businesserror.XSpaceBusinessError) {
+	snapshot, err := r.readPoolState(ctx, chainID, poolAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	balance0, err := r.gethService.GetTokenBalance(ctx, chainID, poolAddress, snapshot.Token0.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	balance1, err := r.gethService.GetTokenBalance(ctx, chainID, poolAddress, snapshot.Token1.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot.Balance0 = balance0
+	snapshot.Balance1 = balance1
+
+	return snapshot, nil
+}
+
+// readPoolState reads everything but the pool balances, which is all a swap simulation needs.
+func (r *v3PoolReader) readPoolState(ctx context.Context, chainID, poolAddress string) (*v3PoolSnapshot, businesserror.XSpaceBusinessError) {
+	contract, err := r.bindPool(chainID, poolAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &bind.CallOpts{Context: ctx}
+	snapshot := &v3PoolSnapshot{}
+
+	var token0, token1, fee, tickSpacing, liquidity, slot0 []interface{}
+	for _, call := range []struct {
+		method string
+		out    *[]interface{}
+	}{
+		{"token0", &token0},
+		{"token1", &token1},
+		{"fee", &fee},
+		{"tickSpacing", &tickSpacing},
+		{"liquidity", &liquidity},
+		{"slot0", &slot0},
+	} {
+		if basicErr := contract.Call(opts, call.out, call.method); basicErr != nil {
+			return nil, common.NewRuntimeError(fmt.Errorf("v3 pool %s: %w", call.method, basicErr))
+		}
+	}
+
+	snapshot.Token0 = token0[0].(common2.Address)
+	snapshot.Token1 = token1[0].(common2.Address)
+	snapshot.Fee = uint32(fee[0].(*big.Int).Uint64())
+	snapshot.TickSpacing = int(tickSpacing[0].(*big.Int).Int64())
+	snapshot.Liquidity = liquidity[0].(*big.Int)
+	snapshot.SqrtPriceX96 = slot0[0].(*big.Int)
+	snapshot.Tick = int(slot0[1].(*big.Int).Int64())
+
+	return snapshot, nil
+}
+
+// quotePool returns the pool as read within the last v3QuoteTTL, or reads it again.
+func (r *v3PoolReader) quotePool(ctx context.Context, pair *model.DexPair) (*v3QuotePool, businesserror.XSpaceBusinessError) {
+	key := pair.ChainID + ":" + strings.ToLower(pair.ContractAddress)
+	now := time.Now()
+
+	r.mu.Lock()
+	pool, ok := r.quotePools[key]
+	r.mu.Unlock()
+	if ok && now.Before(pool.expireAt) {
+		return pool, nil
+	}
+
+	snapshot, err := r.readPoolState(ctx, pair.ChainID, pair.ContractAddress)
+	if err != nil {
+		return nil, err
+	}
+	pool = &v3QuotePool{state: snapshot.V3PoolState, ticks: newV3TickCache(), expireAt: now.Add(v3QuoteTTL)}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for cachedKey, cached := range r.quotePools {
+		if !now.Before(cached.expireAt) {
+			delete(r.quotePools, cachedKey)
+		}
+	}
+	r.quotePools[key] = pool
+
+	return pool, nil
+}
+
+// Quote simulates a swap against the live pool, amount is the input when exactInput and the output otherwise.
+func (r *v3PoolReader) Quote(ctx context.Context, pair *model.DexPair, zeroForOne, exactInput bool, amount *big.Int) (*big.Int, businesserror.XSpaceBusinessError) {
+	if amount == nil || amount.Sign() <= 0 {
+		return big.NewInt(0), nil
+	}
+
+	pool, err := r.quotePool(ctx, pair)
+	if err != nil {
+		return nil, err
+	}
+
+	ticks, err := r.tickLoader(ctx, pair, pool.state.TickSpacing, pool.ticks)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *core.V3SwapResult
+	var basicErr error
+	if exactInput {
+		result, basicErr = core.V3SwapExactInput(pool.state, ticks, zeroForOne, amount)
+	} else {
+		result, basicErr = core.V3SwapExactOutput(pool.state, ticks, zeroForOne, amount)
+	}
+	if basicErr != nil {
+		return nil, common.NewRuntimeError(basicErr)
+	}
+
+	if exactInput {
+		return result.AmountOut, nil
+	}
+	return result.AmountIn, nil
+}
+
+// SwapToPrice returns the input, fee included, that moves the pool price by priceFactor in the swap direction.
+func (r *v3PoolReader) SwapToPrice(ctx context.Context, pair *model.DexPair, snapshot *v3PoolSnapshot, zeroForOne bool, priceFactor *big.Float) (*big.Int, businesserror.XSpaceBusinessError) {
+	ticks, err := r.tickLoader(ctx, pair, snapshot.TickSpacing, newV3TickCache())
+	if err != nil {
+		return nil, err
+	}
+
+	target := core.ScaleSqrtPriceX96(snapshot.SqrtPriceX96, priceFactor)
+	result, basicErr := core.V3SwapToPrice(snapshot.V3PoolState, ticks, zeroForOne, target)
+	if basicErr != nil {
+		return nil, common.NewRuntimeError(basicErr)
+	}
+
+	return result.AmountIn, nil
+}
+
+func (r *v3PoolReader) tickLoader(ctx context.Context, pair *model.DexPair, tickSpacing int, cache *v3TickCache) (*v3TickLoader, businesserror.XSpaceBusinessError) {
+	contract, err := r.bindPool(pair.ChainID, pair.ContractAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v3TickLoader{
+		opts:        &bind.CallOpts{Context: ctx},
+		contract:    contract,
+		tickSpacing: tickSpacing,
+		cache:       cache,
+		walked:      map[int]struct{}{},
+	}, nil
+}
+
+// PackBuyData wraps the native value into exactInputSingle and refunds any dust back to the sender.
+func (r *v3PoolReader) PackBuyData(pair *model.DexPair, recipient string, amountInWei, minimalOutAmountInWei *big.Int) ([]byte, businesserror.XSpaceBusinessError) {
+	swap, err := r.packExactInputSingle(pair, getQuoteToken(pair).ContractAddress, pair.GetToken().ContractAddress,
+		recipient, amountInWei, minimalOutAmountInWei)
+	if err != nil {
+		return nil, err
+	}
+
+	refund, basicErr := r.routerAbi.Pack("refundETH")
+	if basicErr != nil {
+		return nil, common.NewRuntimeError(basicErr)
+	}
+
+	return r.packMulticall(swap, refund)
+}
+
+// PackSellData swaps into wrapped native held by routerAddress and unwraps it to the recipient.
+func (r *v3PoolReader) PackSellData(pair *model.DexPair, routerAddress, recipient string, amountInWei, minimalOutAmountInWei *big.Int) ([]byte, businesserror.XSpaceBusinessError) {
+	swap, err := r.packExactInputSingle(pair, pair.GetToken().ContractAddress, getQuoteToken(pair).ContractAddress,
+		routerAddress, amountInWei, minimalOutAmountInWei)
+	if err != nil {
+		return nil, err
+	}
+
+	unwrap, basicErr := r.routerAbi.Pack("unwrapWETH9", minimalOutAmountInWei, common2.HexToAddress(recipient))
+	if basicErr != nil {
+		return nil, common.NewRuntimeError(basicErr)
+	}
+
+	return r.packMulticall(swap, unwrap)
+}
+
+func (r *v3PoolReader) packExactInputSingle(pair *model.DexPair, tokenIn, tokenOut, recipient string, amountInWei, minimalOutAmountInWei *big.Int) ([]byte, businesserror.XSpaceBusinessError) {
+	if pair.FeeTier == 0 {
+		return nil, common.NewRuntimeError(errors.New("v3 pair has no fee tier"))
+	}
+
+	params := struct {
+		TokenIn           common2.Address
+		TokenOut          common2.Address
+		Fee               *big.Int
+		Recipient         common2.Address
+		AmountIn          *big.Int
+		AmountOutMinimum  *big.Int
+		SqrtPriceLimitX96 *big.Int
+	}{
+		TokenIn:           common2.HexToAddress(tokenIn),
+		TokenOut:          common2.HexToAddress(tokenOut),
+		Fee:               big.NewInt(int64(pair.FeeTier)),
+		Recipient:         common2.HexToAddress(recipient),
+		AmountIn:          amountInWei,
+		AmountOutMinimum:  minimalOutAmountInWei,
+		SqrtPriceLimitX96: big.NewInt(0),
+	}
+
+	raw, err := r.routerAbi.Pack("exactInputSingle", params)
+	if err != nil {
+		return nil, common.NewRuntimeError(err)
+	}
+	return raw, nil
+}
+
+func (r *v3PoolReader) packMulticall(calls ...[]byte) ([]byte, businesserror.XSpaceBusinessError) {
+	ddl := time.Now().Add(10 * time.Minute).Unix()
+	raw, err := r.routerAbi.Pack("multicall", big.NewInt(ddl), calls)
+	if err != nil {
+		return nil, common.NewRuntimeError(err)
+	}
+	return raw, nil
+}
+
+func (r *v3PoolReader) bindPool(chainID, poolAddress string) (*bind.BoundContract, businesserror.XSpaceBusinessError) {
+	client, err := r.gethService.GetClient(chainID)
+	if err != nil {
+		return nil, err
+	}
+
+	return bind.NewBoundContract(common2.HexToAddress(poolAddress), r.poolAbi, client, client, client), nil
+}
+
+// v3TickCache keeps the tick words and liquidity read from one pool state, quotes of that state share it.
+type v3TickCache struct {
+	mu           sync.Mutex
+	words        map[int]*big.Int
+	liquidityNet map[int]*big.Int
+}
+
+func newV3TickCache() *v3TickCache {
+	return &v3TickCache{
+		words:        map[int]*big.Int{},
+		liquidityNet: map[int]*big.Int{},
+	}
+}
+
+// v3TickLoader lazily reads tickBitmap words and tick liquidity from the pool while a swap is simulated.
+type v3TickLoader struct {
+	opts        *bind.CallOpts
+	contract    *bind.BoundContract
+	tickSpacing int
+	cache       *v3TickCache
+	// walked bounds the words one swap walks, cached or not
+	walked map[int]struct{}
+}
+
+func (l *v3TickLoader) NextInitializedTickWithinOneWord(tick int, lte bool) (int, bool, error) {
+	compressed := tick / l.tickSpacing
+	if tick < 0 && tick%l.tickSpacing != 0 {
+		compressed--
+	}
+
+	if lte {
+		wordPos, bitPos := compressed>>8, uint(compressed&0xff)
+		word, err := l.word(wordPos)
+		if err != nil {
+			return 0, false, err
+		}
+
+		mask := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), bitPos+1), big.NewInt(1))
+		masked := mask.And(mask, word)
+		if masked.Sign() != 0 {
+			return (compressed - int(bitPos) + masked.BitLen() - 1) * l.tickSpacing, true, nil
+		}
+		return (compressed - int(bitPos)) * l.tickSpacing, false, nil
+	}
+
+	wordPos, bitPos := (compressed+1)>>8, uint((compressed+1)&0xff)
+	word, err := l.word(wordPos)
+	if err != nil {
+		return 0, false, err
+	}
+
+	masked := new(big.Int).Rsh(word, bitPos)
+	if masked.Sign() != 0 {
+		return (compressed + 1 + int(masked.TrailingZeroBits())) * l.tickSpacing, true, nil
+	}
+	return (compressed + 1 + 255 - int(bitPos)) * l.tickSpacing, false, nil
+}
+
+func (l *v3TickLoader) LiquidityNet(tick int) (*big.Int, error) {
+	l.cache.mu.Lock()
+	liquidityNet, ok := l.cache.liquidityNet[tick]
+	l.cache.mu.Unlock()
+	if ok {
+		return liquidityNet, nil
+	}
+
+	var out []interface{}
+	if err := l.contract.Call(l.opts, &out, "ticks", big.NewInt(int64(tick))); err != nil {
+		return nil, err
+	}
+
+	liquidityNet = out[1].(*big.Int)
+	l.cache.mu.Lock()
+	l.cache.liquidityNet[tick] = liquidityNet
+	l.cache.mu.Unlock()
+	return liquidityNet, nil
+}
+
+func (l *v3TickLoader) word(wordPos int) (*big.Int, error) {
+	if _, ok := l.walked[wordPos]; !ok {
+		if len(l.walked) >= v3MaxTickWordLoads {
+			return nil, core.ErrV3InsufficientLiquidity
+		}
+		l.walked[wordPos] = struct{}{}
+	}
+
+	l.cache.mu.Lock()
+	word, ok := l.cache.words[wordPos]
+	l.cache.mu.Unlock()
+	if ok {
+		return word, nil
+	}
+
+	var out []interface{}
+	if err := l.contract.Call(l.opts, &out, "tickBitmap", int16(wordPos)); err != nil {
+		return nil, err
+	}
+
+	word = out[0].(*big.Int)
+	l.cache.mu.Lock()
+	l.cache.words[wordPos] = word
+	l.cache.mu.Unlock()
+	return word, nil
+}
+
+func newV3PoolReader(gethService evm.GethService) *v3PoolReader {
+	return &v3PoolReader{
+		poolAbi:     readAbiResource("./resource/uniswap_v3_pool_abi.json"),
+		routerAbi:   readAbiResource("./resource/uniswap_v3_swap_router_abi.json"),
+		gethService: gethService,
+		quotePools:  map[string]*v3QuotePool{},
+	}
+}
+
+func readAbiResource(path string) abi.ABI {
+	path, _ = filepath.Abs(path)
+	file, err := os.ReadFile(path)
+	if err != nil {
+		panic("Failed to read file")
+	}
+
+	parsed, err := abi.JSON(bytes.NewReader(file))
+	if err != nil {
+		panic(err)
+	}
+
+	return parsed
+}
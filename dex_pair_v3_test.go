@@ -0,0 +1,346 @@
+package service
+
+import (
+	"context"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/kaboom-service/model"
+	"github.com/cross-space-official/kaboom-service/service/provider/evm"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	common2 "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	"github.com/ethereum/go-ethereum/rpc"
+	"math/big"
+	"sort"
+	"testing"
+	"time"
+)
+
+const testV3ChainID = "1"
+
+var (
+	testV3Pool    = common2.HexToAddress("0x000000000000000000000000000000000000b001")
+	testV3Token0  = common2.HexToAddress("0x000000000000000000000000000000000000a001")
+	testV3Token1  = common2.HexToAddress("0x000000000000000000000000000000000000a002")
+	testRecipient = common2.HexToAddress("0x000000000000000000000000000000000000c001")
+)
+
+// simulatedGethService serves GetClient from a simulated backend, any other call panics.
+type simulatedGethService struct {
+	evm.GethService
+	client *ethclient.Client
+}
+
+func (s *simulatedGethService) GetClient(chainID string) (*ethclient.Client, businesserror.XSpaceBusinessError) {
+	return s.client, nil
+}
+
+func abiWord(value int64) []byte {
+	return common2.LeftPadBytes(big.NewInt(value).Bytes(), 32)
+}
+
+func abiWordBig(value *big.Int) []byte {
+	return common2.LeftPadBytes(value.Bytes(), 32)
+}
+
+// constantCallCode assembles runtime code that answers each function signature with fixed return data
+// and reverts on any other selector.
+func constantCallCode(answers map[string][]byte) []byte {
+	signatures := make([]string, 0, len(answers))
+	for signature := range answers {
+		signatures = append(signatures, signature)
+	}
+	sort.Strings(signatures)
+
+	// PUSH1 0 CALLDATALOAD PUSH1 224 SHR
+	header := []byte{0x60, 0x00, 0x35, 0x60, 0xe0, 0x1c}
+	// PUSH1 0 DUP1 REVERT
+	fallback := []byte{0x60, 0x00, 0x80, 0xfd}
+	const dispatchLen, blockLen = 11, 16
+	codeStart := len(header) + dispatchLen*len(signatures) + len(fallback)
+	dataStart := codeStart + blockLen*len(signatures)
+
+	var dispatch, blocks, data []byte
+	for i, signature := range signatures {
+		dest := codeStart + blockLen*i
+		// DUP1 PUSH4 selector EQ PUSH2 dest JUMPI
+		dispatch = append(dispatch, 0x80, 0x63)
+		dispatch = append(dispatch, crypto.Keccak256([]byte(signature))[:4]...)
+		dispatch = append(dispatch, 0x14, 0x61, byte(dest>>8), byte(dest), 0x57)
+
+		// JUMPDEST PUSH2 length PUSH2 offset PUSH1 0 CODECOPY PUSH2 length PUSH1 0 RETURN
+		answer := answers[signature]
+		offset := dataStart + len(data)
+		blocks = append(blocks,
+			0x5b,
+			0x61, byte(len(answer)>>8), byte(len(answer)),
+			0x61, byte(offset>>8), byte(offset),
+			0x60, 0x00, 0x39,
+			0x61, byte(len(answer)>>8), byte(len(answer)),
+			0x60, 0x00, 0xf3)
+		data = append(data, answer...)
+	}
+
+	code := append(header, dispatch...)
+	code = append(code, fallback...)
+	code = append(code, blocks...)
+	return append(code, data...)
+}
+
+// newTestV3Pool deploys a pool at price 1 and tick 0 with 1e24 liquidity, a 0.25% fee, a tick spacing of 50
+// and no initialized tick, so every quote stays in range.
+func newTestV3Pool(t *testing.T) (*v3PoolReader, *simulated.Backend) {
+	t.Helper()
+
+	q96 := new(big.Int).Lsh(big.NewInt(1), 96)
+	liquidity, _ := new(big.Int).SetString("1000000000000000000000000", 10)
+
+	var slot0 []byte
+	for _, word := range [][]byte{abiWordBig(q96), abiWord(0), abiWord(0), abiWord(1), abiWord(1), abiWord(0), abiWord(1)} {
+		slot0 = append(slot0, word...)
+	}
+
+	code := constantCallCode(map[string][]byte{
+		"token0()":          common2.LeftPadBytes(testV3Token0.Bytes(), 32),
+		"token1()":          common2.LeftPadBytes(testV3Token1.Bytes(), 32),
+		"fee()":             abiWord(2500),
+		"tickSpacing()":     abiWord(50),
+		"liquidity()":       abiWordBig(liquidity),
+		"slot0()":           slot0,
+		"tickBitmap(int16)": abiWord(0),
+		"ticks(int24)":      append(abiWord(0), abiWord(0)...),
+	})
+
+	backend := simulated.NewBackend(types.GenesisAlloc{
+		testV3Pool: {Code: code, Balance: big.NewInt(0)},
+	})
+	t.Cleanup(func() { _ = backend.Close() })
+
+	raw := backend.Client().(interface{ Client() *rpc.Client }).Client()
+	return newV3PoolReader(&simulatedGethService{client: ethclient.NewClient(raw)}), backend
+}
+
+// testV3Pair is a uniswap v3 pair on ethereum, so the router and quote token lookups by chain find their entries.
+func testV3Pair() *model.DexPair {
+	token := model.Token{ID: "token", ChainID: testV3ChainID, ContractAddress: testV3Token0.Hex()}
+	native := model.Token{ID: "native", ChainID: testV3ChainID, ContractAddress: testV3Token1.Hex()}
+
+	return &model.DexPair{
+		Type:            model.PairTypeUniSwapV3,
+		ChainID:         testV3ChainID,
+		ContractAddress: testV3Pool.Hex(),
+		Token0ID:        token.ID,
+		Token1ID:        native.ID,
+		Token0:          token,
+		Token1:          native,
+		FeeTier:         2500,
+	}
+}
+
+func TestV3PoolReaderQuote(t *testing.T) {
+	reader, _ := newTestV3Pool(t)
+	pair := testV3Pair()
+	oneToken, _ := new(big.Int).SetString("1000000000000000000", 10)
+
+	// expected amounts follow SwapMath.computeSwapStep for a single in range step
+	tests := []struct {
+		name       string
+		zeroForOne bool
+		exactInput bool
+		want       string
+	}{
+		{"exact input zero for one", true, true, "997499004994742517"},
+		{"exact input one for zero", false, true, "997499004994742517"},
+		{"exact output zero for one", true, false, "1002507268171428574"},
+		{"exact output one for zero", false, false, "1002507268171428574"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := reader.Quote(context.Background(), pair, tt.zeroForOne, tt.exactInput, oneToken)
+			if err != nil {
+				t.Fatalf("Quote() error = %v", err)
+			}
+			if got.String() != tt.want {
+				t.Fatalf("Quote() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	got, err := reader.Quote(context.Background(), pair, true, true, big.NewInt(0))
+	if err != nil || got.Sign() != 0 {
+		t.Fatalf("Quote() of nothing = %v, %v, want 0", got, err)
+	}
+}
+
+func TestV3PoolReaderQuoteReusesPoolRead(t *testing.T) {
+	reader, backend := newTestV3Pool(t)
+	pair := testV3Pair()
+	amount := big.NewInt(1_000_000)
+
+	first, err := reader.Quote(context.Background(), pair, false, true, amount)
+	if err != nil {
+		t.Fatalf("Quote() error = %v", err)
+	}
+
+	// within v3QuoteTTL the second quote must not reach the chain
+	_ = backend.Close()
+	second, err := reader.Quote(context.Background(), pair, false, true, amount)
+	if err != nil {
+		t.Fatalf("cached Quote() error = %v", err)
+	}
+	if first.Cmp(second) != 0 {
+		t.Fatalf("cached Quote() = %s, want %s", second, first)
+	}
+
+	// an expired read is not reused
+	for _, pool := range reader.quotePools {
+		pool.expireAt = time.Now().Add(-time.Second)
+	}
+	if _, err := reader.Quote(context.Background(), pair, false, true, amount); err == nil {
+		t.Fatal("Quote() reused an expired pool read")
+	}
+}
+
+type testExactInputSingleParams struct {
+	TokenIn           common2.Address
+	TokenOut          common2.Address
+	Fee               *big.Int
+	Recipient         common2.Address
+	AmountIn          *big.Int
+	AmountOutMinimum  *big.Int
+	SqrtPriceLimitX96 *big.Int
+}
+
+// unpackMulticall checks the SwapRouter02 multicall(deadline, data) shape and returns the inner calls.
+func unpackMulticall(t *testing.T, routerAbi abi.ABI, data []byte) [][]byte {
+	t.Helper()
+
+	method, err := routerAbi.MethodById(data[:4])
+	if err != nil || method.Name != "multicall" {
+		t.Fatalf("outer call = %v, %v, want multicall", method, err)
+	}
+
+	values, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		t.Fatalf("unpack multicall: %v", err)
+	}
+
+	deadline := values[0].(*big.Int).Int64()
+	if remaining := time.Until(time.Unix(deadline, 0)); remaining <= 0 || remaining > 10*time.Minute {
+		t.Fatalf("multicall deadline in %s, want within 10m", remaining)
+	}
+	return values[1].([][]byte)
+}
+
+func unpackExactInputSingle(t *testing.T, routerAbi abi.ABI, data []byte) testExactInputSingleParams {
+	t.Helper()
+
+	method, err := routerAbi.MethodById(data[:4])
+	if err != nil || method.Name != "exactInputSingle" {
+		t.Fatalf("first call = %v, %v, want exactInputSingle", method, err)
+	}
+
+	values, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		t.Fatalf("unpack exactInputSingle: %v", err)
+	}
+	return *abi.ConvertType(values[0], new(testExactInputSingleParams)).(*testExactInputSingleParams)
+}
+
+func TestV3PoolReaderPackBuyData(t *testing.T) {
+	reader, _ := newTestV3Pool(t)
+	pair := testV3Pair()
+
+	data, err := reader.PackBuyData(pair, testRecipient.Hex(), big.NewInt(1000), big.NewInt(990))
+	if err != nil {
+		t.Fatalf("PackBuyData() error = %v", err)
+	}
+
+	calls := unpackMulticall(t, reader.routerAbi, data)
+	if len(calls) != 2 {
+		t.Fatalf("multicall has %d calls, want swap and refund", len(calls))
+	}
+
+	params := unpackExactInputSingle(t, reader.routerAbi, calls[0])
+	want := testExactInputSingleParams{
+		TokenIn:           common2.HexToAddress(getQuoteToken(pair).ContractAddress),
+		TokenOut:          common2.HexToAddress(pair.GetToken().ContractAddress),
+		Fee:               big.NewInt(2500),
+		Recipient:         testRecipient,
+		AmountIn:          big.NewInt(1000),
+		AmountOutMinimum:  big.NewInt(990),
+		SqrtPriceLimitX96: big.NewInt(0),
+	}
+	assertExactInputSingle(t, params, want)
+
+	if method, err := reader.routerAbi.MethodById(calls[1][:4]); err != nil || method.Name != "refundETH" {
+		t.Fatalf("second call = %v, %v, want refundETH", method, err)
+	}
+}
+
+func TestV3PoolReaderPackSellData(t *testing.T) {
+	reader, _ := newTestV3Pool(t)
+	pair := testV3Pair()
+
+	router := v3SwapRouterAddresses[pair.ChainID][pair.Type]
+	if len(router) == 0 {
+		t.Fatalf("no %s router on chain %s", pair.Type, pair.ChainID)
+	}
+	data, err := reader.PackSellData(pair, router, testRecipient.Hex(), big.NewInt(1000), big.NewInt(990))
+	if err != nil {
+		t.Fatalf("PackSellData() error = %v", err)
+	}
+
+	calls := unpackMulticall(t, reader.routerAbi, data)
+	if len(calls) != 2 {
+		t.Fatalf("multicall has %d calls, want swap and unwrap", len(calls))
+	}
+
+	// the router keeps the wrapped native until unwrapWETH9 sends it on
+	params := unpackExactInputSingle(t, reader.routerAbi, calls[0])
+	want := testExactInputSingleParams{
+		TokenIn:           common2.HexToAddress(pair.GetToken().ContractAddress),
+		TokenOut:          common2.HexToAddress(getQuoteToken(pair).ContractAddress),
+		Fee:               big.NewInt(2500),
+		Recipient:         common2.HexToAddress(router),
+		AmountIn:          big.NewInt(1000),
+		AmountOutMinimum:  big.NewInt(990),
+		SqrtPriceLimitX96: big.NewInt(0),
+	}
+	assertExactInputSingle(t, params, want)
+
+	method, err := reader.routerAbi.MethodById(calls[1][:4])
+	if err != nil || method.Name != "unwrapWETH9" {
+		t.Fatalf("second call = %v, %v, want unwrapWETH9", method, err)
+	}
+	values, err := method.Inputs.Unpack(calls[1][4:])
+	if err != nil {
+		t.Fatalf("unpack unwrapWETH9: %v", err)
+	}
+	if values[0].(*big.Int).Int64() != 990 || values[1].(common2.Address) != testRecipient {
+		t.Fatalf("unwrapWETH9(%v, %v), want (990, %s)", values[0], values[1], testRecipient.Hex())
+	}
+}
+
+func TestV3PoolReaderPackRequiresFeeTier(t *testing.T) {
+	reader, _ := newTestV3Pool(t)
+	pair := testV3Pair()
+	pair.FeeTier = 0
+
+	if _, err := reader.PackBuyData(pair, testRecipient.Hex(), big.NewInt(1000), big.NewInt(990)); err == nil {
+		t.Fatal("PackBuyData() packed a pair without fee tier")
+	}
+}
+
+func assertExactInputSingle(t *testing.T, got, want testExactInputSingleParams) {
+	t.Helper()
+
+	if got.TokenIn != want.TokenIn || got.TokenOut != want.TokenOut || got.Recipient != want.Recipient ||
+		got.Fee.Cmp(want.Fee) != 0 || got.AmountIn.Cmp(want.AmountIn) != 0 ||
+		got.AmountOutMinimum.Cmp(want.AmountOutMinimum) != 0 || got.SqrtPriceLimitX96.Cmp(want.SqrtPriceLimitX96) != 0 {
+		t.Fatalf("exactInputSingle = %+v, want %+v", got, want)
+	}
+}
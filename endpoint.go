@@ -0,0 +1,225 @@
+package eventsync
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type AuthType string
+
+const (
+	AuthTypeNone   AuthType = "none"
+	AuthTypePath   AuthType = "path"
+	AuthTypeHeader AuthType = "header"
+	AuthTypeBearer AuthType = "bearer"
+
+	redactedSecret  = "[REDACTED]"
+	secretKeyToken  = "{key}"
+	secretHostToken = "{host}"
+
+	// credentialRefreshInterval bounds how long a rotated key takes to reach open clients.
+	credentialRefreshInterval = 30 * time.Second
+)
+
+// SecretSource resolves a credential from the environment, a mounted file or a static value, in that order.
+// Env and file sources are re-read on every resolve so keys can be rotated without a restart.
+type SecretSource struct {
+	Env   string
+	File  string
+	Value string
+}
+
+func (s SecretSource) Resolve() (string, error) {
+	if len(s.Env) > 0 {
+		if value := strings.TrimSpace(os.Getenv(s.Env)); len(value) > 0 {
+			return value, nil
+		}
+	}
+
+	if len(s.File) > 0 {
+		raw, err := os.ReadFile(s.File)
+		if err != nil && len(s.Value) == 0 {
+			return "", fmt.Errorf("read secret file %s: %w", s.File, err)
+		}
+		if value := strings.TrimSpace(string(raw)); len(value) > 0 {
+			return value, nil
+		}
+	}
+
+	return strings.TrimSpace(s.Value), nil
+}
+
+type EndpointDefinition struct {
+	ChainID string
+	// URLTemplate may contain {key} for providers that authenticate through the path.
+	URLTemplate string
+	AuthType    AuthType
+	// HeaderName is used by AuthTypeHeader, e.g. "x-api-key".
+	HeaderName string
+	Secret     SecretSource
+	// HostSecret fills {host} in URLTemplate for providers whose endpoint name is a credential too, e.g. quicknode.
+	HostSecret SecretSource
+}
+
+type Endpoint struct {
+	ChainID string
+	URL     string
+	Headers map[string]string
+}
+
+func (e *Endpoint) String() string {
+	return Redact(e.URL)
+}
+
+func (d EndpointDefinition) Resolve() (*Endpoint, error) {
+	if len(d.URLTemplate) == 0 {
+		return nil, fmt.Errorf("unsupported chain id: %s", d.ChainID)
+	}
+
+	endpoint := &Endpoint{
+		ChainID: d.ChainID,
+		URL:     d.URLTemplate,
+		Headers: map[string]string{},
+	}
+
+	if strings.Contains(d.URLTemplate, secretHostToken) {
+		host, err := d.HostSecret.Resolve()
+		if err != nil {
+			return nil, err
+		}
+		if len(host) == 0 {
+			return nil, fmt.Errorf("missing endpoint host for chain id: %s", d.ChainID)
+		}
+		registerSecret(host)
+		endpoint.URL = strings.ReplaceAll(endpoint.URL, secretHostToken, host)
+	}
+
+	if d.AuthType == AuthTypeNone || len(d.AuthType) == 0 {
+		return endpoint, nil
+	}
+
+	secret, err := d.Secret.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("missing credential for chain id: %s", d.ChainID)
+	}
+	registerSecret(secret)
+
+	switch d.AuthType {
+	case AuthTypePath:
+		endpoint.URL = strings.ReplaceAll(endpoint.URL, secretKeyToken, secret)
+	case AuthTypeHeader:
+		if len(d.HeaderName) == 0 {
+			return nil, errors.New("header auth requires a header name")
+		}
+		endpoint.Headers[d.HeaderName] = secret
+	case AuthTypeBearer:
+		endpoint.Headers["Authorization"] = "Bearer " + secret
+	default:
+		return nil, fmt.Errorf("unsupported auth type: %s", d.AuthType)
+	}
+
+	return endpoint, nil
+}
+
+// rotatingTransport sends every request to where its definition currently resolves, the definition is
+// re-resolved at most every credentialRefreshInterval so open clients pick up rotated keys without a redial.
+type rotatingTransport struct {
+	definition EndpointDefinition
+	base       http.RoundTripper
+
+	mu        sync.Mutex
+	endpoint  *Endpoint
+	resolveAt time.Time
+}
+
+func (t *rotatingTransport) current() *Endpoint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(t.resolveAt) {
+		return t.endpoint
+	}
+	t.resolveAt = now.Add(credentialRefreshInterval)
+
+	// a key file caught half written keeps the last credentials until the next resolve
+	endpoint, err := t.definition.Resolve()
+	if err == nil {
+		t.endpoint = endpoint
+	}
+	return t.endpoint
+}
+
+func (t *rotatingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := t.current()
+	target, err := url.Parse(endpoint.URL)
+	if err != nil {
+		return nil, RedactError(err)
+	}
+
+	req = req.Clone(req.Context())
+	req.URL = target
+	req.Host = target.Host
+	for key, value := range endpoint.Headers {
+		req.Header.Set(key, value)
+	}
+	return t.base.RoundTrip(req)
+}
+
+func newRotatingTransport(definition EndpointDefinition, endpoint *Endpoint) *rotatingTransport {
+	return &rotatingTransport{
+		definition: definition,
+		base:       http.DefaultTransport,
+		endpoint:   endpoint,
+		resolveAt:  time.Now().Add(credentialRefreshInterval),
+	}
+}
+
+var secretRegistry = struct {
+	sync.RWMutex
+	secrets map[string]struct{}
+}{secrets: map[string]struct{}{}}
+
+func registerSecret(secret string) {
+	// very short values would redact unrelated text
+	if len(secret) < 6 {
+		return
+	}
+
+	secretRegistry.Lock()
+	secretRegistry.secrets[secret] = struct{}{}
+	secretRegistry.Unlock()
+}
+
+// Redact replaces every credential the package has resolved so far, including rotated ones.
+func Redact(s string) string {
+	secretRegistry.RLock()
+	defer secretRegistry.RUnlock()
+
+	for secret := range secretRegistry.secrets {
+		s = strings.ReplaceAll(s, secret, redactedSecret)
+	}
+	return s
+}
+
+// RedactError flattens err into a new error, the original is not kept as its message may carry a key.
+func RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	message := Redact(err.Error())
+	if message == err.Error() {
+		return err
+	}
+	return errors.New(message)
+}
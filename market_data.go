@@ -0,0 +1,25 @@
+package service
+
+import (
+	"github.com/cross-space-official/kaboom-service/repository"
+	"github.com/cross-space-official/kaboom-service/service/provider/evm"
+)
+
+// MarketData holds the market caches the dex pair and trade services read through. Build it once and hand it to
+// NewEvmDexPairServiceWithMarketData and NewEvmTradeServiceWithMarketData, so both services value in the same
+// native usd price, share its twap samples and load each chain's metadata once.
+type MarketData struct {
+	chains      *chainMetadataCache
+	quoteTokens *quoteTokenService
+	usdOracle   *usdPriceOracle
+}
+
+func NewMarketData(gethService evm.GethService, assetRepository repository.AssetRepository) *MarketData {
+	quoteTokens := newQuoteTokenService(gethService)
+
+	return &MarketData{
+		chains:      newChainMetadataCache(gethService, assetRepository),
+		quoteTokens: quoteTokens,
+		usdOracle:   newUSDPriceOracle(gethService, quoteTokens),
+	}
+}
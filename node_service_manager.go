@@ -1,62 +1,146 @@
 package eventsync
 
 import (
+	"context"
 	"fmt"
 	"github.com/cross-space-official/common/businesserror"
 	"github.com/cross-space-official/kaboom-service/common"
 	"github.com/cross-space-official/kaboom-service/configs"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
 )
 
-func alchemyURL(apiKey string, chainID string) string {
-	return fmt.Sprintf("https://%s.g.alchemy.com/v2/%s", configs.AlchemySupportedChainPathMapping[chainID], apiKey)
+var endpointOverrides = struct {
+	sync.RWMutex
+	definitions map[string]EndpointDefinition
+}{definitions: map[string]EndpointDefinition{}}
+
+// RegisterEndpointDefinition replaces the built-in provider of a chain, e.g. with a header or jwt authenticated node.
+func RegisterEndpointDefinition(definition EndpointDefinition) {
+	endpointOverrides.Lock()
+	defer endpointOverrides.Unlock()
+
+	endpointOverrides.definitions[definition.ChainID] = definition
+}
+
+func alchemyURL(chainID string) string {
+	return fmt.Sprintf("https://%s.g.alchemy.com/v2/%s", configs.AlchemySupportedChainPathMapping[chainID], secretKeyToken)
 }
 
-func infuraURL(apiKey string, chainID string) string {
-	return fmt.Sprintf("https://%s.infura.io/v3/%s", configs.InfuraSupportedChainPathMapping[chainID], apiKey)
+func infuraURL(chainID string) string {
+	return fmt.Sprintf("https://%s.infura.io/v3/%s", configs.InfuraSupportedChainPathMapping[chainID], secretKeyToken)
 }
 
-func nodeRealURL(apiKey string, chainID string) string {
-	return fmt.Sprintf("https://%s.nodereal.io/v1/%s", configs.NodeRealSupportedChainPathMapping[chainID], apiKey)
+func nodeRealURL(chainID string) string {
+	return fmt.Sprintf("https://%s.nodereal.io/v1/%s", configs.NodeRealSupportedChainPathMapping[chainID], secretKeyToken)
 }
 
 func bitlayerURL(chainID string) string {
 	return fmt.Sprintf("https://%s.bitlayer.org", configs.BitLayerPathMapping[chainID])
 }
 
-func morphTestnetURL(prefix, apiKey, chainID string) string {
-	return fmt.Sprintf("https://%s.morph-holesky.quiknode.pro/%s", prefix, apiKey)
+// morphTestnetURL leaves the quicknode endpoint name to HostSecret, it identifies the account like the key does.
+func morphTestnetURL() string {
+	return fmt.Sprintf("https://%s.morph-holesky.quiknode.pro/%s", secretHostToken, secretKeyToken)
 }
 
-func GetBaseURL(config configs.OnchainClientConfig) string {
+// secretSource lets RPC_API_KEY_<chain id> or the file in RPC_API_KEY_FILE_<chain id> override the configured key.
+func secretSource(chainID, configuredKey string) SecretSource {
+	return SecretSource{
+		Env:   fmt.Sprintf("RPC_API_KEY_%s", chainID),
+		File:  os.Getenv(fmt.Sprintf("RPC_API_KEY_FILE_%s", chainID)),
+		Value: configuredKey,
+	}
+}
+
+// hostSecretSource is secretSource for the endpoint name, through RPC_HOST_<chain id> and RPC_HOST_FILE_<chain id>.
+func hostSecretSource(chainID, configuredHost string) SecretSource {
+	return SecretSource{
+		Env:   fmt.Sprintf("RPC_HOST_%s", chainID),
+		File:  os.Getenv(fmt.Sprintf("RPC_HOST_FILE_%s", chainID)),
+		Value: configuredHost,
+	}
+}
+
+func GetEndpointDefinition(config configs.OnchainClientConfig) EndpointDefinition {
+	endpointOverrides.RLock()
+	definition, ok := endpointOverrides.definitions[config.ChainID]
+	endpointOverrides.RUnlock()
+	if ok {
+		return definition
+	}
+
 	switch config.ChainID {
 	case "1", "5", "137", "11155111", "42161", "421614":
-		return infuraURL(config.GetInfuraKey(), config.ChainID)
+		return EndpointDefinition{ChainID: config.ChainID, URLTemplate: infuraURL(config.ChainID), AuthType: AuthTypePath,
+			Secret: secretSource(config.ChainID, config.GetInfuraKey())}
 	case "56", "97":
-		return nodeRealURL(config.GetNodeRealKey(), config.ChainID)
+		return EndpointDefinition{ChainID: config.ChainID, URLTemplate: nodeRealURL(config.ChainID), AuthType: AuthTypePath,
+			Secret: secretSource(config.ChainID, config.GetNodeRealKey())}
 	case "8453", "84532":
-		return alchemyURL(config.GetAlchemyKey(), config.ChainID)
+		return EndpointDefinition{ChainID: config.ChainID, URLTemplate: alchemyURL(config.ChainID), AuthType: AuthTypePath,
+			Secret: secretSource(config.ChainID, config.GetAlchemyKey())}
 	case "200901", "200810":
-		return bitlayerURL(config.ChainID)
+		return EndpointDefinition{ChainID: config.ChainID, URLTemplate: bitlayerURL(config.ChainID), AuthType: AuthTypeNone}
 	case "2810":
-		return morphTestnetURL(config.GetQuickNodePrefix(), config.GetQuickNodeKey(), config.ChainID)
+		return EndpointDefinition{ChainID: config.ChainID, URLTemplate: morphTestnetURL(), AuthType: AuthTypePath,
+			Secret: secretSource(config.ChainID, config.GetQuickNodeKey()), HostSecret: hostSecretSource(config.ChainID, config.GetQuickNodePrefix())}
 	case "2818":
-		return "https://rpc-quicknode.morphl2.io"
+		return EndpointDefinition{ChainID: config.ChainID, URLTemplate: "https://rpc-quicknode.morphl2.io", AuthType: AuthTypeNone}
 	default:
+		return EndpointDefinition{ChainID: config.ChainID}
+	}
+}
+
+func GetEndpoint(config configs.OnchainClientConfig) (*Endpoint, businesserror.XSpaceBusinessError) {
+	endpoint, err := GetEndpointDefinition(config).Resolve()
+	if err != nil {
+		return nil, common.NewRuntimeError(RedactError(err))
+	}
+
+	return endpoint, nil
+}
+
+// GetBaseURL returns the url with credentials embedded, it must never be logged, use Endpoint.String instead.
+func GetBaseURL(config configs.OnchainClientConfig) string {
+	endpoint, err := GetEndpoint(config)
+	if err != nil {
 		return ""
 	}
+
+	return endpoint.URL
 }
 
+// NewEthClient dials the chain of config, the client keeps following key rotations of its endpoint definition.
 func NewEthClient(config configs.OnchainClientConfig) (*ethclient.Client, businesserror.XSpaceBusinessError) {
-	url := GetBaseURL(config)
-	if len(url) == 0 {
-		return nil, common.NewRuntimeError(fmt.Errorf("unsupported chain id: %s", config.ChainID))
+	definition := GetEndpointDefinition(config)
+	endpoint, err := definition.Resolve()
+	if err != nil {
+		return nil, common.NewRuntimeError(RedactError(err))
+	}
+
+	return dialEndpoint(definition, endpoint)
+}
+
+// dialEndpoint connects to endpoint, over http every request goes through a rotatingTransport of definition.
+func dialEndpoint(definition EndpointDefinition, endpoint *Endpoint) (*ethclient.Client, businesserror.XSpaceBusinessError) {
+	var options []rpc.ClientOption
+	if strings.HasPrefix(endpoint.URL, "http://") || strings.HasPrefix(endpoint.URL, "https://") {
+		options = append(options, rpc.WithHTTPClient(&http.Client{Transport: newRotatingTransport(definition, endpoint)}))
+	} else {
+		for key, value := range endpoint.Headers {
+			options = append(options, rpc.WithHeader(key, value))
+		}
 	}
 
-	client, err := ethclient.Dial(url)
+	client, err := rpc.DialOptions(context.Background(), endpoint.URL, options...)
 	if err != nil {
-		return nil, common.NewRuntimeError(err)
+		return nil, common.NewRuntimeError(RedactError(err))
 	}
 
-	return client, nil
+	return ethclient.NewClient(client), nil
 }
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	"github.com/cross-space-official/common/logger"
+	"github.com/cross-space-official/kaboom-service/repository"
+	"github.com/cross-space-official/kaboom-service/service/provider/evm"
+	"github.com/ethereum/go-ethereum"
+	common2 "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const (
+	pairLogPollInterval = 15 * time.Second
+	pairLogPollTimeout  = time.Minute
+	// pairLogMaxBlocks bounds one eth_getLogs range, a watcher that fell behind catches up over several polls
+	pairLogMaxBlocks = 2000
+	// pairLogAddressBatch is how many pair addresses go into one eth_getLogs filter
+	pairLogAddressBatch = 500
+)
+
+type PairLogWatcher interface {
+	Start()
+	Stop()
+}
+
+// pairLogWatcher polls the Sync and Burn logs of the published pairs of each chain and hands them to the rug monitor,
+// and the migration logs of the launchpad managers to the migration listener.
+// It starts at the head when started, logs emitted while it was stopped are covered by the reserve resyncs.
+type pairLogWatcher struct {
+	gethService     evm.GethService
+	assetRepository repository.AssetRepository
+	rugMonitor      PairRugMonitor
+	migrations      LaunchpadMigrationListener
+	chainIDs        []string
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func (w *pairLogWatcher) Start() {
+	for _, chainID := range w.chainIDs {
+		w.wg.Add(1)
+		go w.watch(chainID)
+	}
+}
+
+// Stop waits for the running polls to finish, calling it again only waits.
+func (w *pairLogWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+	w.wg.Wait()
+}
+
+func (w *pairLogWatcher) watch(chainID string) {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(pairLogPollInterval)
+	defer ticker.Stop()
+
+	var next uint64
+	for {
+		next = w.poll(chainID, next)
+
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll handles the logs from block next up to the head, at most pairLogMaxBlocks of them, and returns the block
+// to continue from. A failed poll returns next so the range is retried.
+func (w *pairLogWatcher) poll(chainID string, next uint64) uint64 {
+	ctx, cancel := context.WithTimeout(context.Background(), pairLogPollTimeout)
+	defer cancel()
+
+	client, err := w.gethService.GetClient(chainID)
+	if err != nil {
+		logger.GetLoggerEntry(ctx).WithField("chain_id", chainID).Errorf("error getting client, %v", err)
+		return next
+	}
+
+	head, basicErr := client.BlockNumber(ctx)
+	if basicErr != nil {
+		logger.GetLoggerEntry(ctx).WithField("chain_id", chainID).Errorf("error getting block number, %v", basicErr)
+		return next
+	}
+	if next == 0 {
+		return head + 1
+	}
+	if next > head {
+		return next
+	}
+
+	to := head
+	if to-next >= pairLogMaxBlocks {
+		to = next + pairLogMaxBlocks - 1
+	}
+
+	pairs, err := w.assetRepository.RetrievePublishedPairsByChainID(ctx, chainID)
+	if err != nil {
+		logger.GetLoggerEntry(ctx).WithField("chain_id", chainID).Errorf("error retrieving published pairs, %v", err)
+		return next
+	}
+
+	addresses := make([]common2.Address, 0, len(pairs))
+	for _, pair := range pairs {
+		if isLaunchpadPairType(pair.Type) || isV3PairType(pair.Type) {
+			continue
+		}
+		addresses = append(addresses, common2.HexToAddress(pair.ContractAddress))
+	}
+
+	if !w.handleMigrations(ctx, client, chainID, next, to) {
+		return next
+	}
+
+	for start := 0; start < len(addresses); start += pairLogAddressBatch {
+		end := start + pairLogAddressBatch
+		if end > len(addresses) {
+			end = len(addresses)
+		}
+
+		logs, basicErr := client.FilterLogs(ctx, ethereum.FilterQuery{
+			FromBlock: new(big.Int).SetUint64(next),
+			ToBlock:   new(big.Int).SetUint64(to),
+			Addresses: addresses[start:end],
+			Topics:    [][]common2.Hash{{PairSyncTopic, PairBurnTopic}},
+		})
+		if basicErr != nil {
+			logger.GetLoggerEntry(ctx).WithField("chain_id", chainID).Errorf("error getting pair logs, %v", basicErr)
+			return next
+		}
+
+		w.handle(ctx, chainID, logs)
+	}
+
+	return to + 1
+}
+
+// handleMigrations passes the migration logs of the chain's launchpad managers to the migration listener.
+func (w *pairLogWatcher) handleMigrations(ctx context.Context, client *ethclient.Client, chainID string, from, to uint64) bool {
+	managers := make([]common2.Address, 0, len(launchpads[chainID]))
+	for _, launchpad := range launchpads[chainID] {
+		managers = append(managers, common2.HexToAddress(launchpad.ManagerAddress))
+	}
+	if len(managers) == 0 {
+		return true
+	}
+
+	logs, err := client.FilterLogs(ctx, ethereum.FilterQuery{
+		FromBlock: new(big.Int).SetUint64(from),
+		ToBlock:   new(big.Int).SetUint64(to),
+		Addresses: managers,
+		Topics:    [][]common2.Hash{{LaunchpadMigrationTopic}},
+	})
+	if err != nil {
+		logger.GetLoggerEntry(ctx).WithField("chain_id", chainID).Errorf("error getting launchpad logs, %v", err)
+		return false
+	}
+
+	for _, log := range logs {
+		if log.Removed {
+			continue
+		}
+
+		if err := w.migrations.HandleLaunchpadLog(ctx, chainID, log); err != nil {
+			logger.GetLoggerEntry(ctx).
+				WithField("chain_id", chainID).
+				WithField("tx_hash", log.TxHash.Hex()).
+				Errorf("error handling launchpad log, %v", err)
+		}
+	}
+	return true
+}
+
+func (w *pairLogWatcher) handle(ctx context.Context, chainID string, logs []types.Log) {
+	for _, log := range logs {
+		if log.Removed {
+			continue
+		}
+
+		if err := w.rugMonitor.HandlePairLog(ctx, chainID, log); err != nil {
+			logger.GetLoggerEntry(ctx).
+				WithField("chain_id", chainID).
+				WithField("tx_hash", log.TxHash.Hex()).
+				Errorf("error handling pair log, %v", err)
+		}
+	}
+}
+
+func NewPairLogWatcher(
+	gethService evm.GethService,
+	assetRepository repository.AssetRepository,
+	rugMonitor PairRugMonitor,
+	migrations LaunchpadMigrationListener,
+	chainIDs []string,
+) PairLogWatcher {
+	return &pairLogWatcher{
+		gethService:     gethService,
+		assetRepository: assetRepository,
+		rugMonitor:      rugMonitor,
+		migrations:      migrations,
+		chainIDs:        chainIDs,
+		stop:            make(chan struct{}),
+	}
+}
@@ -0,0 +1,230 @@
+package service
+
+import (
+	"context"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/common/logger"
+	"github.com/cross-space-official/kaboom-service/model"
+	"github.com/cross-space-official/kaboom-service/repository"
+	"github.com/shopspring/decimal"
+	"sync"
+	"time"
+)
+
+const (
+	priceHistoryRetention       = 90 * 24 * time.Hour
+	priceHistoryCompactInterval = time.Hour
+	priceHistoryCompactTimeout  = 10 * time.Minute
+)
+
+// priceSnapshotTier keeps one snapshot per Bucket for every pair once snapshots are older than After.
+// Snapshots younger than the first tier are kept as written.
+type priceSnapshotTier struct {
+	After  time.Duration
+	Bucket time.Duration
+}
+
+var priceSnapshotTiers = []priceSnapshotTier{
+	{After: 24 * time.Hour, Bucket: 15 * time.Minute},
+	{After: 7 * 24 * time.Hour, Bucket: time.Hour},
+	{After: 30 * 24 * time.Hour, Bucket: 4 * time.Hour},
+}
+
+type PriceChangeWindow string
+
+const (
+	PriceChangeWindow5m  PriceChangeWindow = "5m"
+	PriceChangeWindow1h  PriceChangeWindow = "1h"
+	PriceChangeWindow6h  PriceChangeWindow = "6h"
+	PriceChangeWindow24h PriceChangeWindow = "24h"
+	PriceChangeWindow7d  PriceChangeWindow = "7d"
+)
+
+var priceChangeWindows = []struct {
+	Window   PriceChangeWindow
+	Duration time.Duration
+}{
+	{PriceChangeWindow5m, 5 * time.Minute},
+	{PriceChangeWindow1h, time.Hour},
+	{PriceChangeWindow6h, 6 * time.Hour},
+	{PriceChangeWindow24h, 24 * time.Hour},
+	{PriceChangeWindow7d, 7 * 24 * time.Hour},
+}
+
+// PriceChange compares the latest snapshot with the one in force at the start of the window, in percent.
+// A change is nil when the pair has no snapshot close enough to the window start, when its latest snapshot is older
+// than the window itself or when either side has no price.
+type PriceChange struct {
+	Window          PriceChangeWindow
+	From            *time.Time
+	ChangeInNative  *decimal.Decimal
+	ChangeUSD       *decimal.Decimal
+	LiquidityChange *decimal.Decimal
+}
+
+type PriceHistoryService interface {
+	GetPriceHistory(ctx context.Context, pairID string, from, to time.Time) ([]*model.PairPriceSnapshot, businesserror.XSpaceBusinessError)
+	GetPriceChanges(ctx context.Context, pairID string) ([]*PriceChange, businesserror.XSpaceBusinessError)
+}
+
+type priceHistoryService struct {
+	assetRepository repository.AssetRepository
+}
+
+func (s *priceHistoryService) GetPriceHistory(ctx context.Context, pairID string, from, to time.Time) ([]*model.PairPriceSnapshot, businesserror.XSpaceBusinessError) {
+	return s.assetRepository.RetrievePairPriceSnapshots(ctx, pairID, from, to)
+}
+
+func (s *priceHistoryService) GetPriceChanges(ctx context.Context, pairID string) ([]*PriceChange, businesserror.XSpaceBusinessError) {
+	now := time.Now()
+	latest, err := s.assetRepository.RetrievePairPriceSnapshotAt(ctx, pairID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]*PriceChange, 0, len(priceChangeWindows))
+	for _, window := range priceChangeWindows {
+		change := &PriceChange{Window: window.Window}
+		changes = append(changes, change)
+		start := now.Add(-window.Duration)
+		// a latest snapshot from before the window start would report a stale move as the window's change
+		if latest == nil || latest.CapturedAt.Before(start) {
+			continue
+		}
+
+		past, err := s.assetRepository.RetrievePairPriceSnapshotAt(ctx, pairID, start)
+		if err != nil {
+			return nil, err
+		}
+		// a snapshot from well before the window says nothing about the window itself
+		if past == nil || past.CapturedAt.Before(start.Add(-window.Duration)) {
+			continue
+		}
+
+		change.From = &past.CapturedAt
+		change.ChangeInNative = percentChange(&past.PriceInNative, &latest.PriceInNative)
+		change.ChangeUSD = percentChange(past.PriceUSD, latest.PriceUSD)
+		change.LiquidityChange = percentChange(past.LiquidityUSD, latest.LiquidityUSD)
+	}
+
+	return changes, nil
+}
+
+func percentChange(from, to *decimal.Decimal) *decimal.Decimal {
+	if from == nil || to == nil || from.IsZero() {
+		return nil
+	}
+
+	change := to.Sub(*from).Div(*from).Mul(decimal.NewFromInt(100))
+	return &change
+}
+
+// recordPriceSnapshot appends the state SyncPair just persisted to the pair's history, a failed write only loses a point.
+func (d *dexEvmPairService) recordPriceSnapshot(c context.Context, pair *model.DexPair, token model.Token) {
+	totalSupply := bigIntOf(token.TotalSupply)
+	if totalSupply.Sign() == 0 {
+		return
+	}
+
+	// native wei per whole token
+	price := decimal.NewFromBigInt(bigIntOf(token.FullyDilutedValueInNative), 0).
+		Div(decimal.NewFromBigInt(totalSupply, -int32(token.Decimals)))
+
+	snapshot := &model.PairPriceSnapshot{
+		PairID:            pair.ID,
+		TokenID:           token.ID,
+		ChainID:           pair.ChainID,
+		PriceInNative:     price,
+		PriceUSD:          token.PriceUSD,
+		Reserve0:          pair.Reserve0,
+		Reserve1:          pair.Reserve1,
+		MarketCapInNative: token.MarketCapInNative,
+		MarketCapUSD:      token.MarketCapUSD,
+		LiquidityUSD:      pair.LiquidityUSD,
+		CapturedAt:        time.Now(),
+	}
+
+	err := d.assetRepository.CreatePairPriceSnapshot(c, snapshot)
+	if err != nil {
+		logger.GetLoggerEntry(c).
+			WithField("pair_id", pair.ID).
+			Errorf("error recording price snapshot, %v", err)
+	}
+}
+
+type PriceHistoryCompactor interface {
+	Start()
+	Stop()
+}
+
+type priceHistoryCompactor struct {
+	assetRepository repository.AssetRepository
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Start downsamples and expires the price history every priceHistoryCompactInterval.
+func (s *priceHistoryCompactor) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(priceHistoryCompactInterval)
+		defer ticker.Stop()
+
+		for {
+			s.compact()
+
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (s *priceHistoryCompactor) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+// compact thins every tier's age range down to its bucket, rerunning it over an already thinned range is a no-op.
+func (s *priceHistoryCompactor) compact() {
+	ctx, cancel := context.WithTimeout(context.Background(), priceHistoryCompactTimeout)
+	defer cancel()
+
+	now := time.Now()
+	for i, tier := range priceSnapshotTiers {
+		from := now.Add(-priceHistoryRetention)
+		if i+1 < len(priceSnapshotTiers) {
+			from = now.Add(-priceSnapshotTiers[i+1].After)
+		}
+
+		err := s.assetRepository.DownsamplePairPriceSnapshots(ctx, from, now.Add(-tier.After), tier.Bucket)
+		if err != nil {
+			logger.GetLoggerEntry(ctx).
+				WithField("bucket", tier.Bucket.String()).
+				Errorf("error downsampling price history, %v", err)
+		}
+	}
+
+	err := s.assetRepository.DeletePairPriceSnapshotsBefore(ctx, now.Add(-priceHistoryRetention))
+	if err != nil {
+		logger.GetLoggerEntry(ctx).Errorf("error expiring price history, %v", err)
+	}
+}
+
+func NewPriceHistoryService(assetRepository repository.AssetRepository) PriceHistoryService {
+	return &priceHistoryService{
+		assetRepository: assetRepository,
+	}
+}
+
+func NewPriceHistoryCompactor(assetRepository repository.AssetRepository) PriceHistoryCompactor {
+	return &priceHistoryCompactor{
+		assetRepository: assetRepository,
+		stop:            make(chan struct{}),
+	}
+}
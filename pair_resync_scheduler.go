@@ -0,0 +1,259 @@
+package service
+
+import (
+	"context"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/common/logger"
+	"github.com/cross-space-official/kaboom-service/model"
+	"github.com/cross-space-official/kaboom-service/repository"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	resyncPollInterval = 30 * time.Second
+	resyncTimeout      = 2 * time.Minute
+	// the due pairs query and the hand off to the workers share one poll interval
+	resyncDispatchTimeout = resyncPollInterval
+	resyncBatchSize       = 200
+	resyncJitter          = 0.1
+
+	hotPairResyncInterval     = 5 * time.Minute
+	warmPairResyncInterval    = time.Hour
+	dormantPairResyncInterval = 24 * time.Hour
+	// syncs in between only refresh prices, see PairSyncer
+	fullPairResyncInterval = time.Hour
+
+	// a swap within hotPairWindow makes the pair hot, within warmPairWindow warm
+	hotPairWindow  = time.Hour
+	warmPairWindow = 24 * time.Hour
+)
+
+type PairResyncScheduler interface {
+	Start()
+	Stop()
+}
+
+// PairSyncer is the part of DexPairService the scheduler drives.
+type PairSyncer interface {
+	SyncPair(ctx context.Context, pair *model.DexPair) businesserror.XSpaceBusinessError
+	SyncPairPrices(ctx context.Context, pair *model.DexPair) businesserror.XSpaceBusinessError
+}
+
+type pairResyncScheduler struct {
+	pairService            PairSyncer
+	assetRepository        repository.AssetRepository
+	tokenBalanceRepository repository.TokenBalanceRepository
+	rugMonitor             PairRugMonitor
+	workers                int
+
+	mu       sync.Mutex
+	inFlight map[string]struct{}
+	jobs     chan *model.DexPair
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// Start polls for published pairs whose next sync is due and feeds them to a bounded worker pool.
+func (s *pairResyncScheduler) Start() {
+	for i := 0; i < s.workers; i++ {
+		s.wg.Add(1)
+		go s.work()
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(s.jobs)
+
+		ticker := time.NewTicker(resyncPollInterval)
+		defer ticker.Stop()
+
+		for {
+			s.dispatchDue()
+
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop waits for the running syncs to finish, queued ones are dropped and picked up after restart.
+// Calling it again only waits.
+func (s *pairResyncScheduler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	s.wg.Wait()
+}
+
+func (s *pairResyncScheduler) dispatchDue() {
+	ctx, cancel := context.WithTimeout(context.Background(), resyncDispatchTimeout)
+	defer cancel()
+
+	pairs, err := s.assetRepository.RetrievePublishedPairsDueForSync(ctx, time.Now(), resyncBatchSize)
+	if err != nil {
+		logger.GetLoggerEntry(ctx).Errorf("error retrieving pairs due for sync, %v", err)
+		return
+	}
+
+	for _, pair := range pairs {
+		s.mu.Lock()
+		_, running := s.inFlight[pair.ID]
+		if !running {
+			s.inFlight[pair.ID] = struct{}{}
+		}
+		s.mu.Unlock()
+		if running {
+			continue
+		}
+
+		select {
+		case s.jobs <- pair:
+		case <-ctx.Done():
+			s.release(pair)
+			return
+		case <-s.stop:
+			s.release(pair)
+			return
+		}
+	}
+}
+
+func (s *pairResyncScheduler) work() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case pair, ok := <-s.jobs:
+			if !ok {
+				return
+			}
+			s.resync(pair)
+			s.release(pair)
+		}
+	}
+}
+
+func (s *pairResyncScheduler) release(pair *model.DexPair) {
+	s.mu.Lock()
+	delete(s.inFlight, pair.ID)
+	s.mu.Unlock()
+}
+
+func (s *pairResyncScheduler) resync(pair *model.DexPair) {
+	ctx, cancel := context.WithTimeout(context.Background(), resyncTimeout)
+	defer cancel()
+
+	now := time.Now()
+	state := pair.SyncState
+	full := needsFullSync(state.LastFullSyncAt, now)
+
+	var err businesserror.XSpaceBusinessError
+	if full {
+		err = s.pairService.SyncPair(ctx, pair)
+	} else {
+		err = s.pairService.SyncPairPrices(ctx, pair)
+	}
+	if err != nil {
+		logger.GetLoggerEntry(ctx).
+			WithField("pair_id", pair.ID).
+			Errorf("error resyncing pair, %v", err)
+
+		state.LastSyncError = err.Error()
+		state.SyncFailures++
+	} else {
+		state.LastSyncedAt = &now
+		state.LastSyncError = ""
+		state.SyncFailures = 0
+		if full {
+			state.LastFullSyncAt = &now
+		}
+
+		if full && s.rugMonitor != nil {
+			if err := s.rugMonitor.CheckSellTax(ctx, pair); err != nil {
+				logger.GetLoggerEntry(ctx).
+					WithField("pair_id", pair.ID).
+					Errorf("error checking pair sell tax, %v", err)
+			}
+		}
+	}
+	state.NextSyncAt = now.Add(s.nextInterval(ctx, pair, state.SyncFailures))
+
+	if err := s.assetRepository.UpdatePairSyncState(ctx, pair.ID, state); err != nil {
+		logger.GetLoggerEntry(ctx).
+			WithField("pair_id", pair.ID).
+			Errorf("error updating pair sync state, %v", err)
+	}
+}
+
+// needsFullSync is true when the last full sync is older than fullPairResyncInterval, warm and dormant pairs are
+// due less often than that and always sync fully.
+func needsFullSync(lastFullSyncAt *time.Time, now time.Time) bool {
+	return lastFullSyncAt == nil || now.Sub(*lastFullSyncAt) >= fullPairResyncInterval
+}
+
+// nextInterval picks the cadence from recent swaps and user holdings, backs off on failures and adds jitter
+// so pairs created together do not stay in lockstep.
+func (s *pairResyncScheduler) nextInterval(ctx context.Context, pair *model.DexPair, failures int) time.Duration {
+	interval := dormantPairResyncInterval
+	switch {
+	case pair.LastSwapAt != nil && time.Since(*pair.LastSwapAt) < hotPairWindow:
+		interval = hotPairResyncInterval
+	case s.hasHolders(ctx, pair):
+		interval = hotPairResyncInterval
+	case pair.LastSwapAt != nil && time.Since(*pair.LastSwapAt) < warmPairWindow:
+		interval = warmPairResyncInterval
+	}
+
+	for i := 0; i < failures && interval < dormantPairResyncInterval; i++ {
+		interval *= 2
+	}
+	if interval > dormantPairResyncInterval {
+		interval = dormantPairResyncInterval
+	}
+
+	jitter := (rand.Float64()*2 - 1) * resyncJitter
+	return interval + time.Duration(float64(interval)*jitter)
+}
+
+func (s *pairResyncScheduler) hasHolders(ctx context.Context, pair *model.DexPair) bool {
+	holders, err := s.tokenBalanceRepository.CountPositiveBalancesByPairID(ctx, pair.ID)
+	if err != nil {
+		logger.GetLoggerEntry(ctx).
+			WithField("pair_id", pair.ID).
+			Errorf("error counting pair holders, %v", err)
+		return false
+	}
+	return holders > 0
+}
+
+func NewPairResyncScheduler(
+	pairService PairSyncer,
+	assetRepository repository.AssetRepository,
+	tokenBalanceRepository repository.TokenBalanceRepository,
+	rugMonitor PairRugMonitor,
+	workers int,
+) PairResyncScheduler {
+	if workers <= 0 {
+		workers = 4
+	}
+
+	return &pairResyncScheduler{
+		pairService:            pairService,
+		assetRepository:        assetRepository,
+		tokenBalanceRepository: tokenBalanceRepository,
+		rugMonitor:             rugMonitor,
+		workers:                workers,
+		inFlight:               map[string]struct{}{},
+		jobs:                   make(chan *model.DexPair, workers),
+		stop:                   make(chan struct{}),
+	}
+}
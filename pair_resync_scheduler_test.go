@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/kaboom-service/model"
+	"github.com/cross-space-official/kaboom-service/repository"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePairSyncer records which sync ran for which pair.
+type fakePairSyncer struct {
+	mu     sync.Mutex
+	full   []string
+	prices []string
+}
+
+func (f *fakePairSyncer) SyncPair(ctx context.Context, pair *model.DexPair) businesserror.XSpaceBusinessError {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.full = append(f.full, pair.ID)
+	return nil
+}
+
+func (f *fakePairSyncer) SyncPairPrices(ctx context.Context, pair *model.DexPair) businesserror.XSpaceBusinessError {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.prices = append(f.prices, pair.ID)
+	return nil
+}
+
+// fakeResyncAssetRepository serves the due pairs and keeps the stored sync states, any other call panics.
+type fakeResyncAssetRepository struct {
+	repository.AssetRepository
+
+	mu     sync.Mutex
+	due    []*model.DexPair
+	states map[string]model.DexPairSyncState
+}
+
+func (f *fakeResyncAssetRepository) RetrievePublishedPairsDueForSync(ctx context.Context, now time.Time, limit int) ([]*model.DexPair, businesserror.XSpaceBusinessError) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	due := f.due
+	f.due = nil
+	return due, nil
+}
+
+func (f *fakeResyncAssetRepository) UpdatePairSyncState(ctx context.Context, pairID string, state model.DexPairSyncState) businesserror.XSpaceBusinessError {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.states[pairID] = state
+	return nil
+}
+
+func (f *fakeResyncAssetRepository) state(pairID string) model.DexPairSyncState {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.states[pairID]
+}
+
+// fakeTokenBalanceRepository counts holders from a fixed map, any other call panics.
+type fakeTokenBalanceRepository struct {
+	repository.TokenBalanceRepository
+	holders map[string]int64
+}
+
+func (f *fakeTokenBalanceRepository) CountPositiveBalancesByPairID(ctx context.Context, pairID string) (int64, businesserror.XSpaceBusinessError) {
+	return f.holders[pairID], nil
+}
+
+func newTestResyncScheduler(due []*model.DexPair, holders map[string]int64) (*pairResyncScheduler, *fakePairSyncer, *fakeResyncAssetRepository) {
+	syncer := &fakePairSyncer{}
+	assets := &fakeResyncAssetRepository{due: due, states: map[string]model.DexPairSyncState{}}
+	balances := &fakeTokenBalanceRepository{holders: holders}
+
+	scheduler := NewPairResyncScheduler(syncer, assets, balances, nil, 2).(*pairResyncScheduler)
+	return scheduler, syncer, assets
+}
+
+func TestPairResyncSchedulerStopTwice(t *testing.T) {
+	scheduler, _, _ := newTestResyncScheduler(nil, nil)
+
+	scheduler.Start()
+	scheduler.Stop()
+	scheduler.Stop()
+}
+
+func TestPairResyncSchedulerSyncsDuePairs(t *testing.T) {
+	now := time.Now()
+	recent := now.Add(-10 * time.Minute)
+	stale := now.Add(-2 * fullPairResyncInterval)
+
+	due := []*model.DexPair{
+		{ID: "never", LastSwapAt: &recent},
+		{ID: "recent", LastSwapAt: &recent, SyncState: model.DexPairSyncState{LastFullSyncAt: &recent}},
+		{ID: "stale", LastSwapAt: &recent, SyncState: model.DexPairSyncState{LastFullSyncAt: &stale}},
+	}
+	scheduler, syncer, assets := newTestResyncScheduler(due, nil)
+
+	scheduler.Start()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		assets.mu.Lock()
+		synced := len(assets.states)
+		assets.mu.Unlock()
+		if synced == len(due) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("synced %d of %d due pairs", synced, len(due))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	scheduler.Stop()
+
+	syncer.mu.Lock()
+	defer syncer.mu.Unlock()
+	if len(syncer.full) != 2 || len(syncer.prices) != 1 || syncer.prices[0] != "recent" {
+		t.Fatalf("full syncs = %v, price syncs = %v, want full for never and stale, prices for recent", syncer.full, syncer.prices)
+	}
+
+	for _, pair := range due {
+		state := assets.state(pair.ID)
+		if state.LastSyncedAt == nil || state.NextSyncAt.Before(now) {
+			t.Errorf("%s: state = %+v, want a sync time and a next sync in the future", pair.ID, state)
+		}
+	}
+	if state := assets.state("recent"); !state.LastFullSyncAt.Equal(recent) {
+		t.Errorf("recent: LastFullSyncAt = %v, want the previous full sync %v", state.LastFullSyncAt, recent)
+	}
+	if state := assets.state("stale"); !state.LastFullSyncAt.After(stale) {
+		t.Errorf("stale: LastFullSyncAt = %v, want it moved past %v", state.LastFullSyncAt, stale)
+	}
+}
+
+func TestPairResyncSchedulerSkipsInFlightPairs(t *testing.T) {
+	pair := &model.DexPair{ID: "running"}
+	scheduler, _, _ := newTestResyncScheduler([]*model.DexPair{pair}, nil)
+	scheduler.inFlight[pair.ID] = struct{}{}
+
+	scheduler.dispatchDue()
+
+	if len(scheduler.jobs) != 0 {
+		t.Fatalf("queued %d jobs for a pair already syncing", len(scheduler.jobs))
+	}
+}
+
+func TestPairResyncSchedulerNextInterval(t *testing.T) {
+	recent := time.Now().Add(-10 * time.Minute)
+	yesterday := time.Now().Add(-2 * time.Hour)
+	lastWeek := time.Now().Add(-7 * 24 * time.Hour)
+
+	tests := []struct {
+		name     string
+		pair     *model.DexPair
+		failures int
+		want     time.Duration
+	}{
+		{name: "recent swap", pair: &model.DexPair{ID: "hot", LastSwapAt: &recent}, want: hotPairResyncInterval},
+		{name: "user holdings", pair: &model.DexPair{ID: "held", LastSwapAt: &lastWeek}, want: hotPairResyncInterval},
+		{name: "swap today", pair: &model.DexPair{ID: "warm", LastSwapAt: &yesterday}, want: warmPairResyncInterval},
+		{name: "dormant", pair: &model.DexPair{ID: "dormant", LastSwapAt: &lastWeek}, want: dormantPairResyncInterval},
+		{name: "never swapped", pair: &model.DexPair{ID: "new"}, want: dormantPairResyncInterval},
+		{name: "failures back off", pair: &model.DexPair{ID: "hot", LastSwapAt: &recent}, failures: 2, want: 4 * hotPairResyncInterval},
+		{name: "back off is capped", pair: &model.DexPair{ID: "warm", LastSwapAt: &yesterday}, failures: 10, want: dormantPairResyncInterval},
+	}
+
+	scheduler, _, _ := newTestResyncScheduler(nil, map[string]int64{"held": 3})
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := scheduler.nextInterval(context.Background(), test.pair, test.failures)
+
+			spread := time.Duration(float64(test.want) * resyncJitter)
+			if got < test.want-spread || got > test.want+spread {
+				t.Fatalf("nextInterval() = %v, want %v ± %v", got, test.want, spread)
+			}
+		})
+	}
+}
+
+func TestNeedsFullSync(t *testing.T) {
+	now := time.Now()
+	recent := now.Add(-fullPairResyncInterval / 2)
+	stale := now.Add(-fullPairResyncInterval)
+
+	if !needsFullSync(nil, now) {
+		t.Error("needsFullSync(nil) = false, want a first full sync")
+	}
+	if needsFullSync(&recent, now) {
+		t.Error("needsFullSync(recent) = true, want prices only")
+	}
+	if !needsFullSync(&stale, now) {
+		t.Error("needsFullSync(stale) = false, want a full sync")
+	}
+}
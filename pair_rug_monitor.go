@@ -0,0 +1,335 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/common/logger"
+	"github.com/cross-space-official/kaboom-service/common"
+	"github.com/cross-space-official/kaboom-service/model"
+	"github.com/cross-space-official/kaboom-service/repository"
+	"github.com/cross-space-official/kaboom-service/service/provider/evm"
+	common2 "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// reserveDropWindow is how far back the highest quote reserve is remembered.
+	reserveDropWindow = time.Hour
+	// rugReserveDrop and rugLiquidityRemoved are shares in model.PercentageBase units.
+	rugReserveDrop      = model.PercentageBase * 7 / 10
+	rugLiquidityRemoved = model.PercentageBase / 2
+	rugSellTax          = model.PercentageBase * 9 / 10
+)
+
+var (
+	PairSyncTopic = crypto.Keccak256Hash([]byte("Sync(uint112,uint112)"))
+	PairBurnTopic = crypto.Keccak256Hash([]byte("Burn(address,uint256,uint256,address)"))
+)
+
+type RugReason string
+
+const (
+	RugReasonReserveDrop      RugReason = "reserve_drop"
+	RugReasonDeployerRemoval  RugReason = "deployer_liquidity_removal"
+	RugReasonSellTaxJump      RugReason = "sell_tax_jump"
+	RugReasonHoneypotDetected RugReason = "honeypot"
+)
+
+type PairRuggedEvent struct {
+	PairID     string
+	ChainID    string
+	Reason     RugReason
+	Detail     string
+	TxHash     string
+	DetectedAt time.Time
+}
+
+type RugHandler func(ctx context.Context, event PairRuggedEvent)
+
+type PairRugMonitor interface {
+	// HandlePairLog inspects Sync and Burn logs emitted by a pair contract.
+	HandlePairLog(ctx context.Context, chainID string, log types.Log) businesserror.XSpaceBusinessError
+	// CheckSellTax reruns the sell simulation and flags a pair whose tax jumped towards 100%, it is as expensive
+	// as the simulation and belongs with the full syncs.
+	CheckSellTax(ctx context.Context, pair *model.DexPair) businesserror.XSpaceBusinessError
+	// OnRugged registers a handler, handlers run synchronously in registration order.
+	OnRugged(handler RugHandler)
+}
+
+type reserveSample struct {
+	At      time.Time
+	Reserve *big.Int
+}
+
+type pairRugMonitor struct {
+	gethService     evm.GethService
+	assetRepository repository.AssetRepository
+	safetySimulator *tokenSafetySimulator
+	quoteTokens     *quoteTokenService
+	reviews         *pairReviewMachine
+
+	mu       sync.Mutex
+	samples  map[string][]reserveSample
+	handlers []RugHandler
+}
+
+func (m *pairRugMonitor) OnRugged(handler RugHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.handlers = append(m.handlers, handler)
+}
+
+func (m *pairRugMonitor) HandlePairLog(ctx context.Context, chainID string, log types.Log) businesserror.XSpaceBusinessError {
+	if len(log.Topics) == 0 || (log.Topics[0] != PairSyncTopic && log.Topics[0] != PairBurnTopic) {
+		return nil
+	}
+
+	pair, err := m.assetRepository.TryRetrievePairByContractAddress(ctx, chainID, log.Address.Hex())
+	if err != nil {
+		return err
+	}
+	if pair == nil || pair.IsRugged {
+		return nil
+	}
+
+	if log.Topics[0] == PairSyncTopic {
+		return m.handleSync(ctx, pair, log)
+	}
+	return m.handleBurn(ctx, chainID, pair, log)
+}
+
+// handleSync compares the new quote reserve with the highest one seen within reserveDropWindow.
+func (m *pairRugMonitor) handleSync(ctx context.Context, pair *model.DexPair, log types.Log) businesserror.XSpaceBusinessError {
+	if len(log.Data) < 64 {
+		return nil
+	}
+
+	reserve0 := new(big.Int).SetBytes(log.Data[:32])
+	reserve1 := new(big.Int).SetBytes(log.Data[32:64])
+	quoteReserve := reserve1
+	if !isTokenToken0(pair) {
+		quoteReserve = reserve0
+	}
+
+	peak := m.recordReserve(pair, quoteReserve, time.Now())
+	drop := shareOf(new(big.Int).Sub(peak, quoteReserve), peak)
+	if drop < rugReserveDrop {
+		return nil
+	}
+
+	return m.markRugged(ctx, pair, PairRuggedEvent{
+		Reason: RugReasonReserveDrop,
+		Detail: fmt.Sprintf("quote reserve dropped %d of %d within %s", drop, model.PercentageBase, reserveDropWindow),
+		TxHash: log.TxHash.Hex(),
+	})
+}
+
+// handleBurn flags a removal of most of the liquidity by the deployer or the current owner. The Burn recipient is
+// the router for removeLiquidityETH, so the sender of the transaction is checked as well.
+func (m *pairRugMonitor) handleBurn(ctx context.Context, chainID string, pair *model.DexPair, log types.Log) businesserror.XSpaceBusinessError {
+	if len(log.Data) < 64 || len(log.Topics) < 3 {
+		return nil
+	}
+
+	remover := common2.BytesToAddress(log.Topics[2].Bytes())
+	if !m.isDeployer(ctx, pair, remover) {
+		sender, err := m.txSender(ctx, chainID, log)
+		if err != nil {
+			return err
+		}
+		if !m.isDeployer(ctx, pair, sender) {
+			return nil
+		}
+		remover = sender
+	}
+
+	amount0 := new(big.Int).SetBytes(log.Data[:32])
+	amount1 := new(big.Int).SetBytes(log.Data[32:64])
+	removed := amount1
+	if !isTokenToken0(pair) {
+		removed = amount0
+	}
+
+	// the stored reserve predates the burn, the following Sync updates it
+	before := pair.GetWNativeReserve()
+	share := shareOf(removed, before)
+	if share < rugLiquidityRemoved {
+		return nil
+	}
+
+	return m.markRugged(ctx, pair, PairRuggedEvent{
+		Reason: RugReasonDeployerRemoval,
+		Detail: fmt.Sprintf("%s removed %d of %d of the liquidity", remover.Hex(), share, model.PercentageBase),
+		TxHash: log.TxHash.Hex(),
+	})
+}
+
+func (m *pairRugMonitor) CheckSellTax(ctx context.Context, pair *model.DexPair) businesserror.XSpaceBusinessError {
+	if pair.IsRugged {
+		return nil
+	}
+
+	wasHoneypot := pair.GetToken().IsHoneypot
+	result, err := m.safetySimulator.SimulateAndStore(ctx, pair)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case result.Status == TokenSafetyStatusHoneypot && !wasHoneypot:
+		return m.markRugged(ctx, pair, PairRuggedEvent{
+			Reason: RugReasonHoneypotDetected,
+			Detail: result.Reason,
+		})
+	case result.SellTax >= rugSellTax:
+		return m.markRugged(ctx, pair, PairRuggedEvent{
+			Reason: RugReasonSellTaxJump,
+			Detail: fmt.Sprintf("sell tax %d of %d", result.SellTax, model.PercentageBase),
+		})
+	}
+
+	return nil
+}
+
+// txSender recovers the sender of the transaction that emitted log.
+func (m *pairRugMonitor) txSender(ctx context.Context, chainID string, log types.Log) (common2.Address, businesserror.XSpaceBusinessError) {
+	client, err := m.gethService.GetClient(chainID)
+	if err != nil {
+		return common2.Address{}, err
+	}
+
+	tx, _, basicErr := client.TransactionByHash(ctx, log.TxHash)
+	if basicErr != nil {
+		return common2.Address{}, common.NewRuntimeError(basicErr)
+	}
+
+	sender, basicErr := client.TransactionSender(ctx, tx, log.BlockHash, log.TxIndex)
+	if basicErr != nil {
+		return common2.Address{}, common.NewRuntimeError(basicErr)
+	}
+	return sender, nil
+}
+
+func (m *pairRugMonitor) isDeployer(ctx context.Context, pair *model.DexPair, address common2.Address) bool {
+	token := pair.GetToken()
+	if token.DeployerAddress != "" && strings.EqualFold(token.DeployerAddress, address.Hex()) {
+		return true
+	}
+
+	owner, err := m.gethService.GetTokenOwnerAddress(ctx, token)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(owner, address.Hex())
+}
+
+// recordReserve appends the sample and returns the highest reserve within the window, the new one included.
+func (m *pairRugMonitor) recordReserve(pair *model.DexPair, reserve *big.Int, at time.Time) *big.Int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	samples := m.samples[pair.ID]
+	if lastSyncedAt := pair.SyncState.LastSyncedAt; len(samples) == 0 && lastSyncedAt != nil {
+		// seed with the stored reserve at the time it was read, so a drop in the first observed Sync still counts
+		// while a reserve older than the window does not
+		samples = append(samples, reserveSample{At: *lastSyncedAt, Reserve: pair.GetWNativeReserve()})
+	}
+	samples = append(samples, reserveSample{At: at, Reserve: reserve})
+
+	for len(samples) > 0 && at.Sub(samples[0].At) > reserveDropWindow {
+		samples = samples[1:]
+	}
+	m.samples[pair.ID] = samples
+
+	peak := big.NewInt(0)
+	for _, sample := range samples {
+		if sample.Reserve.Cmp(peak) > 0 {
+			peak = sample.Reserve
+		}
+	}
+	return peak
+}
+
+// updatePrimaryPool is the monitor's own rug handler, it moves the primary flag of the token off the rugged pair.
+func (m *pairRugMonitor) updatePrimaryPool(ctx context.Context, event PairRuggedEvent) {
+	pair, err := m.assetRepository.RetrievePairByPairID(ctx, event.PairID)
+	if err != nil {
+		logger.GetLoggerEntry(ctx).
+			WithField("pair_id", event.PairID).
+			Errorf("error retrieving rugged pair, %v", err)
+		return
+	}
+
+	updatePrimaryPool(ctx, m.assetRepository, m.quoteTokens, pair)
+}
+
+// markRugged unpublishes the pair, records why and notifies the handlers.
+func (m *pairRugMonitor) markRugged(ctx context.Context, pair *model.DexPair, event PairRuggedEvent) businesserror.XSpaceBusinessError {
+	event.PairID = pair.ID
+	event.ChainID = pair.ChainID
+	event.DetectedAt = time.Now()
+
+	err := m.reviews.Reject(ctx, pair, PairReviewActorSystem, fmt.Sprintf("rugged, %s: %s", event.Reason, event.Detail))
+	if err != nil {
+		return err
+	}
+
+	pair.IsRugged = true
+	pair.RugReason = string(event.Reason)
+	pair.RugDetail = event.Detail
+	pair.RuggedAt = &event.DetectedAt
+
+	err = m.assetRepository.UpdateDexPair(ctx, pair)
+	if err != nil {
+		return err
+	}
+
+	logger.GetLoggerEntry(ctx).
+		WithField("pair_id", pair.ID).
+		WithField("reason", event.Reason).
+		Infof("pair marked rugged, %s", event.Detail)
+
+	m.mu.Lock()
+	delete(m.samples, pair.ID)
+	handlers := append([]RugHandler{}, m.handlers...)
+	m.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(ctx, event)
+	}
+	return nil
+}
+
+// NewPairRugMonitor builds the monitor with market caches of its own, see NewPairRugMonitorWithMarketData.
+func NewPairRugMonitor(
+	gethService evm.GethService,
+	assetRepository repository.AssetRepository,
+) PairRugMonitor {
+	return NewPairRugMonitorWithMarketData(gethService, assetRepository, NewMarketData(gethService, assetRepository))
+}
+
+func NewPairRugMonitorWithMarketData(
+	gethService evm.GethService,
+	assetRepository repository.AssetRepository,
+	market *MarketData,
+) PairRugMonitor {
+	quoteTokens := market.quoteTokens
+
+	monitor := &pairRugMonitor{
+		gethService:     gethService,
+		assetRepository: assetRepository,
+		safetySimulator: newTokenSafetySimulator(gethService, market.chains, newV3PoolReader(gethService), quoteTokens, newLaunchpadReader(gethService), assetRepository),
+		quoteTokens:     quoteTokens,
+		reviews:         newPairReviewMachine(assetRepository),
+		samples:         map[string][]reserveSample{},
+	}
+	monitor.OnRugged(monitor.updatePrimaryPool)
+	return monitor
+}
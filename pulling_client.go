@@ -2,6 +2,7 @@ package eventsync
 
 import (
 	"context"
+	"github.com/cross-space-official/common/businesserror"
 	"github.com/cross-space-official/common/logger"
 	"github.com/cross-space-official/common/utils"
 	"github.com/cross-space-official/kaboom-service/configs"
@@ -18,12 +19,15 @@ import (
 type SyncClient interface {
 	GetEthClient() *ethclient.Client
 	TryFetchLogs(ctx context.Context, addresses []string, topics []string, startingBlockHeight uint64, endingBlockHeight *uint64, retryCount int) []types.Log
+	Close()
 }
 
 type evmEventSyncClient struct {
 	chainID string
 	client  *ethclient.Client
 	config  configs.OnchainClientConfig
+	// onFailure is told about failed log fetches, the client manager uses it to redial the chain.
+	onFailure func(err error)
 }
 
 func (c *evmEventSyncClient) GetEthClient() *ethclient.Client {
@@ -47,7 +51,7 @@ func (c *evmEventSyncClient) TryFetchLogs(ctx context.Context, addresses []strin
 
 	historyLogs, err := c.client.FilterLogs(ctx, query)
 	if err != nil {
-		logger.GetLoggerEntry(ctx).Errorf("chain %s error getting history log, %v, from %v", c.chainID, err, startingBlockHeight)
+		logger.GetLoggerEntry(ctx).Errorf("chain %s error getting history log, %v, from %v", c.chainID, RedactError(err), startingBlockHeight)
 		if strings.Contains(err.Error(), "Log response size exceeded.") {
 			pattern := regexp.MustCompile(`\[0x([0-9a-fA-F]+), 0x([0-9a-fA-F]+)\]`)
 			matches := pattern.FindStringSubmatch(err.Error())
@@ -74,6 +78,9 @@ func (c *evmEventSyncClient) TryFetchLogs(ctx context.Context, addresses []strin
 			secondHistoryLogs := c.TryFetchLogs(ctx, addresses, topics, suggestedEndingBlockHeight+1, endingBlockHeight, retryCount+1)
 			return append(firstHistoryLogs, secondHistoryLogs...)
 		} else {
+			if c.onFailure != nil {
+				c.onFailure(err)
+			}
 			return nil
 		}
 	}
@@ -81,21 +88,46 @@ func (c *evmEventSyncClient) TryFetchLogs(ctx context.Context, addresses []strin
 	return historyLogs
 }
 
+func (c *evmEventSyncClient) Close() {
+	c.client.Close()
+}
+
+// NewEventSyncClient dials the chain of config, it returns nil when the chain cannot be dialed.
+// DialEventSyncClient returns the error instead, the ClientManager also retries and health checks the chain.
 func NewEventSyncClient(
 	config configs.OnchainClientConfig,
 ) SyncClient {
-	client, err := NewEthClient(config)
+	client, err := dialEventSyncClient(config)
 	if err != nil {
-		panic(err)
+		logger.GetLoggerEntry(context.Background()).
+			WithField("chain_id", config.ChainID).
+			Errorf("error dialing chain client, %v", err)
+		return nil
 	}
 
-	if client == nil {
-		return nil
+	return client
+}
+
+func DialEventSyncClient(
+	config configs.OnchainClientConfig,
+) (SyncClient, businesserror.XSpaceBusinessError) {
+	client, err := dialEventSyncClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+func dialEventSyncClient(config configs.OnchainClientConfig) (*evmEventSyncClient, businesserror.XSpaceBusinessError) {
+	client, err := NewEthClient(config)
+	if err != nil {
+		return nil, err
 	}
 
 	return &evmEventSyncClient{
 		chainID: config.ChainID,
 		client:  client,
 		config:  config,
-	}
+	}, nil
 }
@@ -0,0 +1,317 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/kaboom-service/common"
+	"github.com/cross-space-official/kaboom-service/core"
+	"github.com/cross-space-official/kaboom-service/model"
+	"github.com/cross-space-official/kaboom-service/service/provider/evm"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	common2 "github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// QuoteToken is a whitelisted non-native quote side, priced through its pool against the wrapped native token.
+type QuoteToken struct {
+	Address  string
+	Symbol   string
+	Decimals int32
+	IsStable bool
+	// NativePairAddress is the V2 quote/wrapped native pool used for valuation, NativePairType its dex. Trades hop
+	// through the quote/wrapped native pool of the traded pair's own dex instead, see hopPool.
+	NativePairAddress string
+	NativePairType    model.PairType
+}
+
+var quoteTokens = map[string][]QuoteToken{
+	"1": {
+		{Address: "0xdAC17F958D2ee523a2206206994597C13D831ec7", Symbol: "USDT", Decimals: 6, IsStable: true, NativePairAddress: "0x0d4a11d5EEaaC28EC3F61d100daF4d40471f1852", NativePairType: model.PairTypeUniSwapV2},
+		{Address: "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", Symbol: "USDC", Decimals: 6, IsStable: true, NativePairAddress: "0xB4e16d0168e52d35CaCD2c6185b44281Ec28C9Dc", NativePairType: model.PairTypeUniSwapV2},
+	},
+	"56": {
+		{Address: "0x55d398326f99059fF775485246999027B3197955", Symbol: "USDT", Decimals: 18, IsStable: true, NativePairAddress: "0x16b9a82891338f9bA80E2D6970FddA79D1eb0daE", NativePairType: model.PairTypePancakeSwapV2},
+		{Address: "0x8AC76a51cc950d9822D68b83fE1Ad97B32Cd580d", Symbol: "USDC", Decimals: 18, IsStable: true, NativePairAddress: "0xd99c7F6C65857AC913a8f880A4cb84032AB2FC5b", NativePairType: model.PairTypePancakeSwapV2},
+	},
+}
+
+// v2RouterAddresses are the built in routers of ChainMetadata.DexRouters, read them through the chain metadata cache.
+var v2RouterAddresses = map[string]map[model.PairType]string{
+	"1": {
+		model.PairTypeUniSwapV2: "0x7a250d5630B4cF539739dF2C5dAcb4c659F2488D",
+	},
+	"56": {
+		model.PairTypePancakeSwapV2: "0x10ED43C718714eb63d5aA57B78B54704E256024E",
+		model.PairTypeUniSwapV2:     "0x4752ba5DBc23f44D87826276BF6Fd6b1C372aD24",
+	},
+	"8453": {
+		model.PairTypeUniSwapV2: "0x4752ba5DBc23f44D87826276BF6Fd6b1C372aD24",
+	},
+}
+
+func findQuoteToken(chainID, address string) (*QuoteToken, bool) {
+	for i := range quoteTokens[chainID] {
+		if strings.EqualFold(quoteTokens[chainID][i].Address, address) {
+			return &quoteTokens[chainID][i], true
+		}
+	}
+
+	return nil, false
+}
+
+// detectTokenSides returns the traded token and the quote side, preferring the native token as quote.
+func detectTokenSides(chainID, nativeAddress string, token0, token1 common2.Address) (common2.Address, common2.Address, businesserror.XSpaceBusinessError) {
+	isQuote := func(address common2.Address) bool {
+		if strings.EqualFold(address.String(), nativeAddress) {
+			return true
+		}
+		_, ok := findQuoteToken(chainID, address.String())
+		return ok
+	}
+
+	switch {
+	case strings.EqualFold(token0.String(), nativeAddress):
+		return token1, token0, nil
+	case strings.EqualFold(token1.String(), nativeAddress):
+		return token0, token1, nil
+	case isQuote(token0) && !isQuote(token1):
+		return token1, token0, nil
+	case isQuote(token1) && !isQuote(token0):
+		return token0, token1, nil
+	default:
+		return common2.Address{}, common2.Address{}, common.NewRuntimeError(
+			fmt.Errorf("pair of %s and %s has no whitelisted quote token", token0.String(), token1.String()))
+	}
+}
+
+// getQuoteToken returns the non traded side of a pair, the wrapped native token or a whitelisted quote token.
+func getQuoteToken(pair *model.DexPair) model.Token {
+	if pair.Token0ID == pair.GetToken().ID {
+		return pair.Token1
+	}
+	return pair.Token0
+}
+
+func isNativeQuoted(pair *model.DexPair) bool {
+	_, ok := findQuoteToken(pair.ChainID, getQuoteToken(pair).ContractAddress)
+	return !ok
+}
+
+type quoteTokenService struct {
+	v2RouterAbi abi.ABI
+	gethService evm.GethService
+}
+
+// nativeReserves returns the native and quote reserves of a quote/wrapped native V2 pool.
+func (q *quoteTokenService) nativeReserves(ctx context.Context, chainID, poolAddress, quoteAddress string) (*big.Int, *big.Int, businesserror.XSpaceBusinessError) {
+	client, err := q.gethService.GetClient(chainID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	instance, basicErr := core.NewUniswapv2pair(common2.HexToAddress(poolAddress), client)
+	if basicErr != nil {
+		return nil, nil, common.NewRuntimeError(basicErr)
+	}
+
+	token0, basicErr := instance.Token0(nil)
+	if basicErr != nil {
+		return nil, nil, common.NewRuntimeError(basicErr)
+	}
+
+	reserve, basicErr := instance.GetReserves(nil)
+	if basicErr != nil {
+		return nil, nil, common.NewRuntimeError(basicErr)
+	}
+
+	if strings.EqualFold(token0.String(), quoteAddress) {
+		return reserve.Reserve1, reserve.Reserve0, nil
+	}
+	return reserve.Reserve0, reserve.Reserve1, nil
+}
+
+// QuoteToNative converts an amount of quote token into native at the spot price, it is used for valuation only.
+func (q *quoteTokenService) QuoteToNative(ctx context.Context, chainID, quoteAddress string, amount *big.Int) (*big.Int, businesserror.XSpaceBusinessError) {
+	quote, ok := findQuoteToken(chainID, quoteAddress)
+	if !ok {
+		return amount, nil
+	}
+
+	nativeReserve, quoteReserve, err := q.nativeReserves(ctx, chainID, quote.NativePairAddress, quote.Address)
+	if err != nil {
+		return nil, err
+	}
+	if quoteReserve.Sign() == 0 {
+		return nil, common.NewRuntimeError(errors.New("empty quote token pool"))
+	}
+
+	return new(big.Int).Div(new(big.Int).Mul(amount, nativeReserve), quoteReserve), nil
+}
+
+// NativePriceInUSD derives the usd spot price of one native token from the deepest stable quote pool of the chain.
+func (q *quoteTokenService) NativePriceInUSD(ctx context.Context, chainID string) (decimal.Decimal, businesserror.XSpaceBusinessError) {
+	price := decimal.Zero
+	deepest := big.NewInt(0)
+	for i := range quoteTokens[chainID] {
+		quote := &quoteTokens[chainID][i]
+		if !quote.IsStable {
+			continue
+		}
+
+		nativeReserve, quoteReserve, err := q.nativeReserves(ctx, chainID, quote.NativePairAddress, quote.Address)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		if nativeReserve.Cmp(deepest) <= 0 {
+			continue
+		}
+
+		stable := decimal.NewFromBigInt(quoteReserve, -quote.Decimals)
+		native := decimal.NewFromBigInt(nativeReserve, 0).Div(model.GetChainNativeByID(chainID))
+		price = stable.Div(native)
+		deepest = nativeReserve
+	}
+
+	if deepest.Sign() == 0 {
+		return decimal.Zero, common.NewRuntimeError(fmt.Errorf("no stable quote pool for chain %s", chainID))
+	}
+
+	return price, nil
+}
+
+// NativeToQuoteOut and QuoteToNativeOut quote the routing hop through the quote/wrapped native pool of the pair's dex,
+// the pool its router swaps through.
+func (q *quoteTokenService) NativeToQuoteOut(ctx context.Context, pair *model.DexPair, nativeAddress string, nativeIn *big.Int) (*big.Int, businesserror.XSpaceBusinessError) {
+	feeModel, nativeReserve, quoteReserve, err := q.hopPool(ctx, pair, nativeAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	return feeModel.AmountOut(nativeIn, nativeReserve, quoteReserve, core.TransferTax{}), nil
+}
+
+func (q *quoteTokenService) QuoteToNativeOut(ctx context.Context, pair *model.DexPair, nativeAddress string, quoteIn *big.Int) (*big.Int, businesserror.XSpaceBusinessError) {
+	feeModel, nativeReserve, quoteReserve, err := q.hopPool(ctx, pair, nativeAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	return feeModel.AmountOut(quoteIn, quoteReserve, nativeReserve, core.TransferTax{}), nil
+}
+
+func (q *quoteTokenService) NativeInForQuoteOut(ctx context.Context, pair *model.DexPair, nativeAddress string, quoteOut *big.Int) (*big.Int, businesserror.XSpaceBusinessError) {
+	feeModel, nativeReserve, quoteReserve, err := q.hopPool(ctx, pair, nativeAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	amountIn, basicErr := feeModel.AmountIn(quoteOut, nativeReserve, quoteReserve, core.TransferTax{})
+	if basicErr != nil {
+		return nil, common.NewRuntimeError(basicErr)
+	}
+	return amountIn, nil
+}
+
+func (q *quoteTokenService) QuoteInForNativeOut(ctx context.Context, pair *model.DexPair, nativeAddress string, nativeOut *big.Int) (*big.Int, businesserror.XSpaceBusinessError) {
+	feeModel, nativeReserve, quoteReserve, err := q.hopPool(ctx, pair, nativeAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	amountIn, basicErr := feeModel.AmountIn(nativeOut, quoteReserve, nativeReserve, core.TransferTax{})
+	if basicErr != nil {
+		return nil, common.NewRuntimeError(basicErr)
+	}
+	return amountIn, nil
+}
+
+// hopPool returns the fee model and the native and quote reserves of the pool BuyPath and SellPath hop through,
+// the CREATE2 quote/wrapped native pair of the factory behind the pair type's router.
+func (q *quoteTokenService) hopPool(ctx context.Context, pair *model.DexPair, nativeAddress string) (core.AmmFeeModel, *big.Int, *big.Int, businesserror.XSpaceBusinessError) {
+	quoteAddress := getQuoteToken(pair).ContractAddress
+	if _, ok := findQuoteToken(pair.ChainID, quoteAddress); !ok {
+		return core.AmmFeeModel{}, nil, nil, common.NewRuntimeError(fmt.Errorf("%s is not a quote token", quoteAddress))
+	}
+
+	factory, ok := pairFactories[pair.ChainID][pair.Type]
+	if !ok {
+		return core.AmmFeeModel{}, nil, nil, common.NewRuntimeError(fmt.Errorf("no %s factory configured for chain %s", pair.Type, pair.ChainID))
+	}
+
+	poolAddress := pairCreate2Address(pair.Type, factory, &pairOnchainState{
+		Token0: common2.HexToAddress(nativeAddress),
+		Token1: common2.HexToAddress(quoteAddress),
+	})
+
+	nativeReserve, quoteReserve, err := q.nativeReserves(ctx, pair.ChainID, poolAddress.Hex(), quoteAddress)
+	if err != nil {
+		return core.AmmFeeModel{}, nil, nil, err
+	}
+	return getV2FeeModel(pair.Type), nativeReserve, quoteReserve, nil
+}
+
+// BuyPath and SellPath route through the wrapped native token and, for non-native quoted pairs, the quote token.
+func (q *quoteTokenService) BuyPath(pair *model.DexPair, nativeAddress string) []common2.Address {
+	path := []common2.Address{common2.HexToAddress(nativeAddress)}
+	if !isNativeQuoted(pair) {
+		path = append(path, common2.HexToAddress(getQuoteToken(pair).ContractAddress))
+	}
+	return append(path, common2.HexToAddress(pair.GetToken().ContractAddress))
+}
+
+func (q *quoteTokenService) SellPath(pair *model.DexPair, nativeAddress string) []common2.Address {
+	path := []common2.Address{common2.HexToAddress(pair.GetToken().ContractAddress)}
+	if !isNativeQuoted(pair) {
+		path = append(path, common2.HexToAddress(getQuoteToken(pair).ContractAddress))
+	}
+	return append(path, common2.HexToAddress(nativeAddress))
+}
+
+func hexAddresses(path []common2.Address) []string {
+	addresses := make([]string, 0, len(path))
+	for _, address := range path {
+		addresses = append(addresses, address.Hex())
+	}
+	return addresses
+}
+
+func (q *quoteTokenService) PackBuyData(pair *model.DexPair, nativeAddress, recipient string, minimalOutAmountInWei *big.Int) ([]byte, businesserror.XSpaceBusinessError) {
+	raw, err := q.v2RouterAbi.Pack(
+		"swapExactETHForTokensSupportingFeeOnTransferTokens",
+		minimalOutAmountInWei,
+		q.BuyPath(pair, nativeAddress),
+		common2.HexToAddress(recipient),
+		big.NewInt(time.Now().Add(10*time.Minute).Unix()),
+	)
+	if err != nil {
+		return nil, common.NewRuntimeError(err)
+	}
+	return raw, nil
+}
+
+func (q *quoteTokenService) PackSellData(pair *model.DexPair, nativeAddress, recipient string, amountInWei, minimalOutAmountInWei *big.Int) ([]byte, businesserror.XSpaceBusinessError) {
+	raw, err := q.v2RouterAbi.Pack(
+		"swapExactTokensForETHSupportingFeeOnTransferTokens",
+		amountInWei,
+		minimalOutAmountInWei,
+		q.SellPath(pair, nativeAddress),
+		common2.HexToAddress(recipient),
+		big.NewInt(time.Now().Add(10*time.Minute).Unix()),
+	)
+	if err != nil {
+		return nil, common.NewRuntimeError(err)
+	}
+	return raw, nil
+}
+
+func newQuoteTokenService(gethService evm.GethService) *quoteTokenService {
+	return &quoteTokenService{
+		v2RouterAbi: readAbiResource("./resource/uniswap_v2_router_abi.json"),
+		gethService: gethService,
+	}
+}
@@ -0,0 +1,109 @@
+-- Columns and tables the dex pair pipeline reads and writes through model.Chain, model.DexPair, model.Token,
+-- model.PairPriceSnapshot and model.PairReviewEvent. Amounts in wei are numeric(78, 0) like the
+-- existing reserve and supply columns, shares and taxes are in model.PercentageBase units.
+
+-- pair type to router address, overrides the built in dex routers of the chain
+ALTER TABLE chains
+    ADD COLUMN IF NOT EXISTS dex_router_addresses jsonb,
+    -- name, address and token_address of the wallets kept out of circulating supply, no token_address for all tokens
+    ADD COLUMN IF NOT EXISTS supply_exclusions    jsonb;
+
+ALTER TABLE dex_pairs
+    ADD COLUMN IF NOT EXISTS fee_tier          integer      NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS liquidity_usd     numeric,
+    ADD COLUMN IF NOT EXISTS liquidity_depth   jsonb,
+    ADD COLUMN IF NOT EXISTS locked_supply     numeric(78, 0) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS locked_until      timestamptz,
+    ADD COLUMN IF NOT EXISTS locked_liquidity  bigint       NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS is_rugged         boolean      NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS rug_reason        text         NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS rug_detail        text         NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS rugged_at         timestamptz,
+    ADD COLUMN IF NOT EXISTS migrated_pair_id  text         NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS migrated_at       timestamptz,
+    ADD COLUMN IF NOT EXISTS last_swap_at      timestamptz,
+    ADD COLUMN IF NOT EXISTS last_synced_at    timestamptz,
+    ADD COLUMN IF NOT EXISTS last_full_sync_at timestamptz,
+    ADD COLUMN IF NOT EXISTS next_sync_at      timestamptz,
+    ADD COLUMN IF NOT EXISTS last_sync_error   text         NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS sync_failures     integer      NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS is_primary        boolean      NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS review_state      text         NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS reviewed_by       text         NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS review_reason     text         NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS reviewed_at       timestamptz;
+
+-- RetrievePublishedPairsDueForSync
+CREATE INDEX IF NOT EXISTS dex_pairs_published_next_sync_at_idx ON dex_pairs (next_sync_at) WHERE is_published;
+-- TryRetrievePairByContractAddress, and CreateDexPair and CreatePairWithToken fail on a pair another instance
+-- created first, pairCreationLocks only serializes within one process
+CREATE UNIQUE INDEX IF NOT EXISTS dex_pairs_chain_id_contract_address_key ON dex_pairs (chain_id, lower(contract_address));
+-- RetrievePairsByTokenID, UpdatePrimaryPair
+CREATE INDEX IF NOT EXISTS dex_pairs_token0_id_idx ON dex_pairs (token0_id);
+CREATE INDEX IF NOT EXISTS dex_pairs_token1_id_idx ON dex_pairs (token1_id);
+-- RetrievePairsByReviewState
+CREATE INDEX IF NOT EXISTS dex_pairs_review_state_idx ON dex_pairs (chain_id, review_state, reviewed_at);
+
+ALTER TABLE tokens
+    ADD COLUMN IF NOT EXISTS deployer_address              text         NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS buy_tax                       bigint       NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS sell_tax                      bigint       NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS transfer_tax                  bigint       NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS is_honeypot                   boolean      NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS safety_status                 text         NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS safety_check_reason           text         NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS safety_checked_at             timestamptz,
+    ADD COLUMN IF NOT EXISTS risk_report                   jsonb,
+    ADD COLUMN IF NOT EXISTS risk_score                    integer      NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS risk_analyzed_at              timestamptz,
+    ADD COLUMN IF NOT EXISTS is_proxy                      boolean      NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS has_max_tx_limit              boolean      NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS has_max_wallet_limit          boolean      NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS icon_source                   text         NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS icon_override_url             text         NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS icon_checked_at               timestamptz,
+    ADD COLUMN IF NOT EXISTS metadata_status               text         NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS metadata_attempts             integer      NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS decimals_known                boolean      NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS price_usd                     numeric,
+    ADD COLUMN IF NOT EXISTS market_cap_usd                numeric,
+    ADD COLUMN IF NOT EXISTS circulating_supply            numeric(78, 0) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS fully_diluted_value_in_native numeric(78, 0) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS fully_diluted_value_usd       numeric,
+    ADD COLUMN IF NOT EXISTS holder_count                  bigint       NOT NULL DEFAULT 0;
+
+-- TryRetrieveTokenByContractAddress
+CREATE INDEX IF NOT EXISTS tokens_chain_id_contract_address_idx ON tokens (chain_id, lower(contract_address));
+
+CREATE TABLE IF NOT EXISTS dex_pair_price_snapshots (
+    id                   bigserial PRIMARY KEY,
+    pair_id              text           NOT NULL,
+    token_id             text           NOT NULL,
+    chain_id             text           NOT NULL,
+    price_in_native      numeric        NOT NULL,
+    price_usd            numeric,
+    reserve0             numeric(78, 0) NOT NULL,
+    reserve1             numeric(78, 0) NOT NULL,
+    market_cap_in_native numeric(78, 0) NOT NULL,
+    market_cap_usd       numeric,
+    liquidity_usd        numeric,
+    captured_at          timestamptz    NOT NULL
+);
+
+-- RetrievePairPriceSnapshots, RetrievePairPriceSnapshotAt, DownsamplePairPriceSnapshots
+CREATE INDEX IF NOT EXISTS dex_pair_price_snapshots_pair_id_captured_at_idx ON dex_pair_price_snapshots (pair_id, captured_at);
+-- DeletePairPriceSnapshotsBefore
+CREATE INDEX IF NOT EXISTS dex_pair_price_snapshots_captured_at_idx ON dex_pair_price_snapshots (captured_at);
+
+CREATE TABLE IF NOT EXISTS dex_pair_review_events (
+    id         bigserial PRIMARY KEY,
+    pair_id    text        NOT NULL,
+    from_state text        NOT NULL,
+    to_state   text        NOT NULL,
+    actor      text        NOT NULL,
+    reason     text        NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL
+);
+
+-- RetrievePairReviewEvents
+CREATE INDEX IF NOT EXISTS dex_pair_review_events_pair_id_idx ON dex_pair_review_events (pair_id, created_at);
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/kaboom-service/common"
+	"github.com/cross-space-official/kaboom-service/model"
+	"github.com/cross-space-official/kaboom-service/service/provider/evm"
+	"github.com/ethereum/go-ethereum"
+	common2 "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"math/big"
+	"time"
+)
+
+const (
+	// holderCountMaxCalls bounds the eth_getLogs calls of one count and so how far back the transfers of a token
+	// are replayed, older tokens are not counted
+	holderCountMaxCalls = 25
+	// holderCountBlockRange is the block range of one eth_getLogs call
+	holderCountBlockRange = 2000
+	// holderCountTimeout bounds one count, screening waits on it
+	holderCountTimeout = 10 * time.Second
+)
+
+var (
+	tokenTransferTopic  = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+	totalSupplySelector = common2.FromHex("0x18160ddd")
+)
+
+type tokenHolderCounter struct {
+	gethService evm.GethService
+}
+
+// Count replays the token's Transfer logs backwards from the head and returns how many wallets hold a balance,
+// burn addresses left out, and whether the count is known. The replay stops once the transfers seen mint the whole
+// supply, the balances before that block are then all zero. The count is unknown when that does not happen within
+// holderCountMaxCalls and holderCountTimeout: a token that old, or one whose balances do not follow its Transfer logs
+// like a reflection token.
+func (c *tokenHolderCounter) Count(ctx context.Context, chainID string, token model.Token) (int64, bool, businesserror.XSpaceBusinessError) {
+	client, err := c.gethService.GetClient(chainID)
+	if err != nil {
+		return 0, false, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, holderCountTimeout)
+	defer cancel()
+
+	// running out of time leaves the count unknown rather than failing the caller
+	failed := func(basicErr error) (int64, bool, businesserror.XSpaceBusinessError) {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return 0, false, nil
+		}
+		return 0, false, common.NewRuntimeError(basicErr)
+	}
+
+	head, basicErr := client.BlockNumber(ctx)
+	if basicErr != nil {
+		return failed(basicErr)
+	}
+
+	// the supply and the logs are read at the same head so a mint in between cannot break the reconciliation
+	address := common2.HexToAddress(token.ContractAddress)
+	output, basicErr := client.CallContract(ctx, ethereum.CallMsg{To: &address, Data: totalSupplySelector}, new(big.Int).SetUint64(head))
+	if basicErr != nil {
+		return failed(basicErr)
+	}
+	totalSupply := new(big.Int).SetBytes(output)
+
+	zero := common2.Address{}
+	balances := map[common2.Address]*big.Int{}
+	minted := new(big.Int)
+	move := func(holder common2.Address, amount *big.Int) {
+		if holder == zero {
+			minted.Sub(minted, amount)
+			return
+		}
+		if balances[holder] == nil {
+			balances[holder] = new(big.Int)
+		}
+		balances[holder].Add(balances[holder], amount)
+	}
+
+	to := head
+	for calls := 0; calls < holderCountMaxCalls; calls++ {
+		from := uint64(0)
+		if to >= holderCountBlockRange {
+			from = to - holderCountBlockRange + 1
+		}
+
+		logs, basicErr := client.FilterLogs(ctx, ethereum.FilterQuery{
+			FromBlock: new(big.Int).SetUint64(from),
+			ToBlock:   new(big.Int).SetUint64(to),
+			Addresses: []common2.Address{address},
+			Topics:    [][]common2.Hash{{tokenTransferTopic}},
+		})
+		if basicErr != nil {
+			return failed(basicErr)
+		}
+
+		for _, log := range logs {
+			if log.Removed || len(log.Topics) != 3 || len(log.Data) != 32 {
+				continue
+			}
+
+			amount := new(big.Int).SetBytes(log.Data)
+			move(common2.BytesToAddress(log.Topics[1].Bytes()), new(big.Int).Neg(amount))
+			move(common2.BytesToAddress(log.Topics[2].Bytes()), amount)
+		}
+
+		if minted.Cmp(totalSupply) == 0 && totalSupply.Sign() > 0 {
+			return countHolders(balances), true, nil
+		}
+		if from == 0 {
+			break
+		}
+		to = from - 1
+	}
+
+	return 0, false, nil
+}
+
+// countHolders counts the positive balances, burn addresses left out.
+func countHolders(balances map[common2.Address]*big.Int) int64 {
+	burned := map[common2.Address]bool{}
+	for _, address := range supplyBurnAddresses {
+		burned[common2.HexToAddress(address)] = true
+	}
+
+	var count int64
+	for holder, balance := range balances {
+		if balance.Sign() > 0 && !burned[holder] {
+			count++
+		}
+	}
+	return count
+}
+
+func newTokenHolderCounter(gethService evm.GethService) *tokenHolderCounter {
+	return &tokenHolderCounter{
+		gethService: gethService,
+	}
+}
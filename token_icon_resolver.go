@@ -0,0 +1,476 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/common/logger"
+	"github.com/cross-space-official/kaboom-service/common"
+	"github.com/cross-space-official/kaboom-service/model"
+	"github.com/cross-space-official/kaboom-service/repository"
+	common2 "github.com/ethereum/go-ethereum/common"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	iconMaxBytes      = 512 * 1024
+	iconMinDimension  = 16
+	iconMaxDimension  = 2048
+	iconFetchTimeout  = 10 * time.Second
+	iconRetryInterval = 6 * time.Hour
+	tokenListTTL      = 6 * time.Hour
+
+	iconBucket = "kaboom"
+
+	IconSourceIdenticon = "identicon"
+)
+
+// IconCandidate is an image a source found, URL is where it was fetched from and empty for generated images.
+type IconCandidate struct {
+	Data []byte
+	URL  string
+}
+
+// IconSource yields a candidate image for a token, the candidate is nil when the source has nothing for it.
+type IconSource interface {
+	Name() string
+	Fetch(ctx context.Context, token model.Token) (*IconCandidate, error)
+}
+
+// iconBytesUploader is implemented by upload repositories that store content directly, generated icons are only
+// stored through one.
+type iconBytesUploader interface {
+	CreateFileFromBytes(ctx context.Context, id, bucket string, data []byte, contentType string) (string, businesserror.XSpaceBusinessError)
+}
+
+type tokenIconResolver struct {
+	sources       []IconSource
+	uploadService repository.UploadRepository
+}
+
+// NeedsResolve tells whether the token has no stored icon yet, or only a generated one that is due for a retry.
+func (r *tokenIconResolver) NeedsResolve(token model.Token) bool {
+	if token.IconFileURL == "" || strings.HasSuffix(token.IconFileURL, "default-token.png") {
+		return true
+	}
+
+	return token.IconSource == IconSourceIdenticon &&
+		(token.IconCheckedAt == nil || time.Since(*token.IconCheckedAt) > iconRetryInterval)
+}
+
+// Resolve tries the sources in order and uploads the first image that passes validation.
+// The stored url is always our upload, a failed upload leaves the token untouched for the next sync.
+func (r *tokenIconResolver) Resolve(ctx context.Context, token *model.Token) businesserror.XSpaceBusinessError {
+	for _, source := range r.sources {
+		candidate, basicErr := source.Fetch(ctx, *token)
+		if basicErr != nil {
+			logger.GetLoggerEntry(ctx).
+				WithField("token_id", token.ID).
+				WithField("source", source.Name()).
+				Errorf("error fetching token icon, %v", basicErr)
+			continue
+		}
+		if candidate == nil {
+			continue
+		}
+
+		contentType, basicErr := validateIcon(candidate.Data)
+		if basicErr != nil {
+			logger.GetLoggerEntry(ctx).
+				WithField("token_id", token.ID).
+				WithField("source", source.Name()).
+				Errorf("rejected token icon, %v", basicErr)
+			continue
+		}
+
+		url, uploaded, err := r.upload(ctx, token, candidate, contentType)
+		if err != nil {
+			return err
+		}
+		if !uploaded {
+			continue
+		}
+
+		now := time.Now()
+		token.IconFileURL = url
+		token.IconSource = source.Name()
+		token.IconCheckedAt = &now
+		return nil
+	}
+
+	return common.NewRuntimeError(fmt.Errorf("no icon source succeeded for token %s", token.ID))
+}
+
+// upload stores a fetched icon from its validated url and a generated one as bytes, uploaded is false when the
+// upload repository cannot store the candidate.
+func (r *tokenIconResolver) upload(ctx context.Context, token *model.Token, candidate *IconCandidate, contentType string) (string, bool, businesserror.XSpaceBusinessError) {
+	if len(candidate.URL) > 0 {
+		url, err := r.uploadService.CreateFileFromURL(ctx, token.ID, iconBucket, candidate.URL)
+		return url, err == nil, err
+	}
+
+	uploader, ok := r.uploadService.(iconBytesUploader)
+	if !ok {
+		return "", false, nil
+	}
+	url, err := uploader.CreateFileFromBytes(ctx, token.ID, iconBucket, candidate.Data, contentType)
+	return url, err == nil, err
+}
+
+// validateIcon sniffs the content and checks the size limits, it returns the detected content type.
+func validateIcon(data []byte) (string, error) {
+	if len(data) == 0 || len(data) > iconMaxBytes {
+		return "", fmt.Errorf("icon size %d out of bounds", len(data))
+	}
+
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case "image/png", "image/jpeg", "image/gif":
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return "", err
+		}
+		if config.Width < iconMinDimension || config.Height < iconMinDimension ||
+			config.Width > iconMaxDimension || config.Height > iconMaxDimension {
+			return "", fmt.Errorf("icon dimension %dx%d out of bounds", config.Width, config.Height)
+		}
+	case "image/webp":
+		// the standard library cannot decode webp, the byte limit is all we check
+	default:
+		return "", fmt.Errorf("unexpected icon content type %s", contentType)
+	}
+
+	return contentType, nil
+}
+
+// iconHttpClient only connects to public addresses, icon and token list urls come from token lists and admins.
+var iconHttpClient = &http.Client{
+	Timeout: iconFetchTimeout,
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: iconFetchTimeout,
+			Control: dialPublicOnly,
+		}).DialContext,
+		TLSHandshakeTimeout: iconFetchTimeout,
+	},
+	CheckRedirect: func(request *http.Request, via []*http.Request) error {
+		if len(via) >= 3 {
+			return errors.New("too many redirects")
+		}
+		return checkIconURL(request.URL)
+	},
+}
+
+// dialPublicOnly refuses connections to loopback, private, link local and other non public addresses. It runs
+// on the resolved address, so a public host name pointing inside the network is refused as well.
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() ||
+		ip.IsUnspecified() || ip.IsMulticast() {
+		return fmt.Errorf("refusing to fetch icon from %s", host)
+	}
+	return nil
+}
+
+// checkIconURL only accepts https urls without credentials on the default port.
+func checkIconURL(url *neturl.URL) error {
+	if url.Scheme != "https" {
+		return fmt.Errorf("icon url scheme %q is not https", url.Scheme)
+	}
+	if url.User != nil || url.Hostname() == "" || (url.Port() != "" && url.Port() != "443") {
+		return fmt.Errorf("icon url host %q is not allowed", url.Host)
+	}
+	return nil
+}
+
+// fetchIcon downloads url, a 404 is reported as not found rather than an error.
+func fetchIcon(ctx context.Context, url string) (*IconCandidate, error) {
+	parsed, err := neturl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkIconURL(parsed); err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := iconHttpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("icon request returned %d", response.StatusCode)
+	}
+
+	// one byte over the limit is enough for validateIcon to reject it
+	data, err := io.ReadAll(io.LimitReader(response.Body, iconMaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+
+	return &IconCandidate{Data: data, URL: url}, nil
+}
+
+// overrideIconSource serves the icon an admin set on the token.
+type overrideIconSource struct{}
+
+func (s *overrideIconSource) Name() string {
+	return "override"
+}
+
+func (s *overrideIconSource) Fetch(ctx context.Context, token model.Token) (*IconCandidate, error) {
+	if token.IconOverrideURL == "" {
+		return nil, nil
+	}
+	return fetchIcon(ctx, token.IconOverrideURL)
+}
+
+var trustWalletChainNames = map[string]string{
+	"1":    "ethereum",
+	"56":   "smartchain",
+	"8453": "base",
+}
+
+// trustWalletIconSource reads logo.png from a mirror of the trustwallet/assets repository.
+type trustWalletIconSource struct {
+	baseURL string
+}
+
+func (s *trustWalletIconSource) Name() string {
+	return "trustwallet"
+}
+
+func (s *trustWalletIconSource) Fetch(ctx context.Context, token model.Token) (*IconCandidate, error) {
+	chainName, ok := trustWalletChainNames[token.ChainID]
+	if !ok {
+		return nil, nil
+	}
+
+	// the repository is keyed by checksummed address
+	address := common2.HexToAddress(token.ContractAddress).Hex()
+	return fetchIcon(ctx, fmt.Sprintf("%s/blockchains/%s/assets/%s/logo.png", s.baseURL, chainName, address))
+}
+
+// tokenListIconSource looks the token up in uniswap style token lists and fetches its logoURI.
+type tokenListIconSource struct {
+	listURLs []string
+
+	mu        sync.Mutex
+	logos     map[string]string
+	loadedAt  time.Time
+	reloading bool
+}
+
+type tokenList struct {
+	Tokens []struct {
+		ChainID int    `json:"chainId"`
+		Address string `json:"address"`
+		LogoURI string `json:"logoURI"`
+	} `json:"tokens"`
+}
+
+func (s *tokenListIconSource) Name() string {
+	return "tokenlist"
+}
+
+func (s *tokenListIconSource) Fetch(ctx context.Context, token model.Token) (*IconCandidate, error) {
+	logo, ok := s.lookup(ctx, token)
+	if !ok {
+		return nil, nil
+	}
+
+	// ipfs logos go through a public gateway
+	if strings.HasPrefix(logo, "ipfs://") {
+		logo = "https://ipfs.io/ipfs/" + strings.TrimPrefix(logo, "ipfs://")
+	}
+	return fetchIcon(ctx, logo)
+}
+
+// lookup answers from the loaded lists, the caller that finds them stale reloads them without holding the lock
+// while the others keep reading the previous lists.
+func (s *tokenListIconSource) lookup(ctx context.Context, token model.Token) (string, bool) {
+	s.mu.Lock()
+	stale := !s.reloading && (s.logos == nil || time.Since(s.loadedAt) > tokenListTTL)
+	if stale {
+		s.reloading = true
+	}
+	previous := s.logos
+	s.mu.Unlock()
+
+	if stale {
+		logos := s.reload(ctx, previous)
+
+		s.mu.Lock()
+		s.logos = logos
+		s.loadedAt = time.Now()
+		s.reloading = false
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	logo, ok := s.logos[tokenListKey(token.ChainID, token.ContractAddress)]
+	return logo, ok
+}
+
+// reload keeps the previous lists of a url that fails to load.
+func (s *tokenListIconSource) reload(ctx context.Context, previous map[string]string) map[string]string {
+	logos := map[string]string{}
+	for key, logo := range previous {
+		logos[key] = logo
+	}
+
+	for _, url := range s.listURLs {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			continue
+		}
+
+		response, err := iconHttpClient.Do(request)
+		if err != nil {
+			logger.GetLoggerEntry(ctx).WithField("url", url).Errorf("error loading token list, %v", err)
+			continue
+		}
+
+		var list tokenList
+		err = json.NewDecoder(response.Body).Decode(&list)
+		response.Body.Close()
+		if err != nil {
+			logger.GetLoggerEntry(ctx).WithField("url", url).Errorf("error decoding token list, %v", err)
+			continue
+		}
+
+		for _, entry := range list.Tokens {
+			if entry.LogoURI != "" {
+				logos[tokenListKey(fmt.Sprint(entry.ChainID), entry.Address)] = entry.LogoURI
+			}
+		}
+	}
+
+	return logos
+}
+
+func tokenListKey(chainID, address string) string {
+	return chainID + ":" + strings.ToLower(address)
+}
+
+type dexScreenerIconSource struct{}
+
+func (s *dexScreenerIconSource) Name() string {
+	return "dexscreener"
+}
+
+func (s *dexScreenerIconSource) Fetch(ctx context.Context, token model.Token) (*IconCandidate, error) {
+	return fetchIcon(ctx, fmt.Sprintf("https://dd.dexscreener.com/ds-data/tokens/%s/%s.png",
+		model.GetChainNameByID(token.ChainID), token.ContractAddress))
+}
+
+const (
+	identiconGrid     = 5
+	identiconCellSize = 32
+)
+
+// identiconSource draws a mirrored 5x5 pattern from the address hash, it never fails and goes last.
+type identiconSource struct{}
+
+func (s *identiconSource) Name() string {
+	return IconSourceIdenticon
+}
+
+func (s *identiconSource) Fetch(ctx context.Context, token model.Token) (*IconCandidate, error) {
+	hash := sha256.Sum256([]byte(tokenListKey(token.ChainID, token.ContractAddress)))
+	foreground := color.RGBA{R: hash[0], G: hash[1], B: hash[2], A: 0xff}
+	background := color.RGBA{R: 0xf0, G: 0xf0, B: 0xf0, A: 0xff}
+
+	size := identiconGrid * identiconCellSize
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for row := 0; row < identiconGrid; row++ {
+		for col := 0; col < (identiconGrid+1)/2; col++ {
+			fill := background
+			if hash[3+row*identiconGrid+col]%2 == 0 {
+				fill = foreground
+			}
+
+			for _, x := range []int{col, identiconGrid - 1 - col} {
+				for dy := 0; dy < identiconCellSize; dy++ {
+					for dx := 0; dx < identiconCellSize; dx++ {
+						img.Set(x*identiconCellSize+dx, row*identiconCellSize+dy, fill)
+					}
+				}
+			}
+		}
+	}
+
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, img); err != nil {
+		return nil, err
+	}
+	return &IconCandidate{Data: buffer.Bytes()}, nil
+}
+
+// trustWalletAssetsURL points at the upstream repository unless TRUSTWALLET_ASSETS_URL names a mirror.
+func trustWalletAssetsURL() string {
+	if url := os.Getenv("TRUSTWALLET_ASSETS_URL"); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	return "https://raw.githubusercontent.com/trustwallet/assets/master"
+}
+
+var defaultTokenLists = []string{
+	"https://tokens.pancakeswap.finance/pancakeswap-extended.json",
+	"https://tokens.uniswap.org",
+}
+
+func defaultIconSources() []IconSource {
+	return []IconSource{
+		&overrideIconSource{},
+		&trustWalletIconSource{baseURL: trustWalletAssetsURL()},
+		&tokenListIconSource{listURLs: defaultTokenLists},
+		&dexScreenerIconSource{},
+		&identiconSource{},
+	}
+}
+
+func newTokenIconResolver(uploadService repository.UploadRepository, sources ...IconSource) *tokenIconResolver {
+	if len(sources) == 0 {
+		sources = defaultIconSources()
+	}
+
+	return &tokenIconResolver{
+		sources:       sources,
+		uploadService: uploadService,
+	}
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/kaboom-service/common"
+	"github.com/cross-space-official/kaboom-service/model"
+	"github.com/cross-space-official/kaboom-service/service/provider/evm"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	common2 "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+	"math/big"
+	"strings"
+	"unicode"
+)
+
+const (
+	maxTokenNameLength   = 64
+	maxTokenSymbolLength = 32
+	// erc20 decimals above this cannot describe a uint256 supply
+	maxTokenDecimals = 77
+
+	// maxMetadataAttempts is how many syncs with a contract level failure it takes to mark the token broken.
+	maxMetadataAttempts = 5
+)
+
+// token metadata statuses, stored in model.Token.MetadataStatus
+const (
+	TokenMetadataStatusPending  = ""
+	TokenMetadataStatusComplete = "complete"
+	TokenMetadataStatusBroken   = "broken"
+)
+
+// errMetadataContract is a failure of the contract itself, a revert or an undecodable answer, as opposed to the rpc.
+var errMetadataContract = errors.New("token contract answered no metadata")
+
+var (
+	nameSelector     = common2.FromHex("0x06fdde03")
+	symbolSelector   = common2.FromHex("0x95d89b41")
+	decimalsSelector = common2.FromHex("0x313ce567")
+
+	stringArguments = abi.Arguments{{Type: mustAbiType("string")}}
+)
+
+type tokenMetadata struct {
+	Name     string
+	Symbol   string
+	Decimals *uint8
+}
+
+type tokenMetadataReader struct {
+	gethService evm.GethService
+}
+
+// NeedsRead tells whether the token is missing metadata and is not marked broken.
+func (r *tokenMetadataReader) NeedsRead(token model.Token) bool {
+	return token.MetadataStatus != TokenMetadataStatusComplete && token.MetadataStatus != TokenMetadataStatusBroken
+}
+
+// Sync reads name, symbol and decimals and fills what the contract answers.
+// Rpc failures are retried on the next sync for free, contract failures count towards maxMetadataAttempts.
+func (r *tokenMetadataReader) Sync(ctx context.Context, token *model.Token) businesserror.XSpaceBusinessError {
+	metadata, basicErr := r.Read(ctx, token.ChainID, token.ContractAddress)
+	if basicErr != nil && !errors.Is(basicErr, errMetadataContract) {
+		return common.NewRuntimeError(basicErr)
+	}
+
+	if metadata.Name != "" {
+		token.Name = metadata.Name
+	}
+	if metadata.Symbol != "" {
+		token.Symbol = metadata.Symbol
+	}
+	if metadata.Decimals != nil {
+		token.Decimals = *metadata.Decimals
+		token.DecimalsKnown = true
+	}
+
+	if token.DecimalsKnown && token.Symbol != "" {
+		token.MetadataStatus = TokenMetadataStatusComplete
+		return nil
+	}
+
+	token.MetadataAttempts++
+	if token.MetadataAttempts >= maxMetadataAttempts {
+		token.MetadataStatus = TokenMetadataStatusBroken
+	}
+	return nil
+}
+
+// Read returns whatever fields could be read, the error wraps errMetadataContract when only the contract failed.
+func (r *tokenMetadataReader) Read(ctx context.Context, chainID, contractAddress string) (*tokenMetadata, error) {
+	client, err := r.gethService.GetClient(chainID)
+	if err != nil {
+		return &tokenMetadata{}, err
+	}
+
+	address := common2.HexToAddress(contractAddress)
+	call := func(selector []byte) ([]byte, error) {
+		output, basicErr := client.CallContract(ctx, ethereum.CallMsg{To: &address, Data: selector}, nil)
+		if basicErr != nil {
+			var dataErr rpc.DataError
+			if errors.As(basicErr, &dataErr) || strings.Contains(basicErr.Error(), "execution reverted") {
+				return nil, fmt.Errorf("%w: %v", errMetadataContract, basicErr)
+			}
+			return nil, basicErr
+		}
+		return output, nil
+	}
+
+	metadata := &tokenMetadata{}
+	var firstErr error
+	keep := func(basicErr error) {
+		// an rpc failure outranks a contract failure so the attempt is not counted
+		if firstErr == nil || (errors.Is(firstErr, errMetadataContract) && !errors.Is(basicErr, errMetadataContract)) {
+			firstErr = basicErr
+		}
+	}
+
+	if output, basicErr := call(nameSelector); basicErr != nil {
+		keep(basicErr)
+	} else if metadata.Name, basicErr = decodeTokenText(output, maxTokenNameLength); basicErr != nil {
+		keep(basicErr)
+	}
+
+	if output, basicErr := call(symbolSelector); basicErr != nil {
+		keep(basicErr)
+	} else if metadata.Symbol, basicErr = decodeTokenText(output, maxTokenSymbolLength); basicErr != nil {
+		keep(basicErr)
+	}
+
+	if output, basicErr := call(decimalsSelector); basicErr != nil {
+		keep(basicErr)
+	} else if metadata.Decimals, basicErr = decodeDecimals(output); basicErr != nil {
+		keep(basicErr)
+	}
+
+	return metadata, firstErr
+}
+
+// decodeTokenText accepts an abi encoded string as well as the bytes32 used by MKR-era tokens.
+func decodeTokenText(output []byte, maxLength int) (string, error) {
+	var raw string
+	switch {
+	case len(output) >= 64 && new(big.Int).SetBytes(output[:32]).Cmp(big.NewInt(32)) == 0:
+		values, err := stringArguments.Unpack(output)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", errMetadataContract, err)
+		}
+		raw = values[0].(string)
+	case len(output) == 32:
+		raw = string(bytes.TrimRight(output, "\x00"))
+	default:
+		return "", fmt.Errorf("%w: unexpected text of %d bytes", errMetadataContract, len(output))
+	}
+
+	return sanitizeTokenText(raw, maxLength), nil
+}
+
+// sanitizeTokenText drops invalid utf-8 and control characters and caps the rune count.
+func sanitizeTokenText(raw string, maxLength int) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == unicode.ReplacementChar {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(raw, ""))
+	cleaned = strings.TrimSpace(cleaned)
+
+	if runes := []rune(cleaned); len(runes) > maxLength {
+		cleaned = string(runes[:maxLength])
+	}
+	return cleaned
+}
+
+// decodeDecimals returns a pointer so a real 0 is told apart from a missing answer.
+func decodeDecimals(output []byte) (*uint8, error) {
+	if len(output) < 32 {
+		return nil, fmt.Errorf("%w: unexpected decimals of %d bytes", errMetadataContract, len(output))
+	}
+
+	value := new(big.Int).SetBytes(output[:32])
+	if value.Cmp(big.NewInt(maxTokenDecimals)) > 0 {
+		return nil, fmt.Errorf("%w: decimals %s out of range", errMetadataContract, value.String())
+	}
+
+	decimals := uint8(value.Uint64())
+	return &decimals, nil
+}
+
+func mustAbiType(name string) abi.Type {
+	t, err := abi.NewType(name, "", nil)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func newTokenMetadataReader(gethService evm.GethService) *tokenMetadataReader {
+	return &tokenMetadataReader{
+		gethService: gethService,
+	}
+}
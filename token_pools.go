@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/common/logger"
+	"github.com/cross-space-official/kaboom-service/model"
+	"github.com/cross-space-official/kaboom-service/repository"
+	"math/big"
+	"sort"
+)
+
+// TokenPool is one pool of a token with its quote side liquidity in native wei.
+type TokenPool struct {
+	Pair              *model.DexPair
+	LiquidityInNative *big.Int
+}
+
+// isTradablePool tells whether trades may be routed to the pool. A pool that is not published was rejected, is still
+// in review or was never screened.
+func isTradablePool(pair *model.DexPair) bool {
+	return pair.IsPublished && !pair.IsRugged && len(pair.MigratedPairID) == 0 && isSupportedPairType(pair.Type)
+}
+
+// pairLiquidityInNative is the quote side reserve converted to native wei.
+func pairLiquidityInNative(c context.Context, quoteTokens *quoteTokenService, pair *model.DexPair) (*big.Int, businesserror.XSpaceBusinessError) {
+	liquidity := pair.GetWNativeReserve()
+	if isNativeQuoted(pair) {
+		return liquidity, nil
+	}
+
+	return quoteTokens.QuoteToNative(c, pair.ChainID, getQuoteToken(pair).ContractAddress, liquidity)
+}
+
+// rankTokenPools orders the tradable pools of a token deepest first, from their stored reserves.
+// A pool whose quote side cannot be valued ranks last with no liquidity.
+func rankTokenPools(c context.Context, quoteTokens *quoteTokenService, pairs []*model.DexPair) []*TokenPool {
+	pools := make([]*TokenPool, 0, len(pairs))
+	for _, pair := range pairs {
+		if !isTradablePool(pair) {
+			continue
+		}
+
+		liquidity, err := pairLiquidityInNative(c, quoteTokens, pair)
+		if err != nil {
+			logger.GetLoggerEntry(c).
+				WithField("pair_id", pair.ID).
+				Errorf("error converting liquidity to native, %v", err)
+			liquidity = big.NewInt(0)
+		}
+		pools = append(pools, &TokenPool{Pair: pair, LiquidityInNative: liquidity})
+	}
+
+	sort.SliceStable(pools, func(i, j int) bool {
+		return pools[i].LiquidityInNative.Cmp(pools[j].LiquidityInNative) > 0
+	})
+	return pools
+}
+
+// GetTokenPools ranks every tradable pool known for the token, deepest first, the first one is its primary pool.
+func (d *dexEvmPairService) GetTokenPools(ctx context.Context, tokenID string) ([]*TokenPool, businesserror.XSpaceBusinessError) {
+	pairs, err := d.assetRepository.RetrievePairsByTokenID(ctx, tokenID)
+	if err != nil {
+		return nil, err
+	}
+
+	return rankTokenPools(ctx, d.quoteTokens, pairs), nil
+}
+
+func (d *dexEvmPairService) updatePrimaryPool(c context.Context, synced *model.DexPair) {
+	updatePrimaryPool(c, d.assetRepository, d.quoteTokens, synced)
+}
+
+// updatePrimaryPool moves the primary flag of the token to its deepest tradable pool, synced is the pair just persisted.
+// Only the flag is written so concurrent syncs of the other pools are not overwritten.
+func updatePrimaryPool(c context.Context, assetRepository repository.AssetRepository, quoteTokens *quoteTokenService, synced *model.DexPair) {
+	token := synced.GetToken()
+	pairs, err := assetRepository.RetrievePairsByTokenID(c, token.ID)
+	if err != nil {
+		logger.GetLoggerEntry(c).
+			WithField("token_id", token.ID).
+			Errorf("error retrieving token pools, %v", err)
+		return
+	}
+
+	for i := range pairs {
+		if pairs[i].ID == synced.ID {
+			pairs[i] = synced
+		}
+	}
+
+	primaryID := ""
+	if pools := rankTokenPools(c, quoteTokens, pairs); len(pools) > 0 {
+		primaryID = pools[0].Pair.ID
+	}
+
+	changed := false
+	for _, pair := range pairs {
+		if pair.IsPrimary != (pair.ID == primaryID) {
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+
+	err = assetRepository.UpdatePrimaryPair(c, token.ID, primaryID)
+	if err != nil {
+		logger.GetLoggerEntry(c).
+			WithField("token_id", token.ID).
+			Errorf("error updating primary pool, %v", err)
+		return
+	}
+	synced.IsPrimary = synced.ID == primaryID
+}
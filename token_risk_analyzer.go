@@ -0,0 +1,375 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/common/logger"
+	"github.com/cross-space-official/kaboom-service/common"
+	"github.com/cross-space-official/kaboom-service/model"
+	"github.com/cross-space-official/kaboom-service/service/provider/evm"
+	"github.com/ethereum/go-ethereum"
+	common2 "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"sort"
+	"sync"
+	"time"
+)
+
+type TokenRiskCategory string
+
+const (
+	TokenRiskCategoryMint        TokenRiskCategory = "mint"
+	TokenRiskCategoryBlacklist   TokenRiskCategory = "blacklist"
+	TokenRiskCategoryPause       TokenRiskCategory = "pause"
+	TokenRiskCategoryTrading     TokenRiskCategory = "trading_switch"
+	TokenRiskCategoryFee         TokenRiskCategory = "adjustable_fee"
+	TokenRiskCategoryLimit       TokenRiskCategory = "adjustable_limit"
+	TokenRiskCategoryUpgrade     TokenRiskCategory = "upgradeable"
+	TokenRiskCategoryBalanceEdit TokenRiskCategory = "balance_edit"
+)
+
+type TokenRiskSeverity int
+
+const (
+	TokenRiskSeverityLow    TokenRiskSeverity = 1
+	TokenRiskSeverityMedium TokenRiskSeverity = 3
+	TokenRiskSeverityHigh   TokenRiskSeverity = 5
+)
+
+const (
+	ProxyTypeEIP1967  = "eip1967"
+	ProxyTypeBeacon   = "eip1967_beacon"
+	ProxyTypeEIP1822  = "eip1822"
+	ProxyTypeZeppelin = "zeppelinos"
+	ProxyTypeEIP1167  = "eip1167"
+
+	opPush1        = 0x60
+	opPush4        = 0x63
+	opPush32       = 0x7f
+	opDelegateCall = 0xf4
+
+	// clones of the same token template share their bytecode, the scan cache is dropped when it grows past this
+	maxCodeScans = 4096
+)
+
+type riskSignature struct {
+	Signature string
+	Category  TokenRiskCategory
+	Severity  TokenRiskSeverity
+}
+
+// riskSignatures is the catalogue of functions that let a privileged account change how the token trades.
+var riskSignatures = []riskSignature{
+	{"mint(address,uint256)", TokenRiskCategoryMint, TokenRiskSeverityHigh},
+	{"mint(uint256)", TokenRiskCategoryMint, TokenRiskSeverityHigh},
+	{"blacklist(address)", TokenRiskCategoryBlacklist, TokenRiskSeverityHigh},
+	{"addToBlacklist(address)", TokenRiskCategoryBlacklist, TokenRiskSeverityHigh},
+	{"setBlacklist(address,bool)", TokenRiskCategoryBlacklist, TokenRiskSeverityHigh},
+	{"blacklistAddress(address,bool)", TokenRiskCategoryBlacklist, TokenRiskSeverityHigh},
+	{"addBots(address[])", TokenRiskCategoryBlacklist, TokenRiskSeverityHigh},
+	{"setBots(address[])", TokenRiskCategoryBlacklist, TokenRiskSeverityHigh},
+	{"setBot(address,bool)", TokenRiskCategoryBlacklist, TokenRiskSeverityHigh},
+	{"pause()", TokenRiskCategoryPause, TokenRiskSeverityMedium},
+	{"unpause()", TokenRiskCategoryPause, TokenRiskSeverityMedium},
+	{"enableTrading()", TokenRiskCategoryTrading, TokenRiskSeverityMedium},
+	{"openTrading()", TokenRiskCategoryTrading, TokenRiskSeverityMedium},
+	{"setTradingEnabled(bool)", TokenRiskCategoryTrading, TokenRiskSeverityHigh},
+	{"setTrading(bool)", TokenRiskCategoryTrading, TokenRiskSeverityHigh},
+	{"setFee(uint256)", TokenRiskCategoryFee, TokenRiskSeverityMedium},
+	{"setTaxFee(uint256)", TokenRiskCategoryFee, TokenRiskSeverityMedium},
+	{"setFees(uint256,uint256)", TokenRiskCategoryFee, TokenRiskSeverityMedium},
+	{"setBuyFee(uint256)", TokenRiskCategoryFee, TokenRiskSeverityMedium},
+	{"setSellFee(uint256)", TokenRiskCategoryFee, TokenRiskSeverityMedium},
+	{"setTaxes(uint256,uint256)", TokenRiskCategoryFee, TokenRiskSeverityMedium},
+	{"updateFees(uint256,uint256)", TokenRiskCategoryFee, TokenRiskSeverityMedium},
+	{"setMaxTxAmount(uint256)", TokenRiskCategoryLimit, TokenRiskSeverityLow},
+	{"setMaxWalletSize(uint256)", TokenRiskCategoryLimit, TokenRiskSeverityLow},
+	{"setMaxWallet(uint256)", TokenRiskCategoryLimit, TokenRiskSeverityLow},
+	{"upgradeTo(address)", TokenRiskCategoryUpgrade, TokenRiskSeverityHigh},
+	{"upgradeToAndCall(address,bytes)", TokenRiskCategoryUpgrade, TokenRiskSeverityHigh},
+	{"setBalance(address,uint256)", TokenRiskCategoryBalanceEdit, TokenRiskSeverityHigh},
+}
+
+// privilegedRoleGetters are zero argument views returning an address that controls the token.
+var privilegedRoleGetters = []string{"owner()", "getOwner()", "admin()", "operator()", "minter()"}
+
+var (
+	eip1967ImplementationSlot  = common2.HexToHash("0x360894a13ba1a3210667c828492db98dca3e2076cc3735a920a3ca505d382bbc")
+	eip1967BeaconSlot          = common2.HexToHash("0xa3f0ad74e5423aebfd80d3ef4346578335a9a72aeaee59ff6cb3582b35133d50")
+	eip1967AdminSlot           = common2.HexToHash("0xb53127684a568b3173ae13b9f8a6016e243e63b6e8ee1178d6a717850b5d6103")
+	eip1822ProxiableSlot       = common2.HexToHash("0xc5f16f0fcc639fa48a6947836d9850f504798523bf8c9a3a87d5876cf622bcf7")
+	zeppelinImplementationSlot = crypto.Keccak256Hash([]byte("org.zeppelinos.proxy.implementation"))
+
+	eip1167Prefix = common2.FromHex("0x363d3d373d3d3d363d73")
+	eip1167Suffix = common2.FromHex("0x5af43d82803e903d91602b57fd5bf3")
+)
+
+type TokenRiskFlag struct {
+	Function string            `json:"function"`
+	Selector string            `json:"selector"`
+	Category TokenRiskCategory `json:"category"`
+	Severity TokenRiskSeverity `json:"severity"`
+}
+
+type TokenPrivilegedRole struct {
+	Role    string `json:"role"`
+	Address string `json:"address"`
+}
+
+type TokenRiskReport struct {
+	IsProxy         bool                  `json:"isProxy"`
+	ProxyType       string                `json:"proxyType,omitempty"`
+	Implementation  string                `json:"implementation,omitempty"`
+	Flags           []TokenRiskFlag       `json:"flags"`
+	PrivilegedRoles []TokenPrivilegedRole `json:"privilegedRoles"`
+	// Score sums the severity of the flags, privileged flags only count while a privileged role is held.
+	Score      int       `json:"score"`
+	AnalyzedAt time.Time `json:"analyzedAt"`
+}
+
+func (r *TokenRiskReport) HasCategory(category TokenRiskCategory) bool {
+	for _, flag := range r.Flags {
+		if flag.Category == category {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *TokenRiskReport) applyTo(token *model.Token) businesserror.XSpaceBusinessError {
+	report, basicErr := json.Marshal(r)
+	if basicErr != nil {
+		return common.NewRuntimeError(basicErr)
+	}
+
+	token.RiskReport = report
+	token.RiskScore = r.Score
+	token.IsProxy = r.IsProxy
+	token.RiskAnalyzedAt = &r.AnalyzedAt
+	return nil
+}
+
+// codeScan is what the bytecode alone tells, it is cached per code hash.
+type codeScan struct {
+	selectors map[[4]byte]struct{}
+	// delegates is false when the code has no DELEGATECALL, such a contract cannot be a proxy
+	delegates bool
+}
+
+type tokenRiskAnalyzer struct {
+	gethService evm.GethService
+	catalogue   map[[4]byte]riskSignature
+
+	mu    sync.Mutex
+	scans map[common2.Hash]*codeScan
+}
+
+// Analyze reads the token bytecode, follows a proxy to its implementation and matches the dispatched selectors against the catalogue.
+func (a *tokenRiskAnalyzer) Analyze(ctx context.Context, token model.Token) (*TokenRiskReport, businesserror.XSpaceBusinessError) {
+	client, err := a.gethService.GetClient(token.ChainID)
+	if err != nil {
+		return nil, err
+	}
+
+	address := common2.HexToAddress(token.ContractAddress)
+	code, basicErr := client.CodeAt(ctx, address, nil)
+	if basicErr != nil {
+		return nil, common.NewRuntimeError(basicErr)
+	}
+
+	report := &TokenRiskReport{
+		Flags:           []TokenRiskFlag{},
+		PrivilegedRoles: []TokenPrivilegedRole{},
+		AnalyzedAt:      time.Now(),
+	}
+
+	scan := a.scanCode(code)
+	selectors := make(map[[4]byte]struct{}, len(scan.selectors))
+	for selector := range scan.selectors {
+		selectors[selector] = struct{}{}
+	}
+
+	var proxyType string
+	var implementation, admin common2.Address
+	if scan.delegates {
+		proxyType, implementation, admin = a.detectProxy(ctx, client, address, code)
+	}
+	if len(proxyType) > 0 {
+		report.IsProxy = true
+		report.ProxyType = proxyType
+		report.Flags = append(report.Flags, TokenRiskFlag{
+			Function: "proxy",
+			Category: TokenRiskCategoryUpgrade,
+			Severity: TokenRiskSeverityHigh,
+		})
+
+		if admin != (common2.Address{}) {
+			report.PrivilegedRoles = append(report.PrivilegedRoles, TokenPrivilegedRole{Role: "proxyAdmin", Address: admin.Hex()})
+		}
+
+		if implementation != (common2.Address{}) {
+			report.Implementation = implementation.Hex()
+			implementationCode, basicErr := client.CodeAt(ctx, implementation, nil)
+			if basicErr != nil {
+				return nil, common.NewRuntimeError(basicErr)
+			}
+			for selector := range a.scanCode(implementationCode).selectors {
+				selectors[selector] = struct{}{}
+			}
+		}
+	}
+
+	for selector := range selectors {
+		signature, ok := a.catalogue[selector]
+		if !ok {
+			continue
+		}
+		report.Flags = append(report.Flags, TokenRiskFlag{
+			Function: signature.Signature,
+			Selector: common2.Bytes2Hex(selector[:]),
+			Category: signature.Category,
+			Severity: signature.Severity,
+		})
+	}
+	sort.Slice(report.Flags, func(i, j int) bool {
+		return report.Flags[i].Function < report.Flags[j].Function
+	})
+
+	report.PrivilegedRoles = append(report.PrivilegedRoles, a.readPrivilegedRoles(ctx, client, address, selectors)...)
+
+	// functions guarded by a renounced owner can no longer be called, the proxy admin still can upgrade
+	for _, flag := range report.Flags {
+		if len(report.PrivilegedRoles) > 0 || flag.Category == TokenRiskCategoryUpgrade {
+			report.Score += int(flag.Severity)
+		}
+	}
+
+	return report, nil
+}
+
+func (a *tokenRiskAnalyzer) detectProxy(ctx context.Context, client *ethclient.Client, address common2.Address, code []byte) (string, common2.Address, common2.Address) {
+	if len(code) == len(eip1167Prefix)+common2.AddressLength+len(eip1167Suffix) &&
+		bytes.HasPrefix(code, eip1167Prefix) && bytes.HasSuffix(code, eip1167Suffix) {
+		return ProxyTypeEIP1167, common2.BytesToAddress(code[len(eip1167Prefix) : len(eip1167Prefix)+common2.AddressLength]), common2.Address{}
+	}
+
+	admin := a.readAddressSlot(ctx, client, address, eip1967AdminSlot)
+
+	if implementation := a.readAddressSlot(ctx, client, address, eip1967ImplementationSlot); implementation != (common2.Address{}) {
+		return ProxyTypeEIP1967, implementation, admin
+	}
+
+	if beacon := a.readAddressSlot(ctx, client, address, eip1967BeaconSlot); beacon != (common2.Address{}) {
+		return ProxyTypeBeacon, a.callAddress(ctx, client, beacon, "implementation()"), admin
+	}
+
+	if implementation := a.readAddressSlot(ctx, client, address, eip1822ProxiableSlot); implementation != (common2.Address{}) {
+		return ProxyTypeEIP1822, implementation, admin
+	}
+
+	if implementation := a.readAddressSlot(ctx, client, address, zeppelinImplementationSlot); implementation != (common2.Address{}) {
+		return ProxyTypeZeppelin, implementation, admin
+	}
+
+	return "", common2.Address{}, common2.Address{}
+}
+
+func (a *tokenRiskAnalyzer) readPrivilegedRoles(ctx context.Context, client *ethclient.Client, address common2.Address, selectors map[[4]byte]struct{}) []TokenPrivilegedRole {
+	roles := []TokenPrivilegedRole{}
+	for _, getter := range privilegedRoleGetters {
+		if _, ok := selectors[selectorOf(getter)]; !ok {
+			continue
+		}
+
+		holder := a.callAddress(ctx, client, address, getter)
+		if holder == (common2.Address{}) {
+			continue
+		}
+		roles = append(roles, TokenPrivilegedRole{Role: getter[:len(getter)-2], Address: holder.Hex()})
+	}
+	return roles
+}
+
+func (a *tokenRiskAnalyzer) readAddressSlot(ctx context.Context, client *ethclient.Client, address common2.Address, slot common2.Hash) common2.Address {
+	value, err := client.StorageAt(ctx, address, slot, nil)
+	if err != nil {
+		logger.GetLoggerEntry(ctx).
+			WithField("contract_address", address.Hex()).
+			Errorf("error reading storage slot, %v", err)
+		return common2.Address{}
+	}
+	return common2.BytesToAddress(value)
+}
+
+func (a *tokenRiskAnalyzer) callAddress(ctx context.Context, client *ethclient.Client, address common2.Address, signature string) common2.Address {
+	selector := selectorOf(signature)
+	output, err := client.CallContract(ctx, ethereum.CallMsg{To: &address, Data: selector[:]}, nil)
+	if err != nil || len(output) < 32 {
+		return common2.Address{}
+	}
+	return common2.BytesToAddress(output[:32])
+}
+
+func (a *tokenRiskAnalyzer) scanCode(code []byte) *codeScan {
+	codeHash := crypto.Keccak256Hash(code)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if scan, ok := a.scans[codeHash]; ok {
+		return scan
+	}
+
+	if len(a.scans) >= maxCodeScans {
+		a.scans = map[common2.Hash]*codeScan{}
+	}
+	scan := scanBytecode(code)
+	a.scans[codeHash] = scan
+	return scan
+}
+
+// scanBytecode collects the PUSH4 operands of the bytecode, skipping the data of other push opcodes.
+// Solidity and Vyper dispatchers compare the calldata selector against PUSH4 constants.
+func scanBytecode(code []byte) *codeScan {
+	scan := &codeScan{selectors: map[[4]byte]struct{}{}}
+	for i := 0; i < len(code); i++ {
+		op := code[i]
+		if op == opDelegateCall {
+			scan.delegates = true
+		}
+		if op < opPush1 || op > opPush32 {
+			continue
+		}
+
+		size := int(op-opPush1) + 1
+		if op == opPush4 && i+size < len(code) {
+			var selector [4]byte
+			copy(selector[:], code[i+1:i+1+size])
+			scan.selectors[selector] = struct{}{}
+		}
+		i += size
+	}
+	return scan
+}
+
+func selectorOf(signature string) [4]byte {
+	var selector [4]byte
+	copy(selector[:], crypto.Keccak256([]byte(signature))[:4])
+	return selector
+}
+
+func newTokenRiskAnalyzer(gethService evm.GethService) *tokenRiskAnalyzer {
+	catalogue := map[[4]byte]riskSignature{}
+	for _, signature := range riskSignatures {
+		catalogue[selectorOf(signature.Signature)] = signature
+	}
+
+	return &tokenRiskAnalyzer{
+		gethService: gethService,
+		catalogue:   catalogue,
+		scans:       map[common2.Hash]*codeScan{},
+	}
+}
@@ -0,0 +1,751 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/common/logger"
+	"github.com/cross-space-official/kaboom-service/common"
+	"github.com/cross-space-official/kaboom-service/core"
+	"github.com/cross-space-official/kaboom-service/eventsync"
+	"github.com/cross-space-official/kaboom-service/model"
+	"github.com/cross-space-official/kaboom-service/repository"
+	"github.com/cross-space-official/kaboom-service/service/provider/evm"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	common2 "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	// balance and allowance mappings of common ERC20 implementations live in the first storage slots
+	maxProbedMappingSlot = 32
+	// a sell tax above this share of model.PercentageBase is treated like a blocked sell
+	honeypotSellTaxRatio = 0.9
+)
+
+var (
+	maxUint256               = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	safetySimulationWallet   = common2.BytesToAddress(crypto.Keccak256([]byte("kaboom.safety.simulation.wallet"))[12:])
+	safetySimulationReceiver = common2.BytesToAddress(crypto.Keccak256([]byte("kaboom.safety.simulation.receiver"))[12:])
+)
+
+type TokenSafetyStatus string
+
+const (
+	TokenSafetyStatusPassed   TokenSafetyStatus = "passed"
+	TokenSafetyStatusHoneypot TokenSafetyStatus = "honeypot"
+	TokenSafetyStatusUnknown  TokenSafetyStatus = "unknown"
+)
+
+// TokenSafetyResult holds taxes in model.PercentageBase units.
+type TokenSafetyResult struct {
+	Status            TokenSafetyStatus
+	BuyTax            int64
+	SellTax           int64
+	TransferTax       int64
+	HasMaxTxLimit     bool
+	HasMaxWalletLimit bool
+	Reason            string
+	CheckedAt         time.Time
+}
+
+func (r *TokenSafetyResult) applyTo(token *model.Token) {
+	token.SafetyStatus = string(r.Status)
+	token.IsHoneypot = r.Status == TokenSafetyStatusHoneypot
+	token.BuyTax = r.BuyTax
+	token.SellTax = r.SellTax
+	token.TransferTax = r.TransferTax
+	token.HasMaxTxLimit = r.HasMaxTxLimit
+	token.HasMaxWalletLimit = r.HasMaxWalletLimit
+	token.SafetyCheckReason = r.Reason
+	token.SafetyCheckedAt = &r.CheckedAt
+}
+
+type tokenSafetySimulator struct {
+	erc20Abi        abi.ABI
+	gethService     evm.GethService
+	v3Pools         *v3PoolReader
+	quoteTokens     *quoteTokenService
+	launchpads      *launchpadReader
+	assetRepository repository.AssetRepository
+	chains          *chainMetadataCache
+}
+
+// simulationRun is the per pair state of one simulation.
+type simulationRun struct {
+	ctx           context.Context
+	tracer        eventsync.TraceClient
+	pair          *model.DexPair
+	token         common2.Address
+	pairAddress   common2.Address
+	routerAddress common2.Address
+	nativeAddress string
+	balanceSlot   *common2.Hash
+	// state is what the previous traced call left behind, the next one is simulated on top of it
+	state eventsync.StateOverride
+}
+
+// SimulateAndStore runs the simulation and persists the result on the pair token.
+func (s *tokenSafetySimulator) SimulateAndStore(ctx context.Context, pair *model.DexPair) (*TokenSafetyResult, businesserror.XSpaceBusinessError) {
+	result, err := s.Simulate(ctx, pair)
+	if err != nil {
+		return nil, err
+	}
+
+	token := pair.GetToken()
+	result.applyTo(&token)
+	err = s.assetRepository.UpdateToken(ctx, &token)
+	if err != nil {
+		return nil, err
+	}
+
+	setPairToken(pair, token)
+
+	return result, nil
+}
+
+// Simulate buys, approves and sells through the pair's router, each call traced on top of the state the previous one
+// left behind, and measures the taxes from the traced transfers. Launchpad tokens trade with the launchpad manager,
+// which holds the curve tokens and stands in for the pair. Endpoints without the debug namespace are checked with
+// plain calls instead, see simulateWithCalls.
+func (s *tokenSafetySimulator) Simulate(ctx context.Context, pair *model.DexPair) (*TokenSafetyResult, businesserror.XSpaceBusinessError) {
+	client, err := s.gethService.GetClient(pair.ChainID)
+	if err != nil {
+		return nil, err
+	}
+
+	run := &simulationRun{
+		ctx:         ctx,
+		tracer:      eventsync.NewEthTraceClient(client),
+		pair:        pair,
+		token:       common2.HexToAddress(pair.GetToken().ContractAddress),
+		pairAddress: common2.HexToAddress(pair.ContractAddress),
+	}
+
+	nativeToken, err := s.chains.NativeToken(ctx, pair.ChainID)
+	if err != nil {
+		return nil, err
+	}
+	run.nativeAddress = nativeToken.ContractAddress
+
+	routerAddress, err := s.getRouterAddress(ctx, pair)
+	if err != nil {
+		return nil, err
+	}
+	run.routerAddress = common2.HexToAddress(routerAddress)
+	if isLaunchpadPairType(pair.Type) {
+		run.pairAddress = run.routerAddress
+	}
+
+	result := &TokenSafetyResult{Status: TokenSafetyStatusUnknown, CheckedAt: time.Now()}
+
+	buyValue := new(big.Int).Div(model.GetChainNativeByID(pair.ChainID).BigInt(), big.NewInt(100))
+	bought, buyTax, reason, err := s.simulateBuy(run, buyValue)
+	if eventsync.IsTracingUnsupported(err) {
+		s.simulateWithCalls(run, buyValue, result)
+		return result, nil
+	}
+	if err != nil {
+		result.Reason = err.Error()
+		return result, nil
+	}
+	if len(reason) > 0 {
+		result.Status = TokenSafetyStatusHoneypot
+		result.Reason = reason
+		return result, nil
+	}
+	result.BuyTax = buyTax
+
+	// the sell and transfer start from the state the buy left behind, the wallet holds exactly what it bought
+	reason, err = s.approveRouter(run)
+	if err != nil {
+		result.Reason = err.Error()
+		return result, nil
+	}
+	if len(reason) > 0 {
+		result.Status = TokenSafetyStatusHoneypot
+		result.Reason = reason
+		return result, nil
+	}
+
+	sellTax, reason, err := s.simulateSell(run, bought)
+	if err != nil {
+		result.Reason = err.Error()
+		return result, nil
+	}
+	result.SellTax = sellTax
+	if len(reason) > 0 || isHoneypotSellTax(sellTax) {
+		result.Status = TokenSafetyStatusHoneypot
+		result.Reason = reason
+		if len(reason) == 0 {
+			result.Reason = fmt.Sprintf("sell tax %d of %d", sellTax, model.PercentageBase)
+		}
+		return result, nil
+	}
+
+	transferTax, reason, err := s.simulateTransfer(run, bought)
+	if err == nil && len(reason) == 0 {
+		result.TransferTax = transferTax
+	}
+
+	// a large buy would run into the end of the curve rather than a max tx rule
+	if !isLaunchpadPairType(pair.Type) {
+		result.HasMaxTxLimit, result.HasMaxWalletLimit = s.probeLimits(run, buyValue)
+	}
+	result.Status = TokenSafetyStatusPassed
+	return result, nil
+}
+
+// simulateWithCalls checks the pair with plain eth_calls. Without the transfers of a trace each tax is measured from
+// the smallest minimum output the router still accepts, and the sell starts from the bought balance and an approval
+// written into the token storage instead of from the state after the buy. V3 and launchpad routers check the
+// minimum before the token takes its cut, so their buy tax reads as zero, and the transfer tax is not measured.
+func (s *tokenSafetySimulator) simulateWithCalls(run *simulationRun, buyValue *big.Int, result *TokenSafetyResult) {
+	expectedOut, err := s.poolBuyOut(run, buyValue)
+	if err != nil {
+		result.Reason = err.Error()
+		return
+	}
+	if expectedOut.Sign() <= 0 {
+		result.Reason = "buy quote returned no tokens"
+		return
+	}
+
+	buyTax, reason, err := s.measureTax(run, expectedOut, s.walletOverride(nil), func(minOut *big.Int) (ethereum.CallMsg, businesserror.XSpaceBusinessError) {
+		return s.buyCall(run, buyValue, minOut)
+	})
+	if err != nil {
+		result.Reason = err.Error()
+		return
+	}
+	if len(reason) > 0 {
+		result.Status = TokenSafetyStatusHoneypot
+		result.Reason = "buy reverted: " + reason
+		return
+	}
+	result.BuyTax = buyTax
+
+	bought := percentageOf(expectedOut, int64(model.PercentageBase)-buyTax)
+	if bought.Sign() <= 0 {
+		result.Status = TokenSafetyStatusHoneypot
+		result.Reason = "buy returned no tokens"
+		return
+	}
+
+	state, err := s.sellState(run, bought)
+	if err != nil {
+		result.Reason = err.Error()
+		return
+	}
+
+	expectedNative, err := s.poolSellOut(run, bought)
+	if err != nil {
+		result.Reason = err.Error()
+		return
+	}
+	if expectedNative.Sign() <= 0 {
+		result.Reason = "sell quote returned no native"
+		return
+	}
+
+	sellTax, reason, err := s.measureTax(run, expectedNative, state, func(minOut *big.Int) (ethereum.CallMsg, businesserror.XSpaceBusinessError) {
+		return s.sellCall(run, bought, minOut)
+	})
+	if err != nil {
+		result.Reason = err.Error()
+		return
+	}
+	if len(reason) > 0 {
+		result.Status = TokenSafetyStatusHoneypot
+		result.Reason = "sell reverted: " + reason
+		return
+	}
+	result.SellTax = sellTax
+	if isHoneypotSellTax(sellTax) {
+		result.Status = TokenSafetyStatusHoneypot
+		result.Reason = fmt.Sprintf("sell tax %d of %d", sellTax, model.PercentageBase)
+		return
+	}
+
+	if !isLaunchpadPairType(run.pair.Type) {
+		result.HasMaxTxLimit, result.HasMaxWalletLimit = s.probeLimits(run, buyValue)
+	}
+	result.Status = TokenSafetyStatusPassed
+	result.Reason = "simulated without tracing, the transfer tax is not measured"
+}
+
+// measureTax finds the smallest tax, in model.PercentageBase units, at which the call built for a minimum output of
+// expectedOut less that tax still succeeds. A call that reverts even without a minimum returns the revert as reason.
+func (s *tokenSafetySimulator) measureTax(
+	run *simulationRun,
+	expectedOut *big.Int,
+	overrides eventsync.StateOverride,
+	call func(minOut *big.Int) (ethereum.CallMsg, businesserror.XSpaceBusinessError),
+) (int64, string, businesserror.XSpaceBusinessError) {
+	reverts := func(tax int64) (bool, string, businesserror.XSpaceBusinessError) {
+		msg, err := call(percentageOf(expectedOut, int64(model.PercentageBase)-tax))
+		if err != nil {
+			return false, "", err
+		}
+		return s.callReverts(run, msg, overrides)
+	}
+
+	reverted, reason, err := reverts(int64(model.PercentageBase))
+	if err != nil || reverted {
+		return 0, reason, err
+	}
+
+	low, high := int64(0), int64(model.PercentageBase)
+	for low < high {
+		middle := (low + high) / 2
+		reverted, _, err = reverts(middle)
+		if err != nil {
+			return 0, "", err
+		}
+		if reverted {
+			low = middle + 1
+		} else {
+			high = middle
+		}
+	}
+
+	return high, "", nil
+}
+
+// callReverts runs msg as a plain eth_call and tells a revert, returned with its reason, from a failing endpoint.
+func (s *tokenSafetySimulator) callReverts(run *simulationRun, msg ethereum.CallMsg, overrides eventsync.StateOverride) (bool, string, businesserror.XSpaceBusinessError) {
+	_, err := run.tracer.Call(run.ctx, msg, nil, overrides)
+	if err == nil {
+		return false, "", nil
+	}
+	if strings.Contains(err.Error(), "execution reverted") {
+		return true, strings.TrimSpace(err.Error()), nil
+	}
+
+	return false, "", err
+}
+
+// sellState funds the wallet and writes amount tokens and an unlimited router allowance into the token storage.
+func (s *tokenSafetySimulator) sellState(run *simulationRun, amount *big.Int) (eventsync.StateOverride, businesserror.XSpaceBusinessError) {
+	err := s.probeBalanceSlot(run)
+	if err != nil {
+		return nil, err
+	}
+
+	allowanceSlot, err := s.probeAllowanceSlot(run)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := s.tokenOverride(run, amount)
+	overrides[run.token].StateDiff[allowanceSlot] = common2.BigToHash(maxUint256)
+	return s.walletOverride(overrides), nil
+}
+
+// poolBuyOut and poolSellOut quote the pair before transfer taxes, in the amounts the pool sends out.
+func (s *tokenSafetySimulator) poolBuyOut(run *simulationRun, buyValue *big.Int) (*big.Int, businesserror.XSpaceBusinessError) {
+	if isLaunchpadPairType(run.pair.Type) {
+		return s.launchpads.Quote(run.ctx, run.pair, true, true, buyValue)
+	}
+
+	if isV3PairType(run.pair.Type) {
+		return s.v3Pools.Quote(run.ctx, run.pair, !isTokenToken0(run.pair), true, buyValue)
+	}
+
+	quoteIn := buyValue
+	if !isNativeQuoted(run.pair) {
+		var err businesserror.XSpaceBusinessError
+		quoteIn, err = s.quoteTokens.NativeToQuoteOut(run.ctx, run.pair, run.nativeAddress, buyValue)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return getV2FeeModel(run.pair.Type).AmountOut(quoteIn, run.pair.GetWNativeReserve(), run.pair.GetTokenReserve(), core.TransferTax{}), nil
+}
+
+func (s *tokenSafetySimulator) poolSellOut(run *simulationRun, amount *big.Int) (*big.Int, businesserror.XSpaceBusinessError) {
+	if isLaunchpadPairType(run.pair.Type) {
+		return s.launchpads.Quote(run.ctx, run.pair, false, true, amount)
+	}
+
+	if isV3PairType(run.pair.Type) {
+		return s.v3Pools.Quote(run.ctx, run.pair, isTokenToken0(run.pair), true, amount)
+	}
+
+	quoteOut := getV2FeeModel(run.pair.Type).AmountOut(amount, run.pair.GetTokenReserve(), run.pair.GetWNativeReserve(), core.TransferTax{})
+	if !isNativeQuoted(run.pair) {
+		return s.quoteTokens.QuoteToNativeOut(run.ctx, run.pair, run.nativeAddress, quoteOut)
+	}
+
+	return quoteOut, nil
+}
+
+func (s *tokenSafetySimulator) buyCall(run *simulationRun, buyValue, minOut *big.Int) (ethereum.CallMsg, businesserror.XSpaceBusinessError) {
+	data, err := s.packBuy(run, buyValue, minOut)
+	if err != nil {
+		return ethereum.CallMsg{}, err
+	}
+
+	return ethereum.CallMsg{
+		From:  safetySimulationWallet,
+		To:    &run.routerAddress,
+		Value: buyValue,
+		Data:  data,
+	}, nil
+}
+
+func (s *tokenSafetySimulator) sellCall(run *simulationRun, amount, minOut *big.Int) (ethereum.CallMsg, businesserror.XSpaceBusinessError) {
+	data, err := s.packSell(run, amount, minOut)
+	if err != nil {
+		return ethereum.CallMsg{}, err
+	}
+
+	return ethereum.CallMsg{
+		From: safetySimulationWallet,
+		To:   &run.routerAddress,
+		Data: data,
+	}, nil
+}
+
+func (s *tokenSafetySimulator) transferCall(run *simulationRun, amount *big.Int) (ethereum.CallMsg, businesserror.XSpaceBusinessError) {
+	data, err := s.erc20Abi.Pack("transfer", safetySimulationReceiver, amount)
+	if err != nil {
+		return ethereum.CallMsg{}, common.NewRuntimeError(err)
+	}
+
+	return ethereum.CallMsg{
+		From: safetySimulationWallet,
+		To:   &run.token,
+		Data: data,
+	}, nil
+}
+
+// simulateBuy returns the tokens received by the wallet and the tax between what the pair sent and what arrived,
+// and leaves the funded wallet state with the buy applied on the run.
+func (s *tokenSafetySimulator) simulateBuy(run *simulationRun, buyValue *big.Int) (*big.Int, int64, string, businesserror.XSpaceBusinessError) {
+	msg, err := s.buyCall(run, buyValue, big.NewInt(0))
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	overrides := s.walletOverride(nil)
+	trace, postState, err := run.tracer.TraceCallPostState(run.ctx, msg, nil, overrides)
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	if len(trace.Root.Error) > 0 {
+		return nil, 0, "buy reverted: " + revertReasonOf(trace.Root), nil
+	}
+
+	sent := big.NewInt(0)
+	for _, movement := range trace.TokenMovements(run.token) {
+		if movement.From == run.pairAddress {
+			sent.Add(sent, movement.Amount)
+		}
+	}
+	received := trace.NetFlow(safetySimulationWallet)[run.token]
+	if received == nil || received.Sign() <= 0 {
+		return nil, 0, "buy returned no tokens", nil
+	}
+
+	run.state = overrides.Merge(postState)
+	return received, taxOf(sent, received), "", nil
+}
+
+// approveRouter approves the router on top of the run's state and applies the approval to it.
+func (s *tokenSafetySimulator) approveRouter(run *simulationRun) (string, businesserror.XSpaceBusinessError) {
+	data, basicErr := s.erc20Abi.Pack("approve", run.routerAddress, maxUint256)
+	if basicErr != nil {
+		return "", common.NewRuntimeError(basicErr)
+	}
+
+	msg := ethereum.CallMsg{
+		From: safetySimulationWallet,
+		To:   &run.token,
+		Data: data,
+	}
+
+	trace, postState, err := run.tracer.TraceCallPostState(run.ctx, msg, nil, run.state)
+	if err != nil {
+		return "", err
+	}
+	if len(trace.Root.Error) > 0 {
+		return "approve reverted: " + revertReasonOf(trace.Root), nil
+	}
+
+	run.state = run.state.Merge(postState)
+	return "", nil
+}
+
+func (s *tokenSafetySimulator) simulateSell(run *simulationRun, amount *big.Int) (int64, string, businesserror.XSpaceBusinessError) {
+	msg, err := s.sellCall(run, amount, big.NewInt(0))
+	if err != nil {
+		return 0, "", err
+	}
+
+	trace, err := run.tracer.TraceCall(run.ctx, msg, nil, run.state)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if len(trace.Root.Error) > 0 {
+		return 0, "sell reverted: " + revertReasonOf(trace.Root), nil
+	}
+
+	// only what the wallet itself pushed into the pool counts, swap-back taxes also transfer into it
+	arrived := big.NewInt(0)
+	for _, movement := range trace.TokenMovements(run.token) {
+		if movement.From == safetySimulationWallet && movement.To == run.pairAddress {
+			arrived.Add(arrived, movement.Amount)
+		}
+	}
+
+	native := trace.NetFlow(safetySimulationWallet)[common2.Address{}]
+	if native == nil || native.Sign() <= 0 {
+		return int64(model.PercentageBase), "sell returned no native", nil
+	}
+
+	return taxOf(amount, arrived), "", nil
+}
+
+func (s *tokenSafetySimulator) simulateTransfer(run *simulationRun, amount *big.Int) (int64, string, businesserror.XSpaceBusinessError) {
+	msg, err := s.transferCall(run, amount)
+	if err != nil {
+		return 0, "", err
+	}
+
+	trace, err := run.tracer.TraceCall(run.ctx, msg, nil, run.state)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if len(trace.Root.Error) > 0 {
+		return 0, "transfer reverted: " + revertReasonOf(trace.Root), nil
+	}
+
+	received := trace.NetFlow(safetySimulationReceiver)[run.token]
+	if received == nil {
+		received = big.NewInt(0)
+	}
+
+	return taxOf(amount, received), "", nil
+}
+
+// probeLimits retries with large sizes as plain calls, reverts that did not happen at the small size point to max tx
+// or max wallet rules.
+func (s *tokenSafetySimulator) probeLimits(run *simulationRun, smallBuyValue *big.Int) (bool, bool) {
+	largeBuyValue := new(big.Int).Mul(smallBuyValue, big.NewInt(200))
+	hasMaxTx := false
+	if msg, err := s.buyCall(run, largeBuyValue, big.NewInt(0)); err == nil {
+		hasMaxTx, _, _ = s.callReverts(run, msg, s.walletOverride(nil))
+	}
+
+	totalSupply, err := s.gethService.GetTokenTotalSupply(run.ctx, run.pair.GetToken())
+	if err != nil || totalSupply.Sign() == 0 {
+		return hasMaxTx, false
+	}
+
+	// no buy can fund a holding this large, the balance is written into the token storage instead
+	if s.probeBalanceSlot(run) != nil {
+		return hasMaxTx, false
+	}
+
+	// 3% of the supply is above the usual max wallet of 1-2%
+	largeAmount := new(big.Int).Div(new(big.Int).Mul(totalSupply, big.NewInt(3)), big.NewInt(100))
+	msg, err := s.transferCall(run, largeAmount)
+	if err != nil {
+		return hasMaxTx, false
+	}
+	hasMaxWallet, _, _ := s.callReverts(run, msg, s.walletOverride(s.tokenOverride(run, largeAmount)))
+
+	return hasMaxTx, hasMaxWallet
+}
+
+func (s *tokenSafetySimulator) probeBalanceSlot(run *simulationRun) businesserror.XSpaceBusinessError {
+	if run.balanceSlot != nil {
+		return nil
+	}
+
+	marker := big.NewInt(0x6b61626f6f6d)
+	for slot := int64(0); slot < maxProbedMappingSlot; slot++ {
+		for _, key := range mappingKeys(safetySimulationWallet, slot) {
+			if s.readOverridden(run, "balanceOf", key, marker, safetySimulationWallet) {
+				run.balanceSlot = &key
+				return nil
+			}
+		}
+	}
+
+	return common.NewRuntimeError(errors.New("balance slot not found"))
+}
+
+// probeAllowanceSlot finds the storage key of the wallet's allowance for the router.
+func (s *tokenSafetySimulator) probeAllowanceSlot(run *simulationRun) (common2.Hash, businesserror.XSpaceBusinessError) {
+	marker := big.NewInt(0x6b61626f6f6d)
+	for slot := int64(0); slot < maxProbedMappingSlot; slot++ {
+		for _, key := range allowanceKeys(safetySimulationWallet, run.routerAddress, slot) {
+			if s.readOverridden(run, "allowance", key, marker, safetySimulationWallet, run.routerAddress) {
+				return key, nil
+			}
+		}
+	}
+
+	return common2.Hash{}, common.NewRuntimeError(errors.New("allowance slot not found"))
+}
+
+func (s *tokenSafetySimulator) readOverridden(run *simulationRun, method string, key common2.Hash, marker *big.Int, args ...interface{}) bool {
+	data, err := s.erc20Abi.Pack(method, args...)
+	if err != nil {
+		return false
+	}
+
+	overrides := eventsync.StateOverride{
+		run.token: {StateDiff: map[common2.Hash]common2.Hash{key: common2.BigToHash(marker)}},
+	}
+	output, bizErr := run.tracer.Call(run.ctx, ethereum.CallMsg{To: &run.token, Data: data}, nil, overrides)
+	if bizErr != nil || len(output) < 32 {
+		return false
+	}
+
+	return new(big.Int).SetBytes(output[:32]).Cmp(marker) == 0
+}
+
+func (s *tokenSafetySimulator) tokenOverride(run *simulationRun, balance *big.Int) eventsync.StateOverride {
+	return eventsync.StateOverride{
+		run.token: {StateDiff: map[common2.Hash]common2.Hash{*run.balanceSlot: common2.BigToHash(balance)}},
+	}
+}
+
+func (s *tokenSafetySimulator) walletOverride(overrides eventsync.StateOverride) eventsync.StateOverride {
+	if overrides == nil {
+		overrides = eventsync.StateOverride{}
+	}
+
+	balance := new(big.Int).Lsh(big.NewInt(1), 100)
+	overrides[safetySimulationWallet] = eventsync.AccountOverride{Balance: (*hexutil.Big)(balance)}
+	return overrides
+}
+
+func (s *tokenSafetySimulator) getRouterAddress(ctx context.Context, pair *model.DexPair) (string, businesserror.XSpaceBusinessError) {
+	if isLaunchpadPairType(pair.Type) {
+		return s.launchpads.GetManagerAddress(pair.ChainID, pair.Type)
+	}
+
+	// plain dex router instead of kaboom router so our own fee is not measured as tax
+	return s.chains.DexRouterAddress(ctx, pair.ChainID, pair.Type)
+}
+
+func (s *tokenSafetySimulator) packBuy(run *simulationRun, buyValue, minOut *big.Int) ([]byte, businesserror.XSpaceBusinessError) {
+	if isV3PairType(run.pair.Type) {
+		return s.v3Pools.PackBuyData(run.pair, safetySimulationWallet.Hex(), buyValue, minOut)
+	}
+
+	if isLaunchpadPairType(run.pair.Type) {
+		return s.launchpads.PackBuyData(run.pair, safetySimulationWallet.Hex(), buyValue, minOut)
+	}
+
+	return s.quoteTokens.PackBuyData(run.pair, run.nativeAddress, safetySimulationWallet.Hex(), minOut)
+}
+
+func (s *tokenSafetySimulator) packSell(run *simulationRun, amount, minOut *big.Int) ([]byte, businesserror.XSpaceBusinessError) {
+	if isV3PairType(run.pair.Type) {
+		return s.v3Pools.PackSellData(run.pair, run.routerAddress.Hex(), safetySimulationWallet.Hex(), amount, minOut)
+	}
+
+	if isLaunchpadPairType(run.pair.Type) {
+		return s.launchpads.PackSellData(run.pair, amount, minOut)
+	}
+
+	return s.quoteTokens.PackSellData(run.pair, run.nativeAddress, safetySimulationWallet.Hex(), amount, minOut)
+}
+
+// mappingKeys returns the storage key of holder in a mapping at slot, for solidity and vyper layouts.
+func mappingKeys(holder common2.Address, slot int64) []common2.Hash {
+	paddedHolder := common2.LeftPadBytes(holder.Bytes(), 32)
+	paddedSlot := common2.LeftPadBytes(big.NewInt(slot).Bytes(), 32)
+
+	return []common2.Hash{
+		crypto.Keccak256Hash(paddedHolder, paddedSlot),
+		crypto.Keccak256Hash(paddedSlot, paddedHolder),
+	}
+}
+
+// allowanceKeys returns the storage key of the owner's allowance for spender in a nested mapping at slot, for
+// solidity and vyper layouts.
+func allowanceKeys(owner, spender common2.Address, slot int64) []common2.Hash {
+	paddedSpender := common2.LeftPadBytes(spender.Bytes(), 32)
+	ownerKeys := mappingKeys(owner, slot)
+
+	return []common2.Hash{
+		crypto.Keccak256Hash(paddedSpender, ownerKeys[0].Bytes()),
+		crypto.Keccak256Hash(ownerKeys[1].Bytes(), paddedSpender),
+	}
+}
+
+func isHoneypotSellTax(sellTax int64) bool {
+	return sellTax >= int64(float64(model.PercentageBase)*honeypotSellTaxRatio)
+}
+
+// percentageOf returns share, in model.PercentageBase units, of amount.
+func percentageOf(amount *big.Int, share int64) *big.Int {
+	result := new(big.Int).Mul(amount, big.NewInt(share))
+	return result.Div(result, big.NewInt(model.PercentageBase))
+}
+
+func taxOf(sent, received *big.Int) int64 {
+	if sent.Sign() <= 0 || received.Cmp(sent) >= 0 {
+		return 0
+	}
+
+	lost := new(big.Int).Sub(sent, received)
+	return lost.Mul(lost, big.NewInt(model.PercentageBase)).Div(lost, sent).Int64()
+}
+
+func revertReasonOf(frame *eventsync.CallFrame) string {
+	if len(frame.RevertReason) > 0 {
+		return frame.RevertReason
+	}
+	return strings.TrimSpace(frame.Error)
+}
+
+func newTokenSafetySimulator(
+	gethService evm.GethService,
+	chains *chainMetadataCache,
+	v3Pools *v3PoolReader,
+	quoteTokens *quoteTokenService,
+	launchpads *launchpadReader,
+	assetRepository repository.AssetRepository,
+) *tokenSafetySimulator {
+	erc20Abi, err := core.TokenMetaData.GetAbi()
+	if err != nil {
+		panic(err)
+	}
+
+	return &tokenSafetySimulator{
+		erc20Abi:        *erc20Abi,
+		gethService:     gethService,
+		v3Pools:         v3Pools,
+		quoteTokens:     quoteTokens,
+		launchpads:      launchpads,
+		assetRepository: assetRepository,
+		chains:          chains,
+	}
+}
+
+func logSafetyResult(ctx context.Context, pair *model.DexPair, result *TokenSafetyResult) {
+	logger.GetLoggerEntry(ctx).
+		WithField("pair_id", pair.ID).
+		WithField("token_id", pair.GetToken().ID).
+		WithField("status", result.Status).
+		WithField("buy_tax", result.BuyTax).
+		WithField("sell_tax", result.SellTax).
+		Infof("token safety simulated, %s", result.Reason)
+}
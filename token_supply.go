@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/kaboom-service/model"
+	"github.com/cross-space-official/kaboom-service/repository"
+	"github.com/cross-space-official/kaboom-service/service/provider/evm"
+	common2 "github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"strings"
+	"sync"
+)
+
+// supplyBurnAddresses hold tokens nobody can move again.
+var supplyBurnAddresses = []string{
+	"0x0000000000000000000000000000000000000000",
+	"0x000000000000000000000000000000000000dEaD",
+}
+
+// SupplyExclusion is a wallet whose balance does not circulate, such as a team or treasury wallet.
+// An empty TokenAddress excludes the wallet for every token of the chain. Exclusions come from the chain config,
+// model.Chain.SupplyExclusions, and from RegisterSupplyExclusion for the ones shipped with the service.
+type SupplyExclusion struct {
+	Name         string
+	Address      string
+	TokenAddress string
+}
+
+var (
+	supplyExclusionsMu sync.RWMutex
+	supplyExclusions   = map[string][]SupplyExclusion{}
+)
+
+// RegisterSupplyExclusion excludes a wallet from circulating supply, or replaces the exclusion of the same wallet and token.
+func RegisterSupplyExclusion(chainID string, exclusion SupplyExclusion) {
+	supplyExclusionsMu.Lock()
+	defer supplyExclusionsMu.Unlock()
+
+	for i, existing := range supplyExclusions[chainID] {
+		if strings.EqualFold(existing.Address, exclusion.Address) && strings.EqualFold(existing.TokenAddress, exclusion.TokenAddress) {
+			supplyExclusions[chainID][i] = exclusion
+			return
+		}
+	}
+	supplyExclusions[chainID] = append(supplyExclusions[chainID], exclusion)
+}
+
+func getSupplyExclusions(chainID, tokenAddress string) []SupplyExclusion {
+	supplyExclusionsMu.RLock()
+	defer supplyExclusionsMu.RUnlock()
+
+	var exclusions []SupplyExclusion
+	for _, exclusion := range supplyExclusions[chainID] {
+		if exclusion.appliesTo(tokenAddress) {
+			exclusions = append(exclusions, exclusion)
+		}
+	}
+	return exclusions
+}
+
+func (e SupplyExclusion) appliesTo(tokenAddress string) bool {
+	return len(e.TokenAddress) == 0 || strings.EqualFold(e.TokenAddress, tokenAddress)
+}
+
+type circulatingSupplyReader struct {
+	gethService     evm.GethService
+	assetRepository repository.AssetRepository
+	chains          *chainMetadataCache
+	launchpads      *launchpadReader
+}
+
+// Circulating is totalSupply less what burn addresses, the token's pools, known lockers and excluded wallets hold.
+// Any balance that cannot be read fails the whole read, a partial result would overstate the circulating supply.
+func (r *circulatingSupplyReader) Circulating(ctx context.Context, pair *model.DexPair, token model.Token, totalSupply *big.Int) (*big.Int, businesserror.XSpaceBusinessError) {
+	holders, err := r.excludedHolders(ctx, pair, token)
+	if err != nil {
+		return nil, err
+	}
+
+	circulating := new(big.Int).Set(totalSupply)
+	for _, holder := range holders {
+		balance, err := r.gethService.GetTokenBalance(ctx, pair.ChainID, holder, token.ContractAddress)
+		if err != nil {
+			return nil, err
+		}
+		circulating.Sub(circulating, balance)
+	}
+
+	if circulating.Sign() < 0 {
+		return big.NewInt(0), nil
+	}
+	return circulating, nil
+}
+
+// excludedHolders lists every non circulating holder once, a launchpad pool holds its tokens in the manager.
+func (r *circulatingSupplyReader) excludedHolders(ctx context.Context, pair *model.DexPair, token model.Token) ([]string, businesserror.XSpaceBusinessError) {
+	pools, err := r.assetRepository.RetrievePairsByTokenID(ctx, token.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	holders := append([]string{}, supplyBurnAddresses...)
+	for _, pool := range append(pools, pair) {
+		if !isLaunchpadPairType(pool.Type) {
+			holders = append(holders, pool.ContractAddress)
+			continue
+		}
+
+		manager, err := r.launchpads.GetManagerAddress(pool.ChainID, pool.Type)
+		if err != nil {
+			return nil, err
+		}
+		holders = append(holders, manager)
+	}
+	for _, locker := range getLPLockers(pair.ChainID) {
+		holders = append(holders, locker.Address)
+	}
+	exclusions, err := r.chains.SupplyExclusions(ctx, pair.ChainID, token.ContractAddress)
+	if err != nil {
+		return nil, err
+	}
+	for _, exclusion := range exclusions {
+		holders = append(holders, exclusion.Address)
+	}
+
+	seen := map[common2.Address]bool{}
+	unique := make([]string, 0, len(holders))
+	for _, holder := range holders {
+		address := common2.HexToAddress(holder)
+		if seen[address] {
+			continue
+		}
+		seen[address] = true
+		unique = append(unique, address.Hex())
+	}
+	return unique, nil
+}
+
+// valueAtPrice values amount token wei at price, in native wei per token wei.
+func valueAtPrice(amount *big.Int, price *big.Rat) *big.Int {
+	value := new(big.Rat).Mul(new(big.Rat).SetInt(amount), price)
+	return new(big.Int).Quo(value.Num(), value.Denom())
+}
+
+func newCirculatingSupplyReader(gethService evm.GethService, assetRepository repository.AssetRepository, chains *chainMetadataCache, launchpads *launchpadReader) *circulatingSupplyReader {
+	return &circulatingSupplyReader{
+		gethService:     gethService,
+		assetRepository: assetRepository,
+		chains:          chains,
+		launchpads:      launchpads,
+	}
+}
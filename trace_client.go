@@ -0,0 +1,421 @@
+package eventsync
+
+import (
+	"context"
+	"errors"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/kaboom-service/common"
+	"github.com/ethereum/go-ethereum"
+	common2 "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"math/big"
+	"strings"
+)
+
+type MovementKind string
+
+const (
+	MovementKindNative MovementKind = "native"
+	MovementKindToken  MovementKind = "token"
+)
+
+// methodNotFoundCode is the JSON-RPC error code of a method the endpoint does not serve.
+const methodNotFoundCode = -32601
+
+var (
+	ErrTracingUnsupported = errors.New("debug tracing is not supported by the chain endpoint")
+
+	transferTopic   = common2.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+	depositTopic    = common2.HexToHash("0xe1fffcc4923d04b559f4d29a8bfc6cda04eb5b0d3c460751c2402c5c5cc9109c")
+	withdrawalTopic = common2.HexToHash("0x7fcf532c15f0a6db0bd6d0e038bea71d30d808c7d98cb3bf7268a95bf5081b65")
+)
+
+type CallLog struct {
+	Address common2.Address `json:"address"`
+	Topics  []common2.Hash  `json:"topics"`
+	Data    hexutil.Bytes   `json:"data"`
+}
+
+// CallFrame mirrors the output of geth's callTracer with logs enabled.
+type CallFrame struct {
+	Type         string          `json:"type"`
+	From         common2.Address `json:"from"`
+	To           common2.Address `json:"to"`
+	Value        *hexutil.Big    `json:"value,omitempty"`
+	Gas          hexutil.Uint64  `json:"gas"`
+	GasUsed      hexutil.Uint64  `json:"gasUsed"`
+	Input        hexutil.Bytes   `json:"input"`
+	Output       hexutil.Bytes   `json:"output,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	RevertReason string          `json:"revertReason,omitempty"`
+	Calls        []CallFrame     `json:"calls,omitempty"`
+	Logs         []CallLog       `json:"logs,omitempty"`
+}
+
+type ValueMovement struct {
+	Kind MovementKind
+	// Token is the zero address for native movements.
+	Token  common2.Address
+	From   common2.Address
+	To     common2.Address
+	Amount *big.Int
+	Depth  int
+}
+
+type TraceResult struct {
+	Root      *CallFrame
+	Movements []ValueMovement
+}
+
+// AccountOverride and StateOverride follow the eth_call / debug_traceCall state override format.
+type AccountOverride struct {
+	Nonce *hexutil.Uint64 `json:"nonce,omitempty"`
+	// Code is a pointer so an account can be overridden to have no code.
+	Code      *hexutil.Bytes                `json:"code,omitempty"`
+	Balance   *hexutil.Big                  `json:"balance,omitempty"`
+	StateDiff map[common2.Hash]common2.Hash `json:"stateDiff,omitempty"`
+}
+
+type StateOverride map[common2.Address]AccountOverride
+
+type TraceClient interface {
+	TraceTransaction(ctx context.Context, txnHash string) (*TraceResult, businesserror.XSpaceBusinessError)
+	TraceCall(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int, overrides StateOverride) (*TraceResult, businesserror.XSpaceBusinessError)
+	// Call is a plain eth_call with state overrides, it works on endpoints without the debug namespace.
+	Call(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int, overrides StateOverride) ([]byte, businesserror.XSpaceBusinessError)
+	// TraceCallPostState traces the call like TraceCall and also returns its state changes as an override, so a
+	// following call can be simulated on top of it. Both come from the same debug_traceCall.
+	TraceCallPostState(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int, overrides StateOverride) (*TraceResult, StateOverride, businesserror.XSpaceBusinessError)
+}
+
+// Merge returns o with next applied on top, next wins per balance, nonce, code and storage slot.
+func (o StateOverride) Merge(next StateOverride) StateOverride {
+	merged := StateOverride{}
+	for _, overrides := range []StateOverride{o, next} {
+		for address, account := range overrides {
+			current := merged[address]
+			if account.Nonce != nil {
+				current.Nonce = account.Nonce
+			}
+			if account.Code != nil {
+				current.Code = account.Code
+			}
+			if account.Balance != nil {
+				current.Balance = account.Balance
+			}
+			if len(account.StateDiff) > 0 {
+				stateDiff := make(map[common2.Hash]common2.Hash, len(current.StateDiff)+len(account.StateDiff))
+				for slot, value := range current.StateDiff {
+					stateDiff[slot] = value
+				}
+				for slot, value := range account.StateDiff {
+					stateDiff[slot] = value
+				}
+				current.StateDiff = stateDiff
+			}
+			merged[address] = current
+		}
+	}
+
+	return merged
+}
+
+// prestateAccount is an account of the prestateTracer output, the nonce is left out as it is a plain number.
+type prestateAccount struct {
+	Balance *hexutil.Big                  `json:"balance,omitempty"`
+	Code    hexutil.Bytes                 `json:"code,omitempty"`
+	Storage map[common2.Hash]common2.Hash `json:"storage,omitempty"`
+}
+
+// prestateDiff is the prestateTracer output in diff mode, pre and post only hold the accounts the call modified.
+type prestateDiff struct {
+	Pre  map[common2.Address]prestateAccount `json:"pre"`
+	Post map[common2.Address]prestateAccount `json:"post"`
+}
+
+// muxTrace is the muxTracer output of callTracerConfig and a prestateTracer in diff mode.
+type muxTrace struct {
+	Calls    CallFrame    `json:"callTracer"`
+	Prestate prestateDiff `json:"prestateTracer"`
+}
+
+// postState turns the diff into an override that recreates the state after the call.
+func (d prestateDiff) postState() StateOverride {
+	postState := StateOverride{}
+	for address, account := range d.Pre {
+		// diff mode leaves accounts the call deleted out of post
+		if _, ok := d.Post[address]; !ok {
+			postState[address] = AccountOverride{Code: &hexutil.Bytes{}, Balance: new(hexutil.Big)}
+		}
+
+		// and leaves slots the call cleared out of post
+		for slot := range account.Storage {
+			if _, ok := d.Post[address].Storage[slot]; ok {
+				continue
+			}
+			override := postState[address]
+			if override.StateDiff == nil {
+				override.StateDiff = map[common2.Hash]common2.Hash{}
+			}
+			override.StateDiff[slot] = common2.Hash{}
+			postState[address] = override
+		}
+	}
+	for address, account := range d.Post {
+		override := postState[address]
+		override.Balance = account.Balance
+		if len(account.Code) > 0 {
+			code := account.Code
+			override.Code = &code
+		}
+		if len(account.Storage) > 0 && override.StateDiff == nil {
+			override.StateDiff = map[common2.Hash]common2.Hash{}
+		}
+		for slot, value := range account.Storage {
+			override.StateDiff[slot] = value
+		}
+		postState[address] = override
+	}
+
+	return postState
+}
+
+type evmTraceClient struct {
+	client *rpc.Client
+}
+
+func (t *evmTraceClient) TraceTransaction(ctx context.Context, txnHash string) (*TraceResult, businesserror.XSpaceBusinessError) {
+	var frame CallFrame
+	err := t.client.CallContext(ctx, &frame, "debug_traceTransaction", common2.HexToHash(txnHash), callTracerConfig(nil))
+	if err != nil {
+		return nil, common.NewRuntimeError(traceError(err))
+	}
+
+	return NewTraceResult(&frame), nil
+}
+
+func (t *evmTraceClient) TraceCall(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int, overrides StateOverride) (*TraceResult, businesserror.XSpaceBusinessError) {
+	block := "latest"
+	if blockNumber != nil {
+		block = hexutil.EncodeBig(blockNumber)
+	}
+
+	var frame CallFrame
+	err := t.client.CallContext(ctx, &frame, "debug_traceCall", toCallArg(msg), block, callTracerConfig(overrides))
+	if err != nil {
+		return nil, common.NewRuntimeError(traceError(err))
+	}
+
+	return NewTraceResult(&frame), nil
+}
+
+func (t *evmTraceClient) TraceCallPostState(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int, overrides StateOverride) (*TraceResult, StateOverride, businesserror.XSpaceBusinessError) {
+	block := "latest"
+	if blockNumber != nil {
+		block = hexutil.EncodeBig(blockNumber)
+	}
+
+	config := map[string]interface{}{
+		"tracer": "muxTracer",
+		"tracerConfig": map[string]interface{}{
+			"callTracer":     map[string]interface{}{"withLog": true},
+			"prestateTracer": map[string]interface{}{"diffMode": true},
+		},
+	}
+	if len(overrides) > 0 {
+		config["stateOverrides"] = overrides
+	}
+
+	var trace muxTrace
+	err := t.client.CallContext(ctx, &trace, "debug_traceCall", toCallArg(msg), block, config)
+	if err != nil {
+		return nil, nil, common.NewRuntimeError(traceError(err))
+	}
+
+	return NewTraceResult(&trace.Calls), trace.Prestate.postState(), nil
+}
+
+func (t *evmTraceClient) Call(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int, overrides StateOverride) ([]byte, businesserror.XSpaceBusinessError) {
+	block := "latest"
+	if blockNumber != nil {
+		block = hexutil.EncodeBig(blockNumber)
+	}
+
+	var result hexutil.Bytes
+	var err error
+	if len(overrides) > 0 {
+		err = t.client.CallContext(ctx, &result, "eth_call", toCallArg(msg), block, overrides)
+	} else {
+		err = t.client.CallContext(ctx, &result, "eth_call", toCallArg(msg), block)
+	}
+	if err != nil {
+		return nil, common.NewRuntimeError(RedactError(err))
+	}
+
+	return result, nil
+}
+
+// NewTraceResult flattens the call tree into native and token movements, skipping reverted sub calls.
+func NewTraceResult(root *CallFrame) *TraceResult {
+	result := &TraceResult{Root: root}
+	result.collect(root, 0)
+	return result
+}
+
+func (r *TraceResult) collect(frame *CallFrame, depth int) {
+	if len(frame.Error) > 0 {
+		return
+	}
+
+	if frame.Value != nil && frame.Value.ToInt().Sign() > 0 && frame.Type != "DELEGATECALL" {
+		r.Movements = append(r.Movements, ValueMovement{
+			Kind:   MovementKindNative,
+			From:   frame.From,
+			To:     frame.To,
+			Amount: new(big.Int).Set(frame.Value.ToInt()),
+			Depth:  depth,
+		})
+	}
+
+	for _, log := range frame.Logs {
+		if movement, ok := parseTokenMovement(log, depth); ok {
+			r.Movements = append(r.Movements, movement)
+		}
+	}
+
+	for i := range frame.Calls {
+		r.collect(&frame.Calls[i], depth+1)
+	}
+}
+
+// NetFlow sums what account received minus what it sent, keyed by token (zero address for native).
+func (r *TraceResult) NetFlow(account common2.Address) map[common2.Address]*big.Int {
+	flows := map[common2.Address]*big.Int{}
+	for _, movement := range r.Movements {
+		if movement.From != account && movement.To != account {
+			continue
+		}
+		if _, ok := flows[movement.Token]; !ok {
+			flows[movement.Token] = big.NewInt(0)
+		}
+		if movement.To == account {
+			flows[movement.Token].Add(flows[movement.Token], movement.Amount)
+		}
+		if movement.From == account {
+			flows[movement.Token].Sub(flows[movement.Token], movement.Amount)
+		}
+	}
+
+	return flows
+}
+
+// TokenMovements returns the transfers of a single token in execution order.
+func (r *TraceResult) TokenMovements(token common2.Address) []ValueMovement {
+	movements := make([]ValueMovement, 0)
+	for _, movement := range r.Movements {
+		if movement.Kind == MovementKindToken && movement.Token == token {
+			movements = append(movements, movement)
+		}
+	}
+
+	return movements
+}
+
+func parseTokenMovement(log CallLog, depth int) (ValueMovement, bool) {
+	if len(log.Topics) == 0 || len(log.Data) < 32 {
+		return ValueMovement{}, false
+	}
+
+	movement := ValueMovement{
+		Kind:   MovementKindToken,
+		Token:  log.Address,
+		Amount: new(big.Int).SetBytes(log.Data[:32]),
+		Depth:  depth,
+	}
+
+	switch {
+	case log.Topics[0] == transferTopic && len(log.Topics) == 3:
+		movement.From = common2.BytesToAddress(log.Topics[1].Bytes())
+		movement.To = common2.BytesToAddress(log.Topics[2].Bytes())
+	case log.Topics[0] == depositTopic && len(log.Topics) == 2:
+		movement.To = common2.BytesToAddress(log.Topics[1].Bytes())
+	case log.Topics[0] == withdrawalTopic && len(log.Topics) == 2:
+		movement.From = common2.BytesToAddress(log.Topics[1].Bytes())
+	default:
+		return ValueMovement{}, false
+	}
+
+	return movement, true
+}
+
+func callTracerConfig(overrides StateOverride) map[string]interface{} {
+	config := map[string]interface{}{
+		"tracer":       "callTracer",
+		"tracerConfig": map[string]interface{}{"withLog": true},
+	}
+	if len(overrides) > 0 {
+		config["stateOverrides"] = overrides
+	}
+
+	return config
+}
+
+func toCallArg(msg ethereum.CallMsg) map[string]interface{} {
+	arg := map[string]interface{}{
+		"from": msg.From,
+		"to":   msg.To,
+	}
+	if len(msg.Data) > 0 {
+		arg["input"] = hexutil.Bytes(msg.Data)
+	}
+	if msg.Value != nil {
+		arg["value"] = (*hexutil.Big)(msg.Value)
+	}
+	if msg.Gas != 0 {
+		arg["gas"] = hexutil.Uint64(msg.Gas)
+	}
+	if msg.GasPrice != nil {
+		arg["gasPrice"] = (*hexutil.Big)(msg.GasPrice)
+	}
+
+	return arg
+}
+
+func traceError(err error) error {
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) && rpcErr.ErrorCode() == methodNotFoundCode {
+		return ErrTracingUnsupported
+	}
+	if strings.Contains(err.Error(), "does not exist") || strings.Contains(err.Error(), "not available") {
+		return ErrTracingUnsupported
+	}
+
+	return RedactError(err)
+}
+
+// IsTracingUnsupported tells whether err comes from an endpoint without the debug namespace. The business error
+// built around ErrTracingUnsupported does not unwrap, so its message is matched as well.
+func IsTracingUnsupported(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	return errors.Is(err, ErrTracingUnsupported) || strings.Contains(err.Error(), ErrTracingUnsupported.Error())
+}
+
+// NewTraceClient is optional on top of a sync client, most public endpoints do not expose the debug namespace.
+func NewTraceClient(client SyncClient) TraceClient {
+	if client == nil || client.GetEthClient() == nil {
+		return nil
+	}
+
+	return NewEthTraceClient(client.GetEthClient())
+}
+
+func NewEthTraceClient(client *ethclient.Client) TraceClient {
+	return &evmTraceClient{
+		client: client.Client(),
+	}
+}
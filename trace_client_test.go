@@ -0,0 +1,488 @@
+package eventsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/ethereum/go-ethereum"
+	common2 "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var (
+	testUser   = common2.HexToAddress("0x1111111111111111111111111111111111111111")
+	testRouter = common2.HexToAddress("0x2222222222222222222222222222222222222222")
+	testWeth   = common2.HexToAddress("0x3333333333333333333333333333333333333333")
+	testToken  = common2.HexToAddress("0x4444444444444444444444444444444444444444")
+	testPair   = common2.HexToAddress("0x5555555555555555555555555555555555555555")
+	testTaxTo  = common2.HexToAddress("0x6666666666666666666666666666666666666666")
+
+	testSlot0 = common2.HexToHash("0x00")
+	testSlot1 = common2.HexToHash("0x01")
+	testSlot2 = common2.HexToHash("0x02")
+)
+
+// testBuyTrace is a callTracer output of a buy of 1 native: the router wraps it, the pair sends 900 tokens to the
+// user and 100 to a tax wallet, a reverted sub call and a delegate call moved nothing.
+const testBuyTrace = `{
+	"type": "CALL",
+	"from": "0x1111111111111111111111111111111111111111",
+	"to": "0x2222222222222222222222222222222222222222",
+	"value": "0xde0b6b3a7640000",
+	"gas": "0x30d40",
+	"gasUsed": "0x1d4c0",
+	"input": "0x7ff36ab5",
+	"calls": [
+		{
+			"type": "CALL",
+			"from": "0x2222222222222222222222222222222222222222",
+			"to": "0x3333333333333333333333333333333333333333",
+			"value": "0xde0b6b3a7640000",
+			"input": "0xd0e30db0",
+			"logs": [
+				{
+					"address": "0x3333333333333333333333333333333333333333",
+					"topics": [
+						"0xe1fffcc4923d04b559f4d29a8bfc6cda04eb5b0d3c460751c2402c5c5cc9109c",
+						"0x0000000000000000000000002222222222222222222222222222222222222222"
+					],
+					"data": "0x0000000000000000000000000000000000000000000000000de0b6b3a7640000"
+				}
+			]
+		},
+		{
+			"type": "CALL",
+			"from": "0x2222222222222222222222222222222222222222",
+			"to": "0x5555555555555555555555555555555555555555",
+			"input": "0x022c0d9f",
+			"logs": [
+				{
+					"address": "0x4444444444444444444444444444444444444444",
+					"topics": [
+						"0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef",
+						"0x0000000000000000000000005555555555555555555555555555555555555555",
+						"0x0000000000000000000000001111111111111111111111111111111111111111"
+					],
+					"data": "0x0000000000000000000000000000000000000000000000000000000000000384"
+				},
+				{
+					"address": "0x4444444444444444444444444444444444444444",
+					"topics": [
+						"0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef",
+						"0x0000000000000000000000005555555555555555555555555555555555555555",
+						"0x0000000000000000000000006666666666666666666666666666666666666666"
+					],
+					"data": "0x0000000000000000000000000000000000000000000000000000000000000064"
+				}
+			]
+		},
+		{
+			"type": "CALL",
+			"from": "0x2222222222222222222222222222222222222222",
+			"to": "0x4444444444444444444444444444444444444444",
+			"input": "0xa9059cbb",
+			"error": "execution reverted",
+			"logs": [
+				{
+					"address": "0x4444444444444444444444444444444444444444",
+					"topics": [
+						"0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef",
+						"0x0000000000000000000000005555555555555555555555555555555555555555",
+						"0x0000000000000000000000001111111111111111111111111111111111111111"
+					],
+					"data": "0x0000000000000000000000000000000000000000000000000000000000001388"
+				}
+			]
+		},
+		{
+			"type": "DELEGATECALL",
+			"from": "0x2222222222222222222222222222222222222222",
+			"to": "0x7777777777777777777777777777777777777777",
+			"value": "0xde0b6b3a7640000",
+			"input": "0x"
+		}
+	]
+}`
+
+// testSellDiff is a prestateTracer diff mode output: the token moved a balance slot and cleared another, and the
+// call deleted a helper contract.
+const testSellDiff = `{
+	"pre": {
+		"0x4444444444444444444444444444444444444444": {
+			"balance": "0x0",
+			"storage": {
+				"0x0000000000000000000000000000000000000000000000000000000000000000": "0x0000000000000000000000000000000000000000000000000000000000000384",
+				"0x0000000000000000000000000000000000000000000000000000000000000001": "0x0000000000000000000000000000000000000000000000000000000000000064"
+			}
+		},
+		"0x2222222222222222222222222222222222222222": {
+			"balance": "0x5",
+			"nonce": 1,
+			"code": "0x6080",
+			"storage": {
+				"0x0000000000000000000000000000000000000000000000000000000000000002": "0x0000000000000000000000000000000000000000000000000000000000000001"
+			}
+		},
+		"0x1111111111111111111111111111111111111111": {
+			"balance": "0xde0b6b3a7640000",
+			"nonce": 3
+		}
+	},
+	"post": {
+		"0x4444444444444444444444444444444444444444": {
+			"storage": {
+				"0x0000000000000000000000000000000000000000000000000000000000000000": "0x0000000000000000000000000000000000000000000000000000000000000320"
+			}
+		},
+		"0x1111111111111111111111111111111111111111": {
+			"balance": "0xddf3d4e4bc10000",
+			"nonce": 4
+		}
+	}
+}`
+
+func TestParseTokenMovement(t *testing.T) {
+	amount := "0x0000000000000000000000000000000000000000000000000000000000000384"
+	userTopic := common2.BytesToHash(testUser.Bytes())
+	pairTopic := common2.BytesToHash(testPair.Bytes())
+
+	tests := []struct {
+		name   string
+		log    CallLog
+		wantOk bool
+		want   ValueMovement
+	}{
+		{
+			name:   "transfer",
+			log:    CallLog{Address: testToken, Topics: []common2.Hash{transferTopic, pairTopic, userTopic}, Data: hexutil.MustDecode(amount)},
+			wantOk: true,
+			want:   ValueMovement{Kind: MovementKindToken, Token: testToken, From: testPair, To: testUser, Amount: big.NewInt(900), Depth: 2},
+		},
+		{
+			name:   "deposit mints to the depositor",
+			log:    CallLog{Address: testWeth, Topics: []common2.Hash{depositTopic, userTopic}, Data: hexutil.MustDecode(amount)},
+			wantOk: true,
+			want:   ValueMovement{Kind: MovementKindToken, Token: testWeth, To: testUser, Amount: big.NewInt(900), Depth: 2},
+		},
+		{
+			name:   "withdrawal burns from the withdrawer",
+			log:    CallLog{Address: testWeth, Topics: []common2.Hash{withdrawalTopic, userTopic}, Data: hexutil.MustDecode(amount)},
+			wantOk: true,
+			want:   ValueMovement{Kind: MovementKindToken, Token: testWeth, From: testUser, Amount: big.NewInt(900), Depth: 2},
+		},
+		{
+			name: "erc721 transfer has the id in a topic",
+			log:  CallLog{Address: testToken, Topics: []common2.Hash{transferTopic, pairTopic, userTopic, common2.HexToHash("0x01")}},
+		},
+		{
+			name: "short data",
+			log:  CallLog{Address: testToken, Topics: []common2.Hash{transferTopic, pairTopic, userTopic}, Data: hexutil.MustDecode("0x0384")},
+		},
+		{
+			name: "other event",
+			log:  CallLog{Address: testToken, Topics: []common2.Hash{common2.HexToHash("0x8c5be1e5"), pairTopic, userTopic}, Data: hexutil.MustDecode(amount)},
+		},
+		{
+			name: "anonymous event",
+			log:  CallLog{Address: testToken, Data: hexutil.MustDecode(amount)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := parseTokenMovement(test.log, 2)
+			if ok != test.wantOk {
+				t.Fatalf("parseTokenMovement() ok = %v, want %v", ok, test.wantOk)
+			}
+			if !ok {
+				return
+			}
+			if got.Kind != test.want.Kind || got.Token != test.want.Token || got.From != test.want.From ||
+				got.To != test.want.To || got.Amount.Cmp(test.want.Amount) != 0 || got.Depth != test.want.Depth {
+				t.Fatalf("parseTokenMovement() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTraceResultNetFlow(t *testing.T) {
+	var frame CallFrame
+	if err := json.Unmarshal([]byte(testBuyTrace), &frame); err != nil {
+		t.Fatalf("decoding trace: %v", err)
+	}
+	result := NewTraceResult(&frame)
+
+	oneNative := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	native := common2.Address{}
+
+	tests := []struct {
+		name    string
+		account common2.Address
+		want    map[common2.Address]*big.Int
+	}{
+		{
+			name:    "user pays native and receives the tokens",
+			account: testUser,
+			want:    map[common2.Address]*big.Int{native: new(big.Int).Neg(oneNative), testToken: big.NewInt(900)},
+		},
+		{
+			name:    "router passes the native on and holds the wrapped native",
+			account: testRouter,
+			want:    map[common2.Address]*big.Int{native: big.NewInt(0), testWeth: oneNative},
+		},
+		{
+			name:    "pair only sends what did not revert",
+			account: testPair,
+			want:    map[common2.Address]*big.Int{testToken: big.NewInt(-1000)},
+		},
+		{
+			name:    "tax wallet",
+			account: testTaxTo,
+			want:    map[common2.Address]*big.Int{testToken: big.NewInt(100)},
+		},
+		{
+			name:    "uninvolved account",
+			account: common2.HexToAddress("0x8888888888888888888888888888888888888888"),
+			want:    map[common2.Address]*big.Int{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := result.NetFlow(test.account)
+			if len(got) != len(test.want) {
+				t.Fatalf("NetFlow() = %v, want %v", got, test.want)
+			}
+			for token, amount := range test.want {
+				if got[token] == nil || got[token].Cmp(amount) != 0 {
+					t.Fatalf("NetFlow()[%s] = %v, want %v", token.Hex(), got[token], amount)
+				}
+			}
+		})
+	}
+
+	if movements := result.TokenMovements(testToken); len(movements) != 2 || movements[0].To != testUser || movements[0].Depth != 1 {
+		t.Fatalf("TokenMovements() = %+v, want the two transfers of the swap in order", movements)
+	}
+}
+
+func TestStateOverrideMerge(t *testing.T) {
+	code := hexutil.Bytes{0x60, 0x80}
+	noCode := hexutil.Bytes{}
+	nonce := hexutil.Uint64(4)
+	one := (*hexutil.Big)(big.NewInt(1))
+	two := (*hexutil.Big)(big.NewInt(2))
+	value := func(n int64) common2.Hash { return common2.BigToHash(big.NewInt(n)) }
+
+	tests := []struct {
+		name  string
+		base  StateOverride
+		next  StateOverride
+		check func(t *testing.T, merged StateOverride)
+	}{
+		{
+			name: "next wins per slot and keeps the other slots",
+			base: StateOverride{testToken: {StateDiff: map[common2.Hash]common2.Hash{testSlot0: value(1), testSlot1: value(2)}}},
+			next: StateOverride{testToken: {StateDiff: map[common2.Hash]common2.Hash{testSlot1: value(3), testSlot2: value(4)}}},
+			check: func(t *testing.T, merged StateOverride) {
+				stateDiff := merged[testToken].StateDiff
+				if len(stateDiff) != 3 || stateDiff[testSlot0] != value(1) || stateDiff[testSlot1] != value(3) || stateDiff[testSlot2] != value(4) {
+					t.Fatalf("StateDiff = %v, want slot 0 kept, slot 1 replaced and slot 2 added", stateDiff)
+				}
+			},
+		},
+		{
+			name: "unset fields of next keep the base",
+			base: StateOverride{testUser: {Balance: one, Nonce: &nonce, Code: &code}},
+			next: StateOverride{testUser: {StateDiff: map[common2.Hash]common2.Hash{testSlot0: value(1)}}},
+			check: func(t *testing.T, merged StateOverride) {
+				account := merged[testUser]
+				if account.Balance != one || account.Nonce != &nonce || account.Code != &code || len(account.StateDiff) != 1 {
+					t.Fatalf("account = %+v, want the base balance, nonce and code with the new slot", account)
+				}
+			},
+		},
+		{
+			name: "next balance and cleared code win",
+			base: StateOverride{testRouter: {Balance: one, Code: &code}},
+			next: StateOverride{testRouter: {Balance: two, Code: &noCode}},
+			check: func(t *testing.T, merged StateOverride) {
+				account := merged[testRouter]
+				if account.Balance != two || account.Code == nil || len(*account.Code) != 0 {
+					t.Fatalf("account = %+v, want the next balance and no code", account)
+				}
+			},
+		},
+		{
+			name: "accounts of both sides",
+			base: StateOverride{testUser: {Balance: one}},
+			next: StateOverride{testPair: {Balance: two}},
+			check: func(t *testing.T, merged StateOverride) {
+				if len(merged) != 2 || merged[testUser].Balance != one || merged[testPair].Balance != two {
+					t.Fatalf("Merge() = %+v, want both accounts", merged)
+				}
+			},
+		},
+		{
+			name: "nil base",
+			next: StateOverride{testPair: {Balance: two}},
+			check: func(t *testing.T, merged StateOverride) {
+				if len(merged) != 1 || merged[testPair].Balance != two {
+					t.Fatalf("Merge() = %+v, want the next account", merged)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base := test.base
+			var before int
+			if base != nil {
+				before = len(base[testToken].StateDiff)
+			}
+
+			test.check(t, base.Merge(test.next))
+
+			if base != nil && len(base[testToken].StateDiff) != before {
+				t.Fatal("Merge() modified the base override")
+			}
+		})
+	}
+}
+
+// newTraceServer answers every call with result and records the params of the last one.
+func newTraceServer(t *testing.T, result string) (*evmTraceClient, *[]json.RawMessage) {
+	t.Helper()
+
+	var params []json.RawMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ID     json.RawMessage   `json:"id"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		params = request.Params
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      request.ID,
+			"result":  json.RawMessage(result),
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := rpc.DialContext(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("dialing trace server: %v", err)
+	}
+	t.Cleanup(client.Close)
+
+	return &evmTraceClient{client: client}, &params
+}
+
+func TestTraceCallPostState(t *testing.T) {
+	client, params := newTraceServer(t, `{"callTracer": `+testBuyTrace+`, "prestateTracer": `+testSellDiff+`}`)
+
+	trace, postState, err := client.TraceCallPostState(context.Background(), ethereum.CallMsg{From: testUser, To: &testRouter}, nil, nil)
+	if err != nil {
+		t.Fatalf("TraceCallPostState() error = %v", err)
+	}
+
+	if len(*params) != 3 {
+		t.Fatalf("debug_traceCall params = %s, want call, block and tracer config", *params)
+	}
+	if config := string((*params)[2]); !strings.Contains(config, `"muxTracer"`) {
+		t.Errorf("tracer config = %s, want a single muxTracer call", config)
+	}
+
+	if received := trace.NetFlow(testUser)[testToken]; received == nil || received.Cmp(big.NewInt(900)) != 0 {
+		t.Errorf("traced user token flow = %v, want 900", received)
+	}
+
+	token := postState[testToken]
+	if token.StateDiff[testSlot0] != common2.BigToHash(big.NewInt(800)) {
+		t.Errorf("token slot 0 = %s, want the post value 800", token.StateDiff[testSlot0].Hex())
+	}
+	if value, ok := token.StateDiff[testSlot1]; !ok || value != (common2.Hash{}) {
+		t.Errorf("token slot 1 = %s, %v, want the cleared slot overridden to zero", value.Hex(), ok)
+	}
+	if token.Balance != nil || token.Code != nil {
+		t.Errorf("token = %+v, want balance and code left alone", token)
+	}
+
+	deleted := postState[testRouter]
+	if deleted.Balance == nil || deleted.Balance.ToInt().Sign() != 0 {
+		t.Errorf("deleted balance = %v, want zero", deleted.Balance)
+	}
+	if deleted.Code == nil || len(*deleted.Code) != 0 {
+		t.Errorf("deleted code = %v, want overridden to no code", deleted.Code)
+	}
+	if value, ok := deleted.StateDiff[testSlot2]; !ok || value != (common2.Hash{}) {
+		t.Errorf("deleted slot 2 = %s, %v, want cleared", value.Hex(), ok)
+	}
+
+	user := postState[testUser]
+	if user.Balance == nil || user.Balance.ToInt().Cmp(big.NewInt(0xddf3d4e4bc10000)) != 0 || user.StateDiff != nil {
+		t.Errorf("user = %+v, want the post balance only", user)
+	}
+
+	// the deleted account is sent on as an explicit empty code
+	encoded, marshalErr := json.Marshal(postState)
+	if marshalErr != nil {
+		t.Fatalf("encoding post state: %v", marshalErr)
+	}
+	var decoded map[common2.Address]map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("decoding post state: %v", err)
+	}
+	if code := string(decoded[testRouter]["code"]); code != `"0x"` {
+		t.Errorf("deleted code encodes as %s, want \"0x\"", code)
+	}
+}
+
+type testRPCError struct {
+	code    int
+	message string
+}
+
+func (e testRPCError) Error() string  { return e.message }
+func (e testRPCError) ErrorCode() int { return e.code }
+
+func TestIsTracingUnsupported(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "method not found code", err: testRPCError{code: -32601, message: "Method not found"}, want: true},
+		{name: "geth missing namespace", err: errors.New("the method debug_traceCall does not exist/is not available"), want: true},
+		{name: "message of a wrapping error", err: errors.New("runtime error: " + ErrTracingUnsupported.Error()), want: true},
+		{name: "execution reverted", err: testRPCError{code: 3, message: "execution reverted"}, want: false},
+		{name: "timeout", err: errors.New("context deadline exceeded"), want: false},
+		{name: "no error", err: nil, want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.err
+			if err != nil {
+				err = traceError(err)
+			}
+
+			if got := IsTracingUnsupported(err); got != test.want {
+				t.Fatalf("IsTracingUnsupported(%v) = %v, want %v", err, got, test.want)
+			}
+		})
+	}
+}
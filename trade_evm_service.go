@@ -23,6 +23,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -33,6 +34,12 @@ type evmTradeService struct {
 	cutonomyService mpc.CustonomyService
 	pollingManager  MpcPollingManager
 	kaboomRouterAbi abi.ABI
+	v3Pools         *v3PoolReader
+	quoteTokens     *quoteTokenService
+	usdOracle       *usdPriceOracle
+	depth           *liquidityDepthCalculator
+	launchpads      *launchpadReader
+	chains          *chainMetadataCache
 
 	assetRepository        repository.AssetRepository
 	tokenBalanceRepository repository.TokenBalanceRepository
@@ -68,6 +75,7 @@ func (a *evmTradeService) RefreshTokenBalancesByUser(ctx context.Context, user m
 			}
 
 			balance.BalanceInWei = model.NewBigInt(*val)
+			balance.ValueUSD = a.usdOracle.NativeToUSD(ctx, balance.DexPair.ChainID, tokenValueInNative(balance.DexPair.GetToken(), val))
 		}(balances[i])
 	}
 
@@ -95,7 +103,7 @@ func (a *evmTradeService) ComposeTransactionApprovePairByID(ctx context.Context,
 		return nil, err
 	}
 
-	routerAddress, err := a.gethService.GetKaboomRouterAddress(pair.ChainID)
+	routerAddress, err := a.getRouterAddress(ctx, pair)
 	if err != nil {
 		return nil, err
 	}
@@ -143,11 +151,6 @@ func (a *evmTradeService) ComposeTransactionSellPairByID(ctx context.Context, us
 		return nil, err
 	}
 
-	routerAddress, err := a.gethService.GetKaboomRouterAddress(pair.ChainID)
-	if err != nil {
-		return nil, err
-	}
-
 	user, err := a.userService.RetrieveUserByID(ctx, userID)
 	if err != nil {
 		return nil, err
@@ -160,8 +163,19 @@ func (a *evmTradeService) ComposeTransactionSellPairByID(ctx context.Context, us
 	userSettings := user.GetUserSettingsByChainID(pair.ChainID)
 	userWalletAddress := user.GetWalletAddress(pair.ChainID)
 
+	route, err := a.routeSell(ctx, pair, userWalletAddress, sellValueInWei)
+	if err != nil {
+		return nil, err
+	}
+	pair = route.Legs[0].Pair
+
+	routerAddress, err := a.getRouterAddress(ctx, pair)
+	if err != nil {
+		return nil, err
+	}
+
 	if minimalOutAmountInWei == nil {
-		expectETHAmountOutWei := decimal.NewFromBigInt(pair.GetSellAmountOut(sellValueInWei), 0)
+		expectETHAmountOutWei := decimal.NewFromBigInt(route.ExpectedOut, 0)
 		expectETHAmountOutWeiWithSlippage :=
 			expectETHAmountOutWei.
 				Div(decimal.NewFromInt(model.PercentageBase)).
@@ -169,6 +183,44 @@ func (a *evmTradeService) ComposeTransactionSellPairByID(ctx context.Context, us
 		minimalOutAmountInWei = expectETHAmountOutWeiWithSlippage.BigInt()
 	}
 
+	if isQuoteTokenRouted(pair) {
+		nativeAddress, err := a.getNativeAddress(ctx, pair.ChainID)
+		if err != nil {
+			return nil, err
+		}
+
+		return &WalletSignPayload{
+			ToAddress:  routerAddress,
+			ChainID:    pair.ChainID,
+			Method:     "swapExactTokensForETHSupportingFeeOnTransferTokens",
+			ValueInWei: "0",
+			CallData: map[string]interface{}{
+				"amountIn":     sellValueInWei.String(),
+				"amountOutMin": minimalOutAmountInWei.String(),
+				"path":         hexAddresses(a.quoteTokens.SellPath(pair, nativeAddress)),
+				"to":           userWalletAddress,
+				"deadline":     time.Now().Add(10 * time.Minute).Unix(),
+			},
+		}, nil
+	}
+
+	if !isKaboomRouted(pair) {
+		data, err := a.packSellData(ctx, "", pair.GetToken().ContractAddress, pair, user, sellValueInWei, minimalOutAmountInWei)
+		if err != nil {
+			return nil, err
+		}
+
+		return &WalletSignPayload{
+			ToAddress:  routerAddress,
+			ChainID:    pair.ChainID,
+			Method:     getSellMethod(pair),
+			ValueInWei: "0",
+			CallData: map[string]interface{}{
+				"calldata": hexutil.Encode(data),
+			},
+		}, nil
+	}
+
 	return &WalletSignPayload{
 		ToAddress:  routerAddress,
 		ChainID:    pair.ChainID,
@@ -191,9 +243,8 @@ func (a *evmTradeService) ComposeTransactionBuyPairByID(ctx context.Context, use
 		return nil, err
 	}
 
-	routerAddress, err := a.gethService.GetKaboomRouterAddress(pair.ChainID)
-	if err != nil {
-		return nil, err
+	if pair.GetToken().IsHoneypot {
+		return nil, common.NewRuntimeError(errors.New(common.HoneypotTokenFailure))
 	}
 
 	user, err := a.userService.RetrieveUserByID(ctx, userID)
@@ -213,8 +264,20 @@ func (a *evmTradeService) ComposeTransactionBuyPairByID(ctx context.Context, use
 		buyValueInWei = userSettings.GetBoomAmountInWei(pair.ChainID).BigInt()
 	}
 
+	// a composed payload is a single transaction, so it goes to the best pool without splitting
+	route, err := a.routeBuy(ctx, pair, buyValueInWei, false)
+	if err != nil {
+		return nil, err
+	}
+	pair = route.Legs[0].Pair
+
+	routerAddress, err := a.getRouterAddress(ctx, pair)
+	if err != nil {
+		return nil, err
+	}
+
 	if minimalOutAmountInWei == nil {
-		expectTokenAmountOutWei := decimal.NewFromBigInt(pair.GetBuyAmountOut(buyValueInWei), 0)
+		expectTokenAmountOutWei := decimal.NewFromBigInt(route.ExpectedOut, 0)
 		expectTokenAmountOutWeiWithSlippage :=
 			expectTokenAmountOutWei.
 				Div(decimal.NewFromInt(model.PercentageBase)).
@@ -222,6 +285,43 @@ func (a *evmTradeService) ComposeTransactionBuyPairByID(ctx context.Context, use
 		minimalOutAmountInWei = expectTokenAmountOutWeiWithSlippage.BigInt()
 	}
 
+	if isQuoteTokenRouted(pair) {
+		nativeAddress, err := a.getNativeAddress(ctx, pair.ChainID)
+		if err != nil {
+			return nil, err
+		}
+
+		return &WalletSignPayload{
+			ToAddress:  routerAddress,
+			ChainID:    pair.ChainID,
+			Method:     "swapExactETHForTokensSupportingFeeOnTransferTokens",
+			ValueInWei: buyValueInWei.String(),
+			CallData: map[string]interface{}{
+				"amountOutMin": minimalOutAmountInWei.String(),
+				"path":         hexAddresses(a.quoteTokens.BuyPath(pair, nativeAddress)),
+				"to":           userWalletAddress,
+				"deadline":     time.Now().Add(10 * time.Minute).Unix(),
+			},
+		}, nil
+	}
+
+	if !isKaboomRouted(pair) {
+		data, err := a.packBuyData(ctx, "", pair.GetToken().ContractAddress, pair, user, buyValueInWei, minimalOutAmountInWei)
+		if err != nil {
+			return nil, err
+		}
+
+		return &WalletSignPayload{
+			ToAddress:  routerAddress,
+			ChainID:    pair.ChainID,
+			Method:     getBuyMethod(pair),
+			ValueInWei: buyValueInWei.String(),
+			CallData: map[string]interface{}{
+				"calldata": hexutil.Encode(data),
+			},
+		}, nil
+	}
+
 	return &WalletSignPayload{
 		ToAddress:  routerAddress,
 		ChainID:    pair.ChainID,
@@ -255,6 +355,16 @@ func (a *evmTradeService) GetNativeTokenBalanceByUserID(c context.Context, chain
 	return balance, nil
 }
 
+// GetNativeTokenBalanceInUSDByUserID returns nil when no fresh native usd price is available.
+func (a *evmTradeService) GetNativeTokenBalanceInUSDByUserID(c context.Context, chainID, userID string) (*decimal.Decimal, businesserror.XSpaceBusinessError) {
+	balance, err := a.GetNativeTokenBalanceByUserID(c, chainID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.usdOracle.NativeToUSD(c, chainID, balance), nil
+}
+
 func (a *evmTradeService) ApprovePairByIDSync(ctx context.Context, userID, pairID, jwt string, amountInWei *big.Int) businesserror.XSpaceBusinessError {
 	pair, err := a.assetRepository.RetrievePairByPairID(ctx, pairID)
 	if err != nil {
@@ -276,7 +386,7 @@ func (a *evmTradeService) ApprovePairByIDSync(ctx context.Context, userID, pairI
 		return common.NewRuntimeError(errors.New(common.NoBoundWalletFailure))
 	}
 
-	data, err := a.packApproveData(pair.ChainID, amountInWei)
+	data, err := a.packApproveData(ctx, pair, amountInWei)
 	if err != nil {
 		return err
 	}
@@ -351,9 +461,8 @@ func (a *evmTradeService) PreflightBuyPairByID(ctx context.Context, userID, pair
 		return false, nil, err
 	}
 
-	routerAddress, err := a.gethService.GetKaboomRouterAddress(pair.ChainID)
-	if err != nil {
-		return false, nil, err
+	if pair.GetToken().IsHoneypot {
+		return false, nil, common.NewRuntimeError(errors.New(common.HoneypotTokenFailure))
 	}
 
 	user, err := a.userService.RetrieveUserByID(ctx, userID)
@@ -373,7 +482,19 @@ func (a *evmTradeService) PreflightBuyPairByID(ctx context.Context, userID, pair
 	if buyValueInWei == nil {
 		buyValueInWei = userSettings.GetBoomAmountInWei(pair.ChainID).BigInt()
 	}
-	data, err := a.packBuyData(requestID, pair.GetToken().ContractAddress, pair, user, buyValueInWei, nil)
+
+	route, err := a.routeBuy(ctx, pair, buyValueInWei, false)
+	if err != nil {
+		return false, nil, err
+	}
+	pair = route.Legs[0].Pair
+
+	routerAddress, err := a.getRouterAddress(ctx, pair)
+	if err != nil {
+		return false, nil, err
+	}
+
+	data, err := a.packBuyData(ctx, requestID, pair.GetToken().ContractAddress, pair, user, buyValueInWei, nil)
 	if err != nil {
 		return false, nil, err
 	}
@@ -425,12 +546,18 @@ func (a *evmTradeService) PreviewBuyPairByID(ctx context.Context, pairID string,
 	}
 
 	if inAmountInWei != nil {
-		expectTokenAmountOutWei := decimal.NewFromBigInt(pair.GetBuyAmountOut(inAmountInWei), 0)
-		return pair, expectTokenAmountOutWei.BigInt(), nil
+		expectTokenAmountOutWei, err := a.getBuyAmountOut(ctx, pair, inAmountInWei)
+		if err != nil {
+			return nil, nil, err
+		}
+		return pair, expectTokenAmountOutWei, nil
 	}
 
-	expectEthAmountInWei := decimal.NewFromBigInt(pair.GetBuyAmountIn(outAmountInWei), 0)
-	return pair, expectEthAmountInWei.BigInt(), nil
+	expectEthAmountInWei, err := a.getBuyAmountIn(ctx, pair, outAmountInWei)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pair, expectEthAmountInWei, nil
 }
 
 func (a *evmTradeService) PreviewSellPairByID(ctx context.Context, pairID string, inAmountInWei, outAmountInWei *big.Int) (*model.DexPair, *big.Int, businesserror.XSpaceBusinessError) {
@@ -440,12 +567,33 @@ func (a *evmTradeService) PreviewSellPairByID(ctx context.Context, pairID string
 	}
 
 	if inAmountInWei != nil {
-		expectEthAmountOutWei := decimal.NewFromBigInt(pair.GetSellAmountOut(inAmountInWei), 0)
-		return pair, expectEthAmountOutWei.BigInt(), nil
+		expectEthAmountOutWei, err := a.getSellAmountOut(ctx, pair, inAmountInWei)
+		if err != nil {
+			return nil, nil, err
+		}
+		return pair, expectEthAmountOutWei, nil
 	}
 
-	expectTokenAmountInWei := decimal.NewFromBigInt(pair.GetSellAmountIn(outAmountInWei), 0)
-	return pair, expectTokenAmountInWei.BigInt(), nil
+	expectTokenAmountInWei, err := a.getSellAmountIn(ctx, pair, outAmountInWei)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pair, expectTokenAmountInWei, nil
+}
+
+// PreviewBuyPriceImpactByID returns how far below spot the buy fills, dex fee and measured buy tax included.
+func (a *evmTradeService) PreviewBuyPriceImpactByID(ctx context.Context, pairID string, inAmountInWei *big.Int) (decimal.Decimal, businesserror.XSpaceBusinessError) {
+	pair, err := a.assetRepository.RetrievePairByPairID(ctx, pairID)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	received, err := a.getBuyAmountOut(ctx, pair, inAmountInWei)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	return a.depth.PriceImpact(ctx, pair, true, inAmountInWei, received)
 }
 
 func (a *evmTradeService) WithdrawNativeTokenByUserID(ctx context.Context, userID, jwt, chainIDStr, toAddress string, amountInWei *big.Int, clientIp string) businesserror.XSpaceBusinessError {
@@ -520,11 +668,6 @@ func (a *evmTradeService) SellPairByID(ctx context.Context, userID, pairID, jwt
 		return err
 	}
 
-	routerAddress, err := a.gethService.GetKaboomRouterAddress(pair.ChainID)
-	if err != nil {
-		return err
-	}
-
 	user, err := a.userService.RetrieveUserByID(ctx, userID)
 	if err != nil {
 		return err
@@ -539,6 +682,17 @@ func (a *evmTradeService) SellPairByID(ctx context.Context, userID, pairID, jwt
 	}
 	userWalletAddress := user.GetWalletAddress(pair.ChainID)
 
+	route, err := a.routeSell(ctx, pair, userWalletAddress, sellValueInWei)
+	if err != nil {
+		return err
+	}
+	pair = route.Legs[0].Pair
+
+	routerAddress, err := a.getRouterAddress(ctx, pair)
+	if err != nil {
+		return err
+	}
+
 	allowance, err := a.gethService.GetTokenApproveAmount(ctx, pair.ChainID, pair.GetToken().ContractAddress, userWalletAddress, routerAddress)
 	if err != nil {
 		return err
@@ -554,10 +708,10 @@ func (a *evmTradeService) SellPairByID(ctx context.Context, userID, pairID, jwt
 	}
 
 	go func() {
-		err = a.ApprovePairByIDSync(ctx, userID, pairID, jwt, sellValueInWei)
+		err = a.ApprovePairByIDSync(ctx, userID, pair.ID, jwt, sellValueInWei)
 		if err != nil {
 			logger.GetLoggerEntry(ctx).
-				WithField("pair_id", pairID).
+				WithField("pair_id", pair.ID).
 				WithField("user_id", userID).
 				WithField("message", err.Message()).
 				Warn("Failed to approve pair: ", err)
@@ -567,7 +721,7 @@ func (a *evmTradeService) SellPairByID(ctx context.Context, userID, pairID, jwt
 		err = a.submitSellTransaction(ctx, user, pair, jwt, clientIp, sellValueInWei, minimalOutAmountInWei)
 		if err != nil {
 			logger.GetLoggerEntry(ctx).
-				WithField("pair_id", pairID).
+				WithField("pair_id", pair.ID).
 				WithField("user_id", userID).
 				WithField("message", err.Message()).
 				Warn("Failed to sell pair: ", err)
@@ -579,15 +733,16 @@ func (a *evmTradeService) SellPairByID(ctx context.Context, userID, pairID, jwt
 	return nil
 }
 
+// BuyPairByID routes the buy to the best pool of the token, a buy that fills better across pools is submitted
+// as one transaction per pool with consecutive nonces.
 func (a *evmTradeService) BuyPairByID(ctx context.Context, userID, pairID, jwt string, amountInWei, minimalOutAmountInWei, suggestedGasPrice, suggestedGasLimit *big.Int, clientIp string) businesserror.XSpaceBusinessError {
 	pair, err := a.assetRepository.RetrievePairByPairID(ctx, pairID)
 	if err != nil {
 		return err
 	}
 
-	routerAddress, err := a.gethService.GetKaboomRouterAddress(pair.ChainID)
-	if err != nil {
-		return err
+	if pair.GetToken().IsHoneypot {
+		return common.NewRuntimeError(errors.New(common.HoneypotTokenFailure))
 	}
 
 	user, err := a.userService.RetrieveUserByID(ctx, userID)
@@ -604,31 +759,102 @@ func (a *evmTradeService) BuyPairByID(ctx context.Context, userID, pairID, jwt s
 	}
 
 	userSettings := user.GetUserSettingsByChainID(pair.ChainID)
-	userWalletAddress := user.GetWalletAddress(pair.ChainID)
 
-	requestID := uuid.New().String()
 	buyValueInWei := amountInWei
 	if buyValueInWei == nil {
 		buyValueInWei = userSettings.GetBoomAmountInWei(pair.ChainID).BigInt()
 	}
-	data, err := a.packBuyData(requestID, pair.GetToken().ContractAddress, pair, user, buyValueInWei, minimalOutAmountInWei)
+
+	route, err := a.routeBuy(ctx, pair, buyValueInWei, true)
 	if err != nil {
 		return err
 	}
 
-	gasEst, err := a.gethService.EstimateGas(ctx, pair.ChainID, userWalletAddress, routerAddress, data, buyValueInWei)
+	nonce, err := a.getNextNonce(ctx, pair.ChainID, user)
 	if err != nil {
 		return err
 	}
 
-	nonce, err := a.getNextNonce(ctx, pair.ChainID, user)
+	gasPriceInWei := suggestedGasPrice
+	if gasPriceInWei == nil {
+		gasPriceInWei, err = a.gethService.GetGasPrice(ctx, pair.ChainID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the suggested gas limit was estimated for the requested pair alone
+	gasLimit := suggestedGasLimit
+	if len(route.Legs) > 1 || route.Legs[0].Pair.ID != pairID {
+		gasLimit = nil
+	}
+
+	// every leg is packed and its gas estimated before any is submitted, so a leg that cannot go through stops the
+	// buy before the others take the wallet's nonces
+	buys := make([]*buyTransaction, 0, len(route.Legs))
+	for i, leg := range route.Legs {
+		buy, err := a.buildBuyTransaction(ctx, user, leg.Pair, leg.AmountIn, route.MinimalOut(leg, minimalOutAmountInWei),
+			nonce+uint64(i), gasPriceInWei, gasLimit)
+		if err != nil {
+			return err
+		}
+		buys = append(buys, buy)
+	}
+
+	submitted := make([]string, 0, len(buys))
+	for i, buy := range buys {
+		err = a.submitBuyTransaction(ctx, user, jwt, clientIp, buy)
+		if err == nil {
+			submitted = append(submitted, buy.requestID)
+			continue
+		}
+		if len(submitted) == 0 {
+			return err
+		}
+
+		// the legs already submitted go through, the error names them so the caller does not retry the whole buy
+		go a.pollingManager.StartPolling(ctx, user.ID, jwt)
+		return common.NewRuntimeError(fmt.Errorf("buy leg %d of %d failed after requests %s were submitted: %v",
+			i+1, len(buys), strings.Join(submitted, ", "), err))
+	}
+
+	go a.pollingManager.StartPolling(ctx, user.ID, jwt)
+
+	return nil
+}
+
+// buyTransaction is a buy packed and ready to submit.
+type buyTransaction struct {
+	requestID string
+	pair      *model.DexPair
+	amountIn  *big.Int
+	txn       mpc.Transaction
+}
+
+// buildBuyTransaction packs one buy, the gas limit is estimated when gasLimit is nil.
+func (a *evmTradeService) buildBuyTransaction(
+	ctx context.Context,
+	user *model.User,
+	pair *model.DexPair,
+	buyValueInWei, minimalOutAmountInWei *big.Int,
+	nonce uint64,
+	gasPriceInWei, gasLimit *big.Int,
+) (*buyTransaction, businesserror.XSpaceBusinessError) {
+	routerAddress, err := a.getRouterAddress(ctx, pair)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	gasPriceInWei, err := a.gethService.GetGasPrice(ctx, pair.ChainID)
+	requestID := uuid.New().String()
+	data, err := a.packBuyData(ctx, requestID, pair.GetToken().ContractAddress, pair, user, buyValueInWei, minimalOutAmountInWei)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	userWalletAddress := user.GetWalletAddress(pair.ChainID)
+	gasEst, err := a.gethService.EstimateGas(ctx, pair.ChainID, userWalletAddress, routerAddress, data, buyValueInWei)
+	if err != nil {
+		return nil, err
 	}
 
 	chainID, _ := strconv.Atoi(pair.ChainID)
@@ -644,29 +870,32 @@ func (a *evmTradeService) BuyPairByID(ctx context.Context, userID, pairID, jwt s
 		Value:    fmt.Sprintf("0x%x", buyValueInWei),
 	}
 
-	if suggestedGasPrice != nil {
-		txn.GasPrice = hexutil.EncodeUint64(suggestedGasPrice.Uint64())
+	if gasLimit != nil {
+		txn.GasLimit = hexutil.EncodeUint64(gasLimit.Uint64())
 	}
 
-	if suggestedGasLimit != nil {
-		txn.GasLimit = hexutil.EncodeUint64(suggestedGasLimit.Uint64())
-	}
+	return &buyTransaction{
+		requestID: requestID,
+		pair:      pair,
+		amountIn:  buyValueInWei,
+		txn:       txn,
+	}, nil
+}
 
-	returnedID, err := a.cutonomyService.SubmitTransaction(ctx, requestID, jwt, txn)
+func (a *evmTradeService) submitBuyTransaction(ctx context.Context, user *model.User, jwt, clientIp string, buy *buyTransaction) businesserror.XSpaceBusinessError {
+	returnedID, err := a.cutonomyService.SubmitTransaction(ctx, buy.requestID, jwt, buy.txn)
 	if err != nil {
 		return err
 	}
 
-	requestLog := model.NewRequestLog(requestID, returnedID, userID, model.RequestBusinessTypeBuyToken, buyValueInWei.String(), clientIp, utils2.Ref(pair.GetToken().ID), txn)
+	requestLog := model.NewRequestLog(buy.requestID, returnedID, user.ID, model.RequestBusinessTypeBuyToken, buy.amountIn.String(), clientIp, utils2.Ref(buy.pair.GetToken().ID), buy.txn)
 	_ = a.logRepository.CreateRequestLog(ctx, requestLog)
 
-	go a.pollingManager.StartPolling(ctx, user.ID, jwt)
-
-	return err
+	return nil
 }
 
-func (a *evmTradeService) packApproveData(chainID string, amountInWei *big.Int) ([]byte, businesserror.XSpaceBusinessError) {
-	routerAddress, bizErr := a.gethService.GetKaboomRouterAddress(chainID)
+func (a *evmTradeService) packApproveData(ctx context.Context, pair *model.DexPair, amountInWei *big.Int) ([]byte, businesserror.XSpaceBusinessError) {
+	routerAddress, bizErr := a.getRouterAddress(ctx, pair)
 	if bizErr != nil {
 		return nil, bizErr
 	}
@@ -687,12 +916,17 @@ func (a *evmTradeService) packApproveData(chainID string, amountInWei *big.Int)
 	return raw, nil
 }
 
-func (a *evmTradeService) packBuyData(requestID, tokenAddress string, dexPair *model.DexPair, user *model.User,
+func (a *evmTradeService) packBuyData(ctx context.Context, requestID, tokenAddress string, dexPair *model.DexPair, user *model.User,
 	amountInWei, minimalOutAmountInWei *big.Int) ([]byte, businesserror.XSpaceBusinessError) {
 	userSettings := user.GetUserSettingsByChainID(dexPair.ChainID)
 
 	if minimalOutAmountInWei == nil {
-		expectTokenAmountOutWei := decimal.NewFromBigInt(dexPair.GetBuyAmountOut(amountInWei), 0)
+		amountOut, bizErr := a.getBuyAmountOut(ctx, dexPair, amountInWei)
+		if bizErr != nil {
+			return nil, bizErr
+		}
+
+		expectTokenAmountOutWei := decimal.NewFromBigInt(amountOut, 0)
 		expectTokenAmountOutWeiWithSlippage :=
 			expectTokenAmountOutWei.
 				Div(decimal.NewFromInt(model.PercentageBase)).
@@ -700,6 +934,27 @@ func (a *evmTradeService) packBuyData(requestID, tokenAddress string, dexPair *m
 		minimalOutAmountInWei = expectTokenAmountOutWeiWithSlippage.BigInt()
 	}
 
+	// the v3 routers and the launchpad managers check the minimum against what they send, before the buy tax
+	if isV3PairType(dexPair.Type) || isLaunchpadPairType(dexPair.Type) {
+		poolMinimalOut, err := buyTax(dexPair).RequiredOut(minimalOutAmountInWei)
+		if err != nil {
+			return nil, common.NewRuntimeError(err)
+		}
+
+		if isV3PairType(dexPair.Type) {
+			return a.v3Pools.PackBuyData(dexPair, user.GetWalletAddress(dexPair.ChainID), amountInWei, poolMinimalOut)
+		}
+		return a.launchpads.PackBuyData(dexPair, user.GetWalletAddress(dexPair.ChainID), amountInWei, poolMinimalOut)
+	}
+
+	if !isNativeQuoted(dexPair) {
+		nativeAddress, bizErr := a.getNativeAddress(ctx, dexPair.ChainID)
+		if bizErr != nil {
+			return nil, bizErr
+		}
+		return a.quoteTokens.PackBuyData(dexPair, nativeAddress, user.GetWalletAddress(dexPair.ChainID), minimalOutAmountInWei)
+	}
+
 	ddl := time.Now().Add(10 * time.Minute).UnixMilli()
 	raw, err := a.kaboomRouterAbi.Pack(
 		"swapExactETHForTokensSupportingFeeOnTransferTokens",
@@ -715,11 +970,16 @@ func (a *evmTradeService) packBuyData(requestID, tokenAddress string, dexPair *m
 	return raw, nil
 }
 
-func (a *evmTradeService) packSellData(requestID, tokenAddress string, dexPair *model.DexPair, user *model.User, sellAmountInWei, minimalOutAmountInWei *big.Int) ([]byte, businesserror.XSpaceBusinessError) {
+func (a *evmTradeService) packSellData(ctx context.Context, requestID, tokenAddress string, dexPair *model.DexPair, user *model.User, sellAmountInWei, minimalOutAmountInWei *big.Int) ([]byte, businesserror.XSpaceBusinessError) {
 	userSettings := user.GetUserSettingsByChainID(dexPair.ChainID)
 
 	if minimalOutAmountInWei == nil {
-		expectETHAmountOutWei := decimal.NewFromBigInt(dexPair.GetSellAmountOut(sellAmountInWei), 0)
+		amountOut, bizErr := a.getSellAmountOut(ctx, dexPair, sellAmountInWei)
+		if bizErr != nil {
+			return nil, bizErr
+		}
+
+		expectETHAmountOutWei := decimal.NewFromBigInt(amountOut, 0)
 		expectETHAmountOutWeiWithSlippage :=
 			expectETHAmountOutWei.
 				Div(decimal.NewFromInt(model.PercentageBase)).
@@ -727,6 +987,26 @@ func (a *evmTradeService) packSellData(requestID, tokenAddress string, dexPair *
 		minimalOutAmountInWei = expectETHAmountOutWeiWithSlippage.BigInt()
 	}
 
+	if isV3PairType(dexPair.Type) {
+		routerAddress, bizErr := a.chains.DexRouterAddress(ctx, dexPair.ChainID, dexPair.Type)
+		if bizErr != nil {
+			return nil, bizErr
+		}
+		return a.v3Pools.PackSellData(dexPair, routerAddress, user.GetWalletAddress(dexPair.ChainID), sellAmountInWei, minimalOutAmountInWei)
+	}
+
+	if isLaunchpadPairType(dexPair.Type) {
+		return a.launchpads.PackSellData(dexPair, sellAmountInWei, minimalOutAmountInWei)
+	}
+
+	if !isNativeQuoted(dexPair) {
+		nativeAddress, bizErr := a.getNativeAddress(ctx, dexPair.ChainID)
+		if bizErr != nil {
+			return nil, bizErr
+		}
+		return a.quoteTokens.PackSellData(dexPair, nativeAddress, user.GetWalletAddress(dexPair.ChainID), sellAmountInWei, minimalOutAmountInWei)
+	}
+
 	ddl := time.Now().Add(10 * time.Minute).UnixMilli()
 	raw, err := a.kaboomRouterAbi.Pack(
 		"swapExactTokensForETHSupportingFeeOnTransferTokens",
@@ -749,7 +1029,7 @@ func (a *evmTradeService) pilotRunSellTransaction(
 	pair *model.DexPair,
 	sellValueInWei *big.Int,
 ) businesserror.XSpaceBusinessError {
-	data, err := a.packApproveData(pair.ChainID, sellValueInWei)
+	data, err := a.packApproveData(ctx, pair, sellValueInWei)
 	if err != nil {
 		return err
 	}
@@ -770,13 +1050,13 @@ func (a *evmTradeService) submitSellTransaction(
 	sellValueInWei *big.Int,
 	minimalOutAmountInWei *big.Int,
 ) businesserror.XSpaceBusinessError {
-	routerAddress, err := a.gethService.GetKaboomRouterAddress(pair.ChainID)
+	routerAddress, err := a.getRouterAddress(ctx, pair)
 	if err != nil {
 		return err
 	}
 
 	requestID := uuid.New().String()
-	data, err := a.packSellData(requestID, pair.GetToken().ContractAddress, pair, user, sellValueInWei, minimalOutAmountInWei)
+	data, err := a.packSellData(ctx, requestID, pair.GetToken().ContractAddress, pair, user, sellValueInWei, minimalOutAmountInWei)
 	if err != nil {
 		return err
 	}
@@ -823,6 +1103,166 @@ func (a *evmTradeService) submitSellTransaction(
 	return nil
 }
 
+// isKaboomRouted tells whether the pair trades through the kaboom router, which only supports native quoted v2 pairs.
+func isKaboomRouted(pair *model.DexPair) bool {
+	return !isV3PairType(pair.Type) && !isLaunchpadPairType(pair.Type) && isNativeQuoted(pair)
+}
+
+// isQuoteTokenRouted tells whether the pair is a v2 pair quoted in a whitelisted token, traded through the dex router
+// along BuyPath and SellPath.
+func isQuoteTokenRouted(pair *model.DexPair) bool {
+	return !isV3PairType(pair.Type) && !isLaunchpadPairType(pair.Type) && !isNativeQuoted(pair)
+}
+
+func getBuyMethod(pair *model.DexPair) string {
+	if isV3PairType(pair.Type) {
+		return "multicall"
+	}
+	if isLaunchpadPairType(pair.Type) {
+		return "buyTokenAMAP"
+	}
+	return "swapExactETHForTokensSupportingFeeOnTransferTokens"
+}
+
+func getSellMethod(pair *model.DexPair) string {
+	if isV3PairType(pair.Type) {
+		return "multicall"
+	}
+	if isLaunchpadPairType(pair.Type) {
+		return "sellToken"
+	}
+	return "swapExactTokensForETHSupportingFeeOnTransferTokens"
+}
+
+func (a *evmTradeService) getRouterAddress(ctx context.Context, pair *model.DexPair) (string, businesserror.XSpaceBusinessError) {
+	if isLaunchpadPairType(pair.Type) {
+		return a.launchpads.GetManagerAddress(pair.ChainID, pair.Type)
+	}
+
+	if isV3PairType(pair.Type) || !isNativeQuoted(pair) {
+		return a.chains.DexRouterAddress(ctx, pair.ChainID, pair.Type)
+	}
+
+	return a.chains.KaboomRouterAddress(ctx, pair.ChainID)
+}
+
+func (a *evmTradeService) getNativeAddress(ctx context.Context, chainID string) (string, businesserror.XSpaceBusinessError) {
+	nativeToken, err := a.chains.NativeToken(ctx, chainID)
+	if err != nil {
+		return "", err
+	}
+
+	return nativeToken.ContractAddress, nil
+}
+
+// getBuyAmountOut and the other quoting helpers return native or token wei, hopping through the quote token when needed.
+// Every venue quotes what the wallet receives or has to send, the measured transfer taxes included, minimums
+// are only turned into what each venue checks when packing.
+func (a *evmTradeService) getBuyAmountOut(ctx context.Context, pair *model.DexPair, amountInWei *big.Int) (*big.Int, businesserror.XSpaceBusinessError) {
+	if isV3PairType(pair.Type) || isLaunchpadPairType(pair.Type) {
+		poolOut, err := a.quotePool(ctx, pair, true, true, amountInWei)
+		if err != nil {
+			return nil, err
+		}
+		return buyTax(pair).ReceivedOut(poolOut), nil
+	}
+
+	if !isNativeQuoted(pair) {
+		nativeAddress, err := a.getNativeAddress(ctx, pair.ChainID)
+		if err != nil {
+			return nil, err
+		}
+		quoteAmount, err := a.quoteTokens.NativeToQuoteOut(ctx, pair, nativeAddress, amountInWei)
+		if err != nil {
+			return nil, err
+		}
+		return v2BuyAmountOut(pair, quoteAmount), nil
+	}
+
+	return v2BuyAmountOut(pair, amountInWei), nil
+}
+
+func (a *evmTradeService) getBuyAmountIn(ctx context.Context, pair *model.DexPair, amountOutWei *big.Int) (*big.Int, businesserror.XSpaceBusinessError) {
+	if isV3PairType(pair.Type) || isLaunchpadPairType(pair.Type) {
+		poolOut, err := buyTax(pair).RequiredOut(amountOutWei)
+		if err != nil {
+			return nil, common.NewRuntimeError(err)
+		}
+		return a.quotePool(ctx, pair, true, false, poolOut)
+	}
+
+	quoteIn, err := v2BuyAmountIn(pair, amountOutWei)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isNativeQuoted(pair) {
+		nativeAddress, err := a.getNativeAddress(ctx, pair.ChainID)
+		if err != nil {
+			return nil, err
+		}
+		return a.quoteTokens.NativeInForQuoteOut(ctx, pair, nativeAddress, quoteIn)
+	}
+
+	return quoteIn, nil
+}
+
+func (a *evmTradeService) getSellAmountOut(ctx context.Context, pair *model.DexPair, amountInWei *big.Int) (*big.Int, businesserror.XSpaceBusinessError) {
+	if isV3PairType(pair.Type) || isLaunchpadPairType(pair.Type) {
+		return a.quotePool(ctx, pair, false, true, sellTax(pair).SentIn(amountInWei))
+	}
+
+	if !isNativeQuoted(pair) {
+		nativeAddress, err := a.getNativeAddress(ctx, pair.ChainID)
+		if err != nil {
+			return nil, err
+		}
+		return a.quoteTokens.QuoteToNativeOut(ctx, pair, nativeAddress, v2SellAmountOut(pair, amountInWei))
+	}
+
+	return v2SellAmountOut(pair, amountInWei), nil
+}
+
+func (a *evmTradeService) getSellAmountIn(ctx context.Context, pair *model.DexPair, amountOutWei *big.Int) (*big.Int, businesserror.XSpaceBusinessError) {
+	if isV3PairType(pair.Type) || isLaunchpadPairType(pair.Type) {
+		poolIn, err := a.quotePool(ctx, pair, false, false, amountOutWei)
+		if err != nil {
+			return nil, err
+		}
+
+		amountIn, basicErr := sellTax(pair).RequiredIn(poolIn)
+		if basicErr != nil {
+			return nil, common.NewRuntimeError(basicErr)
+		}
+		return amountIn, nil
+	}
+
+	if !isNativeQuoted(pair) {
+		nativeAddress, err := a.getNativeAddress(ctx, pair.ChainID)
+		if err != nil {
+			return nil, err
+		}
+		quoteAmount, err := a.quoteTokens.QuoteInForNativeOut(ctx, pair, nativeAddress, amountOutWei)
+		if err != nil {
+			return nil, err
+		}
+		return v2SellAmountIn(pair, quoteAmount)
+	}
+
+	return v2SellAmountIn(pair, amountOutWei)
+}
+
+// quotePool quotes a v3 or launchpad pair in the amounts the pool itself sends and takes, before transfer taxes.
+func (a *evmTradeService) quotePool(ctx context.Context, pair *model.DexPair, isBuy, exactIn bool, amount *big.Int) (*big.Int, businesserror.XSpaceBusinessError) {
+	if isLaunchpadPairType(pair.Type) {
+		return a.launchpads.Quote(ctx, pair, isBuy, exactIn, amount)
+	}
+
+	// buying swaps the quote token for the token
+	zeroForOne := isTokenToken0(pair) != isBuy
+	return a.v3Pools.Quote(ctx, pair, zeroForOne, exactIn, amount)
+}
+
 func (a *evmTradeService) getNextNonce(c context.Context, chainID string, user *model.User) (uint64, businesserror.XSpaceBusinessError) {
 	if user == nil {
 		return 0, nil
@@ -843,11 +1283,22 @@ func (a *evmTradeService) getNextNonce(c context.Context, chainID string, user *
 	return nonce, nil
 }
 
+// NewEvmTradeService builds the service with market caches of its own, see NewEvmTradeServiceWithMarketData.
 func NewEvmTradeService(
 	gethService evm.GethService,
 	assetRepository repository.AssetRepository,
 	tokenBalanceRepository repository.TokenBalanceRepository,
 	eventLogRepository repository.EventLogRepository,
+) TradeService {
+	return NewEvmTradeServiceWithMarketData(gethService, assetRepository, tokenBalanceRepository, eventLogRepository, NewMarketData(gethService, assetRepository))
+}
+
+func NewEvmTradeServiceWithMarketData(
+	gethService evm.GethService,
+	assetRepository repository.AssetRepository,
+	tokenBalanceRepository repository.TokenBalanceRepository,
+	eventLogRepository repository.EventLogRepository,
+	market *MarketData,
 ) TradeService {
 	path, _ := filepath.Abs("./resource/kaboom_router_abi.json")
 	file, err := os.ReadFile(path)
@@ -861,6 +1312,9 @@ func NewEvmTradeService(
 	}
 
 	cutonomyService := mpc.NewWalletService()
+	quoteTokens := market.quoteTokens
+	v3Pools := newV3PoolReader(gethService)
+	launchpads := newLaunchpadReader(gethService)
 
 	return &evmTradeService{
 		assetRepository:        assetRepository,
@@ -871,5 +1325,11 @@ func NewEvmTradeService(
 		gethService:            gethService,
 		pollingManager:         NewMpcPollingManager(cutonomyService, eventLogRepository),
 		logRepository:          eventLogRepository,
+		v3Pools:                v3Pools,
+		quoteTokens:            quoteTokens,
+		usdOracle:              market.usdOracle,
+		depth:                  newLiquidityDepthCalculator(v3Pools, quoteTokens, launchpads),
+		launchpads:             launchpads,
+		chains:                 market.chains,
 	}
 }
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"context"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/common/logger"
+	"github.com/cross-space-official/kaboom-service/model"
+	"math/big"
+)
+
+const (
+	// routeMaxPools caps how many of the token's pools a trade is quoted against.
+	routeMaxPools = 3
+	// routeSplitSlices is how finely a buy is divided when it is split across pools.
+	routeSplitSlices = 4
+	// routeSplitMinGain is what a split has to gain over the best single pool, in model.PercentageBase units.
+	routeSplitMinGain = model.PercentageBase / 200
+)
+
+// routeLeg is the part of a trade sent to one pool.
+type routeLeg struct {
+	Pair        *model.DexPair
+	AmountIn    *big.Int
+	ExpectedOut *big.Int
+}
+
+type tradeRoute struct {
+	Legs        []*routeLeg
+	ExpectedOut *big.Int
+}
+
+type routeQuoter func(ctx context.Context, pair *model.DexPair, amountInWei *big.Int) (*big.Int, businesserror.XSpaceBusinessError)
+
+// MinimalOut shares the caller's minimal output between the legs by their expected output, nil stays nil
+// so every leg applies the user slippage to its own quote.
+func (r *tradeRoute) MinimalOut(leg *routeLeg, minimalOutAmountInWei *big.Int) *big.Int {
+	if minimalOutAmountInWei == nil || len(r.Legs) == 1 || r.ExpectedOut.Sign() == 0 {
+		return minimalOutAmountInWei
+	}
+
+	minimalOut := new(big.Int).Mul(minimalOutAmountInWei, leg.ExpectedOut)
+	return minimalOut.Div(minimalOut, r.ExpectedOut)
+}
+
+// routeBuy picks the pool giving the most tokens for amountInWei native, with split the buy may be spread over pools.
+func (a *evmTradeService) routeBuy(ctx context.Context, pair *model.DexPair, amountInWei *big.Int, split bool) (*tradeRoute, businesserror.XSpaceBusinessError) {
+	candidates := a.routeCandidates(ctx, pair)
+
+	route, err := a.routeBest(ctx, candidates, a.getBuyAmountOut, amountInWei)
+	if err != nil || !split {
+		return route, err
+	}
+
+	splittable := make([]*model.DexPair, 0, len(candidates))
+	for _, candidate := range candidates {
+		if isSplittablePool(candidate) {
+			splittable = append(splittable, candidate)
+		}
+	}
+	return a.routeSplit(ctx, splittable, a.getBuyAmountOut, amountInWei, route), nil
+}
+
+// isSplittablePool tells whether the pool quotes from a snapshot, the stored v2 reserves or the cached v3 pool read,
+// so the repeated quotes of routeSplit cost no calls. Launchpad and quote token routed pools read the chain per quote.
+func isSplittablePool(pair *model.DexPair) bool {
+	if isLaunchpadPairType(pair.Type) {
+		return false
+	}
+	return isV3PairType(pair.Type) || isNativeQuoted(pair)
+}
+
+// routeSell picks the pool paying the most native for amountInWei tokens. Other pools only compete with the requested
+// pair once the wallet has approved their router for the amount, so an approval composed for the pair stays valid.
+func (a *evmTradeService) routeSell(ctx context.Context, pair *model.DexPair, walletAddress string, amountInWei *big.Int) (*tradeRoute, businesserror.XSpaceBusinessError) {
+	candidates := []*model.DexPair{pair}
+	for _, candidate := range a.routeCandidates(ctx, pair)[1:] {
+		routerAddress, err := a.getRouterAddress(ctx, candidate)
+		if err != nil {
+			continue
+		}
+
+		allowance, err := a.gethService.GetTokenApproveAmount(ctx, candidate.ChainID, candidate.GetToken().ContractAddress, walletAddress, routerAddress)
+		if err != nil || allowance.Cmp(amountInWei) < 0 {
+			continue
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	return a.routeBest(ctx, candidates, a.getSellAmountOut, amountInWei)
+}
+
+// routeCandidates returns the requested pair followed by the deepest other published pools of its token.
+func (a *evmTradeService) routeCandidates(ctx context.Context, pair *model.DexPair) []*model.DexPair {
+	candidates := []*model.DexPair{pair}
+
+	pairs, err := a.assetRepository.RetrievePairsByTokenID(ctx, pair.GetToken().ID)
+	if err != nil {
+		logger.GetLoggerEntry(ctx).
+			WithField("pair_id", pair.ID).
+			Errorf("error retrieving token pools, trading the requested pair, %v", err)
+		return candidates
+	}
+
+	for _, pool := range rankTokenPools(ctx, a.quoteTokens, pairs) {
+		if len(candidates) == routeMaxPools {
+			break
+		}
+		if pool.Pair.ID != pair.ID && pool.Pair.ChainID == pair.ChainID {
+			candidates = append(candidates, pool.Pair)
+		}
+	}
+	return candidates
+}
+
+// routeBest quotes the whole amount on every candidate and keeps the best one, candidates failing to quote are skipped.
+// The first quoting error is returned when none of them quotes.
+func (a *evmTradeService) routeBest(ctx context.Context, candidates []*model.DexPair, quote routeQuoter, amountInWei *big.Int) (*tradeRoute, businesserror.XSpaceBusinessError) {
+	var best *routeLeg
+	var firstErr businesserror.XSpaceBusinessError
+	for _, pair := range candidates {
+		amountOut, err := quote(ctx, pair, amountInWei)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			logger.GetLoggerEntry(ctx).
+				WithField("pair_id", pair.ID).
+				Warnf("error quoting route candidate, %v", err)
+			continue
+		}
+
+		if best == nil || amountOut.Cmp(best.ExpectedOut) > 0 {
+			best = &routeLeg{Pair: pair, AmountIn: amountInWei, ExpectedOut: amountOut}
+		}
+	}
+	if best == nil {
+		return nil, firstErr
+	}
+
+	return &tradeRoute{Legs: []*routeLeg{best}, ExpectedOut: best.ExpectedOut}, nil
+}
+
+// routeSplit hands the amount out slice by slice, each slice to the candidate whose output grows the most.
+// The split replaces single only when it gains at least routeSplitMinGain.
+func (a *evmTradeService) routeSplit(ctx context.Context, candidates []*model.DexPair, quote routeQuoter, amountInWei *big.Int, single *tradeRoute) *tradeRoute {
+	slice := new(big.Int).Div(amountInWei, big.NewInt(routeSplitSlices))
+	if len(candidates) < 2 || slice.Sign() == 0 {
+		return single
+	}
+
+	allocated := make([]*big.Int, len(candidates))
+	outs := make([]*big.Int, len(candidates))
+	failed := make([]bool, len(candidates))
+	for i := range candidates {
+		allocated[i] = big.NewInt(0)
+		outs[i] = big.NewInt(0)
+	}
+
+	remaining := new(big.Int).Set(amountInWei)
+	for i := 0; i < routeSplitSlices; i++ {
+		step := slice
+		if i == routeSplitSlices-1 {
+			step = remaining
+		}
+
+		chosen := -1
+		var chosenOut, chosenGain *big.Int
+		for j, pair := range candidates {
+			if failed[j] {
+				continue
+			}
+
+			amountOut, err := quote(ctx, pair, new(big.Int).Add(allocated[j], step))
+			if err != nil {
+				failed[j] = true
+				continue
+			}
+
+			gain := new(big.Int).Sub(amountOut, outs[j])
+			if chosen < 0 || gain.Cmp(chosenGain) > 0 {
+				chosen, chosenOut, chosenGain = j, amountOut, gain
+			}
+		}
+		if chosen < 0 {
+			return single
+		}
+
+		allocated[chosen].Add(allocated[chosen], step)
+		outs[chosen] = chosenOut
+		remaining = new(big.Int).Sub(remaining, step)
+	}
+
+	split := &tradeRoute{ExpectedOut: big.NewInt(0)}
+	for i, pair := range candidates {
+		if allocated[i].Sign() == 0 {
+			continue
+		}
+		split.Legs = append(split.Legs, &routeLeg{Pair: pair, AmountIn: allocated[i], ExpectedOut: outs[i]})
+		split.ExpectedOut.Add(split.ExpectedOut, outs[i])
+	}
+	if len(split.Legs) < 2 {
+		return single
+	}
+
+	threshold := new(big.Int).Mul(single.ExpectedOut, big.NewInt(model.PercentageBase+routeSplitMinGain))
+	threshold.Div(threshold, big.NewInt(model.PercentageBase))
+	if split.ExpectedOut.Cmp(threshold) < 0 {
+		return single
+	}
+	return split
+}
@@ -0,0 +1,378 @@
+package core
+
+import (
+	"errors"
+	"math/big"
+)
+
+const (
+	V3MinTick = -887272
+	V3MaxTick = 887272
+
+	v3FeeBase     = 1_000_000
+	v3MaxSwapStep = 1000
+)
+
+var (
+	ErrV3InsufficientLiquidity = errors.New("insufficient liquidity in v3 pool")
+
+	q96               = new(big.Int).Lsh(big.NewInt(1), 96)
+	q128              = new(big.Int).Lsh(big.NewInt(1), 128)
+	maxUint256        = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	v3MinSqrtRatio, _ = new(big.Int).SetString("4295128739", 10)
+	v3MaxSqrtRatio, _ = new(big.Int).SetString("1461446703485210103287273052203988822378723970342", 10)
+
+	// tickRatioMultipliers are the TickMath.sol constants for each bit of the absolute tick, starting at 0x2.
+	tickRatioMultipliers = []string{
+		"fff97272373d413259a46990580e213a",
+		"fff2e50f5f656932ef12357cf3c7fdcc",
+		"ffe5caca7e10e4e61c3624eaa0941cd0",
+		"ffcb9843d60f6159c9db58835c926644",
+		"ff973b41fa98c081472e6896dfb254c0",
+		"ff2ea16466c96a3843ec78b326b52861",
+		"fe5dee046a99a2a811c461f1969c3053",
+		"fcbe86c7900a88aedcffc83b479aa3a4",
+		"f987a7253ac413176f2b074cf7815e54",
+		"f3392b0822b70005940c7a398e4b70f3",
+		"e7159475a2c29b7443b29c7fa6e889d9",
+		"d097f3bdfd2022b8845ad8f792aa5825",
+		"a9f746462d870fdf8a65dc1f90e061e5",
+		"70d869a156d2a1b890bb3df62baf32f7",
+		"31be135f97d08fd981231505542fcfa6",
+		"9aa508b5b7a84e1c677de54f3e99bc9",
+		"5d6af8dedb81196699c329225ee604",
+		"2216e584f5fa1ea926041bedfe98",
+		"48a170391f7dc42444e8fa2",
+	}
+)
+
+// V3TickSource exposes the initialized ticks of a pool, it is usually backed by tickBitmap/ticks calls.
+type V3TickSource interface {
+	// NextInitializedTickWithinOneWord mirrors TickBitmap.nextInitializedTickWithinOneWord.
+	NextInitializedTickWithinOneWord(tick int, lte bool) (int, bool, error)
+	LiquidityNet(tick int) (*big.Int, error)
+}
+
+type V3PoolState struct {
+	SqrtPriceX96 *big.Int
+	Tick         int
+	Liquidity    *big.Int
+	// Fee is in hundredths of a bip, e.g. 2500 for 0.25%.
+	Fee         uint32
+	TickSpacing int
+}
+
+type V3SwapResult struct {
+	AmountIn          *big.Int
+	AmountOut         *big.Int
+	SqrtPriceX96After *big.Int
+	TickAfter         int
+}
+
+// V3SwapExactInput simulates UniswapV3Pool.swap for a positive amountIn without a price limit.
+func V3SwapExactInput(state V3PoolState, ticks V3TickSource, zeroForOne bool, amountIn *big.Int) (*V3SwapResult, error) {
+	return v3Swap(state, ticks, zeroForOne, new(big.Int).Set(amountIn), nil)
+}
+
+// V3SwapExactOutput returns the input needed to receive amountOut.
+func V3SwapExactOutput(state V3PoolState, ticks V3TickSource, zeroForOne bool, amountOut *big.Int) (*V3SwapResult, error) {
+	return v3Swap(state, ticks, zeroForOne, new(big.Int).Neg(amountOut), nil)
+}
+
+// V3SwapToPrice returns the input, fee included, that moves the pool price to sqrtPriceTargetX96.
+func V3SwapToPrice(state V3PoolState, ticks V3TickSource, zeroForOne bool, sqrtPriceTargetX96 *big.Int) (*V3SwapResult, error) {
+	if (zeroForOne && sqrtPriceTargetX96.Cmp(state.SqrtPriceX96) >= 0) || (!zeroForOne && sqrtPriceTargetX96.Cmp(state.SqrtPriceX96) <= 0) {
+		return nil, errors.New("sqrt price target is on the wrong side of the current price")
+	}
+
+	limit := sqrtPriceTargetX96
+	if limit.Cmp(v3MinSqrtRatio) <= 0 {
+		limit = new(big.Int).Add(v3MinSqrtRatio, big.NewInt(1))
+	} else if limit.Cmp(v3MaxSqrtRatio) >= 0 {
+		limit = new(big.Int).Sub(v3MaxSqrtRatio, big.NewInt(1))
+	}
+
+	// an input no pool can absorb, the swap stops at the limit
+	unbounded := new(big.Int).Lsh(big.NewInt(1), 200)
+	return v3Swap(state, ticks, zeroForOne, unbounded, limit)
+}
+
+// v3Swap stops at sqrtPriceLimit when it is set, the remaining amount is then returned unswapped.
+func v3Swap(state V3PoolState, ticks V3TickSource, zeroForOne bool, amountSpecified, sqrtPriceLimit *big.Int) (*V3SwapResult, error) {
+	exactInput := amountSpecified.Sign() > 0
+
+	limited := sqrtPriceLimit != nil
+	if !limited {
+		sqrtPriceLimit = new(big.Int).Add(v3MinSqrtRatio, big.NewInt(1))
+		if !zeroForOne {
+			sqrtPriceLimit = new(big.Int).Sub(v3MaxSqrtRatio, big.NewInt(1))
+		}
+	}
+
+	remaining := new(big.Int).Set(amountSpecified)
+	amountIn := big.NewInt(0)
+	amountOut := big.NewInt(0)
+	sqrtPrice := new(big.Int).Set(state.SqrtPriceX96)
+	tick := state.Tick
+	liquidity := new(big.Int).Set(state.Liquidity)
+
+	for step := 0; remaining.Sign() != 0 && sqrtPrice.Cmp(sqrtPriceLimit) != 0; step++ {
+		if step >= v3MaxSwapStep {
+			return nil, ErrV3InsufficientLiquidity
+		}
+
+		sqrtPriceStart := new(big.Int).Set(sqrtPrice)
+		tickNext, initialized, err := ticks.NextInitializedTickWithinOneWord(tick, zeroForOne)
+		if err != nil {
+			return nil, err
+		}
+		if tickNext < V3MinTick {
+			tickNext = V3MinTick
+		} else if tickNext > V3MaxTick {
+			tickNext = V3MaxTick
+		}
+
+		sqrtPriceNext := GetSqrtRatioAtTick(tickNext)
+		sqrtPriceTarget := sqrtPriceNext
+		if (zeroForOne && sqrtPriceNext.Cmp(sqrtPriceLimit) < 0) || (!zeroForOne && sqrtPriceNext.Cmp(sqrtPriceLimit) > 0) {
+			sqrtPriceTarget = sqrtPriceLimit
+		}
+
+		var stepIn, stepOut, stepFee *big.Int
+		sqrtPrice, stepIn, stepOut, stepFee = v3ComputeSwapStep(sqrtPrice, sqrtPriceTarget, liquidity, remaining, state.Fee)
+
+		if exactInput {
+			remaining.Sub(remaining, stepIn).Sub(remaining, stepFee)
+		} else {
+			remaining.Add(remaining, stepOut)
+		}
+		amountIn.Add(amountIn, stepIn).Add(amountIn, stepFee)
+		amountOut.Add(amountOut, stepOut)
+
+		if sqrtPrice.Cmp(sqrtPriceNext) == 0 {
+			if initialized {
+				liquidityNet, err := ticks.LiquidityNet(tickNext)
+				if err != nil {
+					return nil, err
+				}
+				if zeroForOne {
+					liquidity.Sub(liquidity, liquidityNet)
+				} else {
+					liquidity.Add(liquidity, liquidityNet)
+				}
+			}
+
+			tick = tickNext
+			if zeroForOne {
+				tick = tickNext - 1
+			}
+		} else if sqrtPrice.Cmp(sqrtPriceStart) != 0 {
+			tick = GetTickAtSqrtRatio(sqrtPrice)
+		}
+	}
+
+	if remaining.Sign() != 0 && !limited {
+		return nil, ErrV3InsufficientLiquidity
+	}
+
+	return &V3SwapResult{
+		AmountIn:          amountIn,
+		AmountOut:         amountOut,
+		SqrtPriceX96After: sqrtPrice,
+		TickAfter:         tick,
+	}, nil
+}
+
+// v3ComputeSwapStep mirrors SwapMath.computeSwapStep, a positive amountRemaining means exact input.
+func v3ComputeSwapStep(sqrtCurrent, sqrtTarget, liquidity, amountRemaining *big.Int, feePips uint32) (*big.Int, *big.Int, *big.Int, *big.Int) {
+	zeroForOne := sqrtCurrent.Cmp(sqrtTarget) >= 0
+	exactIn := amountRemaining.Sign() >= 0
+	fee := big.NewInt(int64(feePips))
+	feeComplement := big.NewInt(int64(v3FeeBase - feePips))
+
+	var sqrtNext, amountIn, amountOut *big.Int
+	if exactIn {
+		remainingLessFee := mulDiv(amountRemaining, feeComplement, big.NewInt(v3FeeBase))
+		if zeroForOne {
+			amountIn = getAmount0Delta(sqrtTarget, sqrtCurrent, liquidity, true)
+		} else {
+			amountIn = getAmount1Delta(sqrtCurrent, sqrtTarget, liquidity, true)
+		}
+
+		if remainingLessFee.Cmp(amountIn) >= 0 {
+			sqrtNext = sqrtTarget
+		} else {
+			sqrtNext = getNextSqrtPriceFromInput(sqrtCurrent, liquidity, remainingLessFee, zeroForOne)
+		}
+	} else {
+		if zeroForOne {
+			amountOut = getAmount1Delta(sqrtTarget, sqrtCurrent, liquidity, false)
+		} else {
+			amountOut = getAmount0Delta(sqrtCurrent, sqrtTarget, liquidity, false)
+		}
+
+		if new(big.Int).Neg(amountRemaining).Cmp(amountOut) >= 0 {
+			sqrtNext = sqrtTarget
+		} else {
+			sqrtNext = getNextSqrtPriceFromOutput(sqrtCurrent, liquidity, new(big.Int).Neg(amountRemaining), zeroForOne)
+		}
+	}
+
+	reachedTarget := sqrtTarget.Cmp(sqrtNext) == 0
+	if zeroForOne {
+		if !reachedTarget || !exactIn {
+			amountIn = getAmount0Delta(sqrtNext, sqrtCurrent, liquidity, true)
+		}
+		if !reachedTarget || exactIn {
+			amountOut = getAmount1Delta(sqrtNext, sqrtCurrent, liquidity, false)
+		}
+	} else {
+		if !reachedTarget || !exactIn {
+			amountIn = getAmount1Delta(sqrtCurrent, sqrtNext, liquidity, true)
+		}
+		if !reachedTarget || exactIn {
+			amountOut = getAmount0Delta(sqrtCurrent, sqrtNext, liquidity, false)
+		}
+	}
+
+	if !exactIn && amountOut.Cmp(new(big.Int).Neg(amountRemaining)) > 0 {
+		amountOut = new(big.Int).Neg(amountRemaining)
+	}
+
+	var feeAmount *big.Int
+	if exactIn && sqrtNext.Cmp(sqrtTarget) != 0 {
+		feeAmount = new(big.Int).Sub(amountRemaining, amountIn)
+	} else {
+		feeAmount = mulDivRoundingUp(amountIn, fee, feeComplement)
+	}
+
+	return sqrtNext, amountIn, amountOut, feeAmount
+}
+
+func getAmount0Delta(sqrtA, sqrtB, liquidity *big.Int, roundUp bool) *big.Int {
+	if sqrtA.Cmp(sqrtB) > 0 {
+		sqrtA, sqrtB = sqrtB, sqrtA
+	}
+
+	numerator1 := new(big.Int).Lsh(liquidity, 96)
+	numerator2 := new(big.Int).Sub(sqrtB, sqrtA)
+	if roundUp {
+		return divRoundingUp(mulDivRoundingUp(numerator1, numerator2, sqrtB), sqrtA)
+	}
+	return new(big.Int).Quo(mulDiv(numerator1, numerator2, sqrtB), sqrtA)
+}
+
+func getAmount1Delta(sqrtA, sqrtB, liquidity *big.Int, roundUp bool) *big.Int {
+	if sqrtA.Cmp(sqrtB) > 0 {
+		sqrtA, sqrtB = sqrtB, sqrtA
+	}
+
+	diff := new(big.Int).Sub(sqrtB, sqrtA)
+	if roundUp {
+		return mulDivRoundingUp(liquidity, diff, q96)
+	}
+	return mulDiv(liquidity, diff, q96)
+}
+
+func getNextSqrtPriceFromInput(sqrtPrice, liquidity, amountIn *big.Int, zeroForOne bool) *big.Int {
+	if zeroForOne {
+		return getNextSqrtPriceFromAmount0RoundingUp(sqrtPrice, liquidity, amountIn, true)
+	}
+	return getNextSqrtPriceFromAmount1RoundingDown(sqrtPrice, liquidity, amountIn, true)
+}
+
+func getNextSqrtPriceFromOutput(sqrtPrice, liquidity, amountOut *big.Int, zeroForOne bool) *big.Int {
+	if zeroForOne {
+		return getNextSqrtPriceFromAmount1RoundingDown(sqrtPrice, liquidity, amountOut, false)
+	}
+	return getNextSqrtPriceFromAmount0RoundingUp(sqrtPrice, liquidity, amountOut, false)
+}
+
+func getNextSqrtPriceFromAmount0RoundingUp(sqrtPrice, liquidity, amount *big.Int, add bool) *big.Int {
+	if amount.Sign() == 0 {
+		return new(big.Int).Set(sqrtPrice)
+	}
+
+	numerator1 := new(big.Int).Lsh(liquidity, 96)
+	product := new(big.Int).Mul(amount, sqrtPrice)
+	denominator := new(big.Int).Add(numerator1, product)
+	if !add {
+		denominator = new(big.Int).Sub(numerator1, product)
+	}
+	return mulDivRoundingUp(numerator1, sqrtPrice, denominator)
+}
+
+func getNextSqrtPriceFromAmount1RoundingDown(sqrtPrice, liquidity, amount *big.Int, add bool) *big.Int {
+	shifted := new(big.Int).Lsh(amount, 96)
+	if add {
+		return new(big.Int).Add(sqrtPrice, new(big.Int).Quo(shifted, liquidity))
+	}
+	return new(big.Int).Sub(sqrtPrice, divRoundingUp(shifted, liquidity))
+}
+
+// GetSqrtRatioAtTick mirrors TickMath.getSqrtRatioAtTick and returns a Q64.96 sqrt price.
+func GetSqrtRatioAtTick(tick int) *big.Int {
+	absTick := tick
+	if absTick < 0 {
+		absTick = -absTick
+	}
+
+	ratio := new(big.Int).Set(q128)
+	if absTick&0x1 != 0 {
+		ratio.SetString("fffcb933bd6fad37aa2d162d1a594001", 16)
+	}
+	for i, multiplier := range tickRatioMultipliers {
+		if absTick&(0x2<<uint(i)) != 0 {
+			m, _ := new(big.Int).SetString(multiplier, 16)
+			ratio.Mul(ratio, m).Rsh(ratio, 128)
+		}
+	}
+
+	if tick > 0 {
+		ratio.Quo(maxUint256, ratio)
+	}
+
+	remainder := new(big.Int).And(ratio, big.NewInt(0xffffffff))
+	ratio.Rsh(ratio, 32)
+	if remainder.Sign() != 0 {
+		ratio.Add(ratio, big.NewInt(1))
+	}
+	return ratio
+}
+
+// GetTickAtSqrtRatio returns the greatest tick whose sqrt ratio is lower or equal to sqrtPriceX96.
+func GetTickAtSqrtRatio(sqrtPriceX96 *big.Int) int {
+	low, high := V3MinTick, V3MaxTick
+	for low < high {
+		mid := low + (high-low+1)/2
+		if GetSqrtRatioAtTick(mid).Cmp(sqrtPriceX96) <= 0 {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+	return low
+}
+
+// V3SpotPrice returns token1 per token0 in raw units as price = (sqrtPriceX96 / 2^96)^2.
+func V3SpotPrice(sqrtPriceX96 *big.Int) *big.Rat {
+	numerator := new(big.Int).Mul(sqrtPriceX96, sqrtPriceX96)
+	denominator := new(big.Int).Mul(q96, q96)
+	return new(big.Rat).SetFrac(numerator, denominator)
+}
+
+func mulDiv(a, b, denominator *big.Int) *big.Int {
+	return new(big.Int).Quo(new(big.Int).Mul(a, b), denominator)
+}
+
+func mulDivRoundingUp(a, b, denominator *big.Int) *big.Int {
+	return divRoundingUp(new(big.Int).Mul(a, b), denominator)
+}
+
+func divRoundingUp(numerator, denominator *big.Int) *big.Int {
+	quotient, remainder := new(big.Int).QuoRem(numerator, denominator, new(big.Int))
+	if remainder.Sign() != 0 {
+		quotient.Add(quotient, big.NewInt(1))
+	}
+	return quotient
+}
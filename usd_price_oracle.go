@@ -0,0 +1,243 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/cross-space-official/common/businesserror"
+	"github.com/cross-space-official/common/logger"
+	"github.com/cross-space-official/kaboom-service/common"
+	"github.com/cross-space-official/kaboom-service/model"
+	"github.com/cross-space-official/kaboom-service/service/provider/evm"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	common2 "github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const (
+	usdPriceCacheTTL = 30 * time.Second
+	// twapWindow is the span pool samples are averaged over, twapMinSpan the span needed before the average is trusted.
+	twapWindow  = 30 * time.Minute
+	twapMinSpan = 5 * time.Minute
+	// twapMaxSampleAge marks the twap stale when no pool sample was taken recently.
+	twapMaxSampleAge = 2 * time.Minute
+)
+
+type USDPriceSource string
+
+const (
+	USDPriceSourceChainlink USDPriceSource = "chainlink"
+	USDPriceSourcePoolTWAP  USDPriceSource = "pool_twap"
+)
+
+type chainlinkFeed struct {
+	Address string
+	// MaxAge is the feed heartbeat plus some slack, older answers are stale.
+	MaxAge time.Duration
+}
+
+// nativeUSDFeeds are the chainlink native/usd aggregators per chain.
+var nativeUSDFeeds = map[string]chainlinkFeed{
+	"1":    {Address: "0x5f4eC3Df9cbd43714FE2740f5E3616155c5b8419", MaxAge: 75 * time.Minute},
+	"56":   {Address: "0x0567F2323251f0Aab15c8dFb1967E4e8A7D8aA1b", MaxAge: 75 * time.Minute},
+	"8453": {Address: "0x71041dddad3595F9CEd3DcCFBe3D1F4b0a16Bb70", MaxAge: 30 * time.Minute},
+}
+
+type USDPrice struct {
+	Price     decimal.Decimal
+	Source    USDPriceSource
+	UpdatedAt time.Time
+}
+
+type twapSample struct {
+	At    time.Time
+	Price decimal.Decimal
+}
+
+// chainUSDPrice is the cached price and twap samples of one chain, its lock is never held across a read.
+type chainUSDPrice struct {
+	mu         sync.Mutex
+	price      *USDPrice
+	cachedAt   time.Time
+	samples    []twapSample
+	refreshing bool
+}
+
+type usdPriceOracle struct {
+	aggregatorAbi abi.ABI
+	gethService   evm.GethService
+	quoteTokens   *quoteTokenService
+
+	mu     sync.Mutex
+	chains map[string]*chainUSDPrice
+}
+
+func (o *usdPriceOracle) chain(chainID string) *chainUSDPrice {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	state, ok := o.chains[chainID]
+	if !ok {
+		state = &chainUSDPrice{}
+		o.chains[chainID] = state
+	}
+	return state
+}
+
+// NativePrice returns the usd price of one native token, or nil when no source is fresh.
+// Callers must leave usd fields empty on nil rather than fall back to an old value.
+// While one caller refreshes the chain the others get the price cached before it, or nil once that is stale too.
+func (o *usdPriceOracle) NativePrice(ctx context.Context, chainID string) *USDPrice {
+	state := o.chain(chainID)
+
+	state.mu.Lock()
+	fresh := !state.cachedAt.IsZero() && time.Since(state.cachedAt) < usdPriceCacheTTL
+	if fresh || state.refreshing {
+		var price *USDPrice
+		if fresh {
+			price = state.price
+		}
+		state.mu.Unlock()
+		return price
+	}
+	state.refreshing = true
+	state.mu.Unlock()
+
+	defer func() {
+		state.mu.Lock()
+		state.refreshing = false
+		state.mu.Unlock()
+	}()
+
+	price, err := o.readChainlink(ctx, chainID)
+	if err != nil {
+		logger.GetLoggerEntry(ctx).
+			WithField("chain_id", chainID).
+			Errorf("error reading chainlink native price, %v", err)
+	}
+
+	// the pool is sampled even when chainlink answers so the twap is warm if the feed goes stale
+	spot, err := o.quoteTokens.NativePriceInUSD(ctx, chainID)
+	if err != nil {
+		logger.GetLoggerEntry(ctx).
+			WithField("chain_id", chainID).
+			Errorf("error reading pool native price, %v", err)
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	now := time.Now()
+	if err == nil {
+		state.samples = append(state.samples, twapSample{At: now, Price: spot})
+	}
+	twap := state.poolTWAP(now)
+	if price == nil {
+		price = twap
+	}
+
+	state.price = price
+	state.cachedAt = now
+	return price
+}
+
+// NativeToUSD converts a native wei amount, nil when the price is missing.
+func (o *usdPriceOracle) NativeToUSD(ctx context.Context, chainID string, amountInWei *big.Int) *decimal.Decimal {
+	price := o.NativePrice(ctx, chainID)
+	if price == nil || amountInWei == nil {
+		return nil
+	}
+
+	value := decimal.NewFromBigInt(amountInWei, 0).Div(model.GetChainNativeByID(chainID)).Mul(price.Price)
+	return &value
+}
+
+// tokenValueInNative values a token amount at the spot price of its last sync, the fully diluted value over the
+// total supply, nil when the token has not been priced.
+func tokenValueInNative(token model.Token, amount *big.Int) *big.Int {
+	totalSupply := bigIntOf(token.TotalSupply)
+	if amount == nil || totalSupply.Sign() == 0 {
+		return nil
+	}
+
+	return valueAtPrice(amount, new(big.Rat).SetFrac(bigIntOf(token.FullyDilutedValueInNative), totalSupply))
+}
+
+func (o *usdPriceOracle) readChainlink(ctx context.Context, chainID string) (*USDPrice, businesserror.XSpaceBusinessError) {
+	feed, ok := nativeUSDFeeds[chainID]
+	if !ok {
+		return nil, nil
+	}
+
+	client, err := o.gethService.GetClient(chainID)
+	if err != nil {
+		return nil, err
+	}
+
+	contract := bind.NewBoundContract(common2.HexToAddress(feed.Address), o.aggregatorAbi, client, client, client)
+	opts := &bind.CallOpts{Context: ctx}
+
+	var decimals, round []interface{}
+	if basicErr := contract.Call(opts, &decimals, "decimals"); basicErr != nil {
+		return nil, common.NewRuntimeError(basicErr)
+	}
+	if basicErr := contract.Call(opts, &round, "latestRoundData"); basicErr != nil {
+		return nil, common.NewRuntimeError(basicErr)
+	}
+
+	answer := round[1].(*big.Int)
+	updatedAt := time.Unix(round[3].(*big.Int).Int64(), 0)
+	if answer.Sign() <= 0 {
+		return nil, common.NewRuntimeError(fmt.Errorf("chainlink feed %s answered %s", feed.Address, answer.String()))
+	}
+	if time.Since(updatedAt) > feed.MaxAge {
+		return nil, common.NewRuntimeError(fmt.Errorf("chainlink feed %s stale since %s", feed.Address, updatedAt.Format(time.RFC3339)))
+	}
+
+	return &USDPrice{
+		Price:     decimal.NewFromBigInt(answer, -int32(decimals[0].(uint8))),
+		Source:    USDPriceSourceChainlink,
+		UpdatedAt: updatedAt,
+	}, nil
+}
+
+// poolTWAP drops the samples older than twapWindow and returns the time weighted average of the rest.
+func (c *chainUSDPrice) poolTWAP(now time.Time) *USDPrice {
+	samples := c.samples
+	for len(samples) > 0 && now.Sub(samples[0].At) > twapWindow {
+		samples = samples[1:]
+	}
+	c.samples = samples
+
+	if len(samples) < 2 || now.Sub(samples[len(samples)-1].At) > twapMaxSampleAge || now.Sub(samples[0].At) < twapMinSpan {
+		return nil
+	}
+
+	// each sample holds until the next one, the last holds until now
+	weighted := decimal.Zero
+	for i, sample := range samples {
+		end := now
+		if i+1 < len(samples) {
+			end = samples[i+1].At
+		}
+		weighted = weighted.Add(sample.Price.Mul(decimal.NewFromInt(int64(end.Sub(sample.At)))))
+	}
+
+	return &USDPrice{
+		Price:     weighted.Div(decimal.NewFromInt(int64(now.Sub(samples[0].At)))),
+		Source:    USDPriceSourcePoolTWAP,
+		UpdatedAt: samples[len(samples)-1].At,
+	}
+}
+
+func newUSDPriceOracle(gethService evm.GethService, quoteTokens *quoteTokenService) *usdPriceOracle {
+	return &usdPriceOracle{
+		aggregatorAbi: readAbiResource("./resource/chainlink_aggregator_abi.json"),
+		gethService:   gethService,
+		quoteTokens:   quoteTokens,
+		chains:        map[string]*chainUSDPrice{},
+	}
+}